@@ -0,0 +1,195 @@
+package zabbix_sender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Regexp is a global regular expression sent with active checks, referenced
+// from log item keys as "@name".
+type Regexp struct {
+	Name             string `json:"name"`
+	Expression       string `json:"expression"`
+	ExpressionType   int    `json:"expression_type"`
+	ExcludeDelimiter string `json:"exclude_delimiter"`
+	CaseSensitive    int    `json:"case_sensitive"`
+}
+
+// ActiveCheck is an item the server asks an active agent to collect.
+type ActiveCheck struct {
+	Key         string `json:"key"`
+	KeyOrig     string `json:"key_orig,omitempty"`
+	ItemID      uint64 `json:"itemid,omitempty"`
+	Delay       int    `json:"-"` // seconds
+	LastLogSize int64  `json:"lastlogsize"`
+	Mtime       int64  `json:"mtime"`
+
+	// Regexps are the global regular expressions the key references ("@name"),
+	// the filters a log item must apply.
+	Regexps []Regexp `json:"-"`
+}
+
+// UnmarshalJSON decodes an active check, accepting delay as a number or a
+// string with an optional time suffix ("30", "30s", "5m").
+func (c *ActiveCheck) UnmarshalJSON(data []byte) error {
+	type activeCheck ActiveCheck
+	aux := struct {
+		*activeCheck
+		Delay json.RawMessage `json:"delay"`
+	}{activeCheck: (*activeCheck)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	delay, err := parseDelay(aux.Delay)
+	if err != nil {
+		return fmt.Errorf("item %s: %w", c.Key, err)
+	}
+	c.Delay = delay
+	return nil
+}
+
+// parseDelay parses an active check delay into seconds.
+func parseDelay(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+
+	var n int
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("invalid delay %s", raw)
+	}
+
+	s = strings.TrimSpace(s)
+	multiplier := 1
+	if s != "" {
+		switch s[len(s)-1] {
+		case 's':
+			s = s[:len(s)-1]
+		case 'm':
+			multiplier, s = 60, s[:len(s)-1]
+		case 'h':
+			multiplier, s = 3600, s[:len(s)-1]
+		case 'd':
+			multiplier, s = 86400, s[:len(s)-1]
+		case 'w':
+			multiplier, s = 604800, s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delay %s", raw)
+	}
+	return n * multiplier, nil
+}
+
+// ActiveChecks decodes the items of an "active checks" response.
+// Each check gets the global regexps its key references.
+func (r *Response) ActiveChecks() ([]ActiveCheck, error) {
+	if r.Response != "success" {
+		return nil, fmt.Errorf("Can not process active checks if response not Success (%s)", r.Response)
+	}
+	if len(r.Data) == 0 {
+		return nil, nil
+	}
+
+	var checks []ActiveCheck
+	if err := json.Unmarshal(r.Data, &checks); err != nil {
+		return nil, fmt.Errorf("decoding active checks: %w", err)
+	}
+
+	for i := range checks {
+		for _, re := range r.Regexp {
+			if referencesRegexp(checks[i].Key, re.Name) {
+				checks[i].Regexps = append(checks[i].Regexps, re)
+			}
+		}
+	}
+
+	return checks, nil
+}
+
+// referencesRegexp reports whether key references the global regexp name as "@name".
+func referencesRegexp(key, name string) bool {
+	ref := "@" + name
+	for i := strings.Index(key, ref); i >= 0; {
+		end := i + len(ref)
+		if end == len(key) || strings.IndexByte(`,]" `, key[end]) >= 0 {
+			return true
+		}
+		next := strings.Index(key[end:], ref)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+// DuplicateChecks is the policy of GetActiveChecks for an item key the server
+// lists more than once, e.g. with different delays, see Sender.DuplicateActiveChecks.
+type DuplicateChecks int
+
+const (
+	// DuplicateChecksKeepAll returns every entry, as listed by the server.
+	DuplicateChecksKeepAll DuplicateChecks = iota
+	// DuplicateChecksLastWins returns one check per key: the last entry, at the
+	// position of the first one, so each key has a single delay to schedule.
+	DuplicateChecksLastWins
+	// DuplicateChecksError fails with ErrDuplicateActiveCheck.
+	DuplicateChecksError
+)
+
+// ErrDuplicateActiveCheck is returned by GetActiveChecks when the server lists
+// an item key twice and Sender.DuplicateActiveChecks is DuplicateChecksError.
+var ErrDuplicateActiveCheck = errors.New("duplicate active check")
+
+// dedupe applies the policy to checks.
+func (p DuplicateChecks) dedupe(checks []ActiveCheck) ([]ActiveCheck, error) {
+	if p == DuplicateChecksKeepAll {
+		return checks, nil
+	}
+
+	index := make(map[string]int, len(checks))
+	unique := checks[:0:0]
+	for _, c := range checks {
+		i, seen := index[c.Key]
+		switch {
+		case !seen:
+			index[c.Key] = len(unique)
+			unique = append(unique, c)
+		case p == DuplicateChecksError:
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateActiveCheck, c.Key)
+		default:
+			unique[i] = c
+		}
+	}
+	return unique, nil
+}
+
+// GetActiveChecks requests the active checks of host ("active checks") and returns
+// its items. Keys listed more than once are handled per Sender.DuplicateActiveChecks.
+func (s *Sender) GetActiveChecks(host, hostmetadata string) ([]ActiveCheck, error) {
+	p := &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
+
+	res, err := s.Send(p)
+	if err != nil {
+		return nil, fmt.Errorf("sending packet: %w", err)
+	}
+
+	checks, err := res.ActiveChecks()
+	if err != nil {
+		return nil, err
+	}
+	return s.DuplicateActiveChecks.dedupe(checks)
+}
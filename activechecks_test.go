@@ -0,0 +1,225 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetActiveChecks(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	responses := []string{
+		`{"response":"success","data":[{"key":"net.if.in[eth0]","delay":60,"lastlogsize":0,"mtime":0},` +
+			`{"key":"logrt[/var/log/app-.*.log]","delay":30,"lastlogsize":52310,"mtime":1700000000}]}`,
+		`{"response":"failed","info":"host [unknown] not found"}`,
+	}
+	done := make(chan error, 1)
+	go func() {
+		for _, jsonResp := range responses {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			request, err := mock.readZabbixRequest(conn)
+			if err == nil && request.Request != "active checks" {
+				err = fmt.Errorf("expected 'active checks', got '%s'", request.Request)
+			}
+			if err == nil {
+				err = mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	s := NewSender(mock.address)
+	checks, err := s.GetActiveChecks("prueba", "prueba")
+	if err != nil {
+		t.Fatalf("error getting active checks: %v", err)
+	}
+	expected := []ActiveCheck{
+		{Key: "net.if.in[eth0]", Delay: 60},
+		{Key: "logrt[/var/log/app-.*.log]", Delay: 30, LastLogSize: 52310, Mtime: 1700000000},
+	}
+	if len(checks) != len(expected) {
+		t.Fatalf("expected %d active checks, got %+v", len(expected), checks)
+	}
+	for i := range expected {
+		c, e := checks[i], expected[i]
+		if c.Key != e.Key || c.Delay != e.Delay || c.LastLogSize != e.LastLogSize || c.Mtime != e.Mtime {
+			t.Errorf("check %d: expected %+v, got %+v", i, e, c)
+		}
+	}
+
+	// A host without active checks is rejected
+	var rejected *ServerRejectedError
+	if _, err := s.GetActiveChecks("unknown", ""); !errors.As(err, &rejected) {
+		t.Errorf("expected a rejection, got %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestGetActiveChecksLogFilters(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		if request.Request != "active checks" || request.Host != "MyAgent" {
+			done <- fmt.Errorf("expected 'active checks' for MyAgent, got '%s' for '%s'", request.Request, request.Host)
+			return
+		}
+
+		jsonResp := `{"response":"success",` +
+			`"data":[` +
+			`{"key":"log[/var/log/app.log,@errors,,,skip]","key_orig":"log[/var/log/app.log,@errors,,,skip]","itemid":1234,"delay":"30s","lastlogsize":2048,"mtime":0},` +
+			`{"key":"agent.ping","itemid":1235,"delay":60,"lastlogsize":0,"mtime":0}],` +
+			`"regexp":[` +
+			`{"name":"errors","expression":"error|fatal","expression_type":3,"exclude_delimiter":",","case_sensitive":0},` +
+			`{"name":"errors_old","expression":"ERR","expression_type":0,"exclude_delimiter":",","case_sensitive":1}]}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(mock.address)
+	checks, err := s.GetActiveChecks("MyAgent", "Linux")
+	if err != nil {
+		t.Fatalf("error getting active checks: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	if len(checks) != 2 {
+		t.Fatalf("expected 2 active checks, got %d", len(checks))
+	}
+
+	logItem := checks[0]
+	if logItem.Key != "log[/var/log/app.log,@errors,,,skip]" {
+		t.Errorf("Key: unexpected %s", logItem.Key)
+	}
+	if logItem.ItemID != 1234 {
+		t.Errorf("ItemID: expected 1234, got %d", logItem.ItemID)
+	}
+	if logItem.Delay != 30 {
+		t.Errorf("Delay: expected 30, got %d", logItem.Delay)
+	}
+	if logItem.LastLogSize != 2048 {
+		t.Errorf("LastLogSize: expected 2048, got %d", logItem.LastLogSize)
+	}
+	if len(logItem.Regexps) != 1 {
+		t.Fatalf("expected 1 referenced regexp, got %d", len(logItem.Regexps))
+	}
+	re := logItem.Regexps[0]
+	if re.Name != "errors" || re.Expression != "error|fatal" || re.ExpressionType != 3 || re.ExcludeDelimiter != "," || re.CaseSensitive != 0 {
+		t.Errorf("unexpected regexp %+v", re)
+	}
+
+	ping := checks[1]
+	if ping.Delay != 60 {
+		t.Errorf("Delay: expected 60, got %d", ping.Delay)
+	}
+	if len(ping.Regexps) != 0 {
+		t.Errorf("expected no regexps for agent.ping, got %v", ping.Regexps)
+	}
+}
+
+func TestParseDelay(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int
+	}{
+		{`60`, 60},
+		{`"60"`, 60},
+		{`"30s"`, 30},
+		{`"5m"`, 300},
+		{`"1h"`, 3600},
+		{`"1d"`, 86400},
+		{`"1w"`, 604800},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDelay([]byte(tt.raw))
+		if err != nil {
+			t.Errorf("parseDelay(%s): unexpected error %v", tt.raw, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseDelay(%s): expected %d, got %d", tt.raw, tt.expected, got)
+		}
+	}
+
+	if _, err := parseDelay([]byte(`"soon"`)); err == nil {
+		t.Error("expected error for invalid delay")
+	}
+}
+
+func TestGetActiveChecksDuplicates(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				mock.writeZabbixResponse(conn, `{"response":"success","data":[`+
+					`{"key":"agent.ping","delay":60},`+
+					`{"key":"system.uptime","delay":300},`+
+					`{"key":"agent.ping","delay":"30s"}]}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+
+	checks, err := s.GetActiveChecks("MyAgent", "")
+	if err != nil {
+		t.Fatalf("error getting active checks: %v", err)
+	}
+	if len(checks) != 3 {
+		t.Errorf("keep all: expected 3 checks, got %d", len(checks))
+	}
+
+	s.DuplicateActiveChecks = DuplicateChecksLastWins
+	checks, err = s.GetActiveChecks("MyAgent", "")
+	if err != nil {
+		t.Fatalf("error getting active checks: %v", err)
+	}
+	if len(checks) != 2 || checks[0].Key != "agent.ping" || checks[0].Delay != 30 || checks[1].Key != "system.uptime" {
+		t.Errorf("last wins: expected agent.ping every 30s then system.uptime, got %+v", checks)
+	}
+
+	s.DuplicateActiveChecks = DuplicateChecksError
+	if _, err := s.GetActiveChecks("MyAgent", ""); !errors.Is(err, ErrDuplicateActiveCheck) || !strings.Contains(err.Error(), "agent.ping") {
+		t.Errorf("error: expected ErrDuplicateActiveCheck for agent.ping, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package zabbix_sender
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAgentConfigDepth bounds the nesting of Include directives, against loops.
+const maxAgentConfigDepth = 10
+
+// NewSenderFromConfig creates a Sender from a Zabbix agent configuration file,
+// e.g. /etc/zabbix/zabbix_agentd.conf, and returns it with the first Hostname
+// of the file, empty when unset. The parameters used are:
+//
+//	ServerActive                      hosts, comma separated, a port defaults to 10051;
+//	                                  the nodes of a cluster are separated by ";"
+//	Hostname                          the returned host name
+//	Timeout                           seconds, for the connect, read and write timeouts
+//	SourceIP                          local address of the connections
+//	TLSConnect                        unencrypted or cert
+//	TLSCAFile, TLSCertFile, TLSKeyFile,
+//	TLSServerCertIssuer, TLSServerCertSubject
+//	                                  with TLSConnect=cert
+//
+// Include directives are followed. The agent sends to each ServerActive entry,
+// here they all become failover Hosts; use Broadcast to deliver to each of them.
+// TLSConnect=psk fails with ErrPSKUnsupported, the Sender could not send: set
+// TLSPSKIdentity, TLSPSKKey and PSKHandshake on a Sender instead.
+func NewSenderFromConfig(path string) (*Sender, string, error) {
+	params := make(map[string]string)
+	if err := readAgentConfig(path, params, 0); err != nil {
+		return nil, "", err
+	}
+
+	s, err := senderFromAgentConfig(params)
+	if err != nil {
+		return nil, "", fmt.Errorf("agent config %s: %w", path, err)
+	}
+	hostname, _, _ := strings.Cut(params["Hostname"], ",")
+	return s, strings.TrimSpace(hostname), nil
+}
+
+// readAgentConfig reads the "Name=Value" parameters of the agent configuration
+// file path into params, a later value replacing an earlier one.
+func readAgentConfig(path string, params map[string]string, depth int) error {
+	if depth > maxAgentConfigDepth {
+		return fmt.Errorf("agent config %s: includes nested too deeply", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("agent config: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("agent config %s:%d: expected Name=Value, got %q", path, line, text)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		if name == "Include" {
+			if err := includeAgentConfig(value, params, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		params[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("agent config %s: %w", path, err)
+	}
+	return nil
+}
+
+// includeAgentConfig reads the files of an Include directive: a file, the files
+// of a directory or the files matching a pattern, in name order.
+func includeAgentConfig(include string, params map[string]string, depth int) error {
+	paths := []string{include}
+	if info, err := os.Stat(include); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(include)
+		if err != nil {
+			return fmt.Errorf("agent config: %w", err)
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(include, entry.Name()))
+			}
+		}
+	} else if strings.ContainsAny(include, "*?[") {
+		if paths, err = filepath.Glob(include); err != nil {
+			return fmt.Errorf("agent config: Include %s: %w", include, err)
+		}
+	}
+
+	for _, path := range paths {
+		if err := readAgentConfig(path, params, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// senderFromAgentConfig creates a Sender from the agent parameters.
+func senderFromAgentConfig(params map[string]string) (*Sender, error) {
+	hosts, err := parseServerActive(params["ServerActive"])
+	if err != nil {
+		return nil, err
+	}
+	s := NewSenderHosts(hosts)
+
+	if v := params["Timeout"]; v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 || seconds > 30 {
+			return nil, fmt.Errorf("Timeout %q: expected 1 to 30 seconds", v)
+		}
+		timeout := time.Duration(seconds) * time.Second
+		s.ConnectTimeout, s.ReadTimeout, s.WriteTimeout = timeout, timeout, timeout
+	}
+
+	if v := params["SourceIP"]; v != "" {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("SourceIP %q: not an IP address", v)
+		}
+		s.Dialer = &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}
+	}
+
+	switch tlsConnect := params["TLSConnect"]; tlsConnect {
+	case "", "unencrypted":
+	case "psk":
+		return nil, fmt.Errorf("TLSConnect=psk: %w", ErrPSKUnsupported)
+	case "cert":
+		if s.TLSConfig, err = agentTLSConfig(params); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("TLSConnect %q: expected unencrypted or cert", tlsConnect)
+	}
+	return s, nil
+}
+
+// parseServerActive returns the hosts of a ServerActive value: comma separated
+// entries, each a host with an optional port or the ";" separated nodes of a cluster.
+func parseServerActive(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, errors.New("ServerActive is not set")
+	}
+
+	var hosts []string
+	for _, entry := range strings.Split(value, ",") {
+		for _, node := range strings.Split(entry, ";") {
+			if node = strings.TrimSpace(node); node == "" {
+				return nil, fmt.Errorf("ServerActive %q: empty host", value)
+			}
+			hosts = append(hosts, node)
+		}
+	}
+	return hosts, nil
+}
+
+// agentTLSConfig returns the TLS configuration of TLSConnect=cert. As the agent
+// does, the server certificate is verified against TLSCAFile and, when set, its
+// issuer and subject, not against the host name.
+func agentTLSConfig(params map[string]string) (*tls.Config, error) {
+	caFile, certFile, keyFile := params["TLSCAFile"], params["TLSCertFile"], params["TLSKeyFile"]
+	if caFile == "" || certFile == "" || keyFile == "" {
+		return nil, errors.New("TLSConnect=cert requires TLSCAFile, TLSCertFile and TLSKeyFile")
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLSCAFile: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("TLSCAFile %s: no certificates", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLSCertFile: %w", err)
+	}
+
+	issuer, subject := params["TLSServerCertIssuer"], params["TLSServerCertSubject"]
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true, // verified by VerifyConnection, without the host name
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			leaf := cs.PeerCertificates[0]
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			if _, err := leaf.Verify(opts); err != nil {
+				return err
+			}
+			if issuer != "" && leaf.Issuer.String() != issuer {
+				return fmt.Errorf("server certificate issuer %q, expected %q", leaf.Issuer, issuer)
+			}
+			if subject != "" && leaf.Subject.String() != subject {
+				return fmt.Errorf("server certificate subject %q, expected %q", leaf.Subject, subject)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,127 @@
+package zabbix_sender
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAgentConfig writes content to name in dir and returns its path.
+func writeAgentConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewSenderFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "zabbix_agentd.d"), 0o700)
+	writeAgentConfig(t, filepath.Join(dir, "zabbix_agentd.d"), "timeout.conf", "Timeout=7\n")
+	path := writeAgentConfig(t, dir, "zabbix_agentd.conf", `# Zabbix agent
+Server=127.0.0.1
+ServerActive=zabbix-proxy1,zabbix-proxy2:10052;[2001:db8::1]:10053
+Hostname=web-01,web-01-alias
+   # indented comment
+
+Include=`+filepath.Join(dir, "zabbix_agentd.d")+`
+`)
+
+	s, hostname, err := NewSenderFromConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hostname != "web-01" {
+		t.Errorf("expected hostname web-01, got %q", hostname)
+	}
+	expected := []string{"zabbix-proxy1:10051", "zabbix-proxy2:10052", "[2001:db8::1]:10053"}
+	if strings.Join(s.Hosts, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected hosts %v, got %v", expected, s.Hosts)
+	}
+	if s.ConnectTimeout != 7*time.Second || s.ReadTimeout != 7*time.Second || s.WriteTimeout != 7*time.Second {
+		t.Errorf("expected the included Timeout, got %v", s)
+	}
+
+	// no PSK implementation: the configuration is rejected, not a Sender that can not send
+	psk := writeAgentConfig(t, dir, "psk.conf", "ServerActive=proxy1\nTLSConnect=psk\nTLSPSKIdentity=PSK web-01\nTLSPSKFile=/etc/zabbix/agent.psk\n")
+	if _, _, err := NewSenderFromConfig(psk); !errors.Is(err, ErrPSKUnsupported) {
+		t.Errorf("expected ErrPSKUnsupported, got %v", err)
+	}
+}
+
+func TestNewSenderFromConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"no ServerActive": "Server=127.0.0.1\n",
+		"empty host":      "ServerActive=proxy1,,proxy2\n",
+		"empty node":      "ServerActive=proxy1;\n",
+		"no equals":       "ServerActive proxy1\n",
+		"timeout":         "ServerActive=proxy1\nTimeout=60\n",
+		"source ip":       "ServerActive=proxy1\nSourceIP=eth0\n",
+		"tls connect":     "ServerActive=proxy1\nTLSConnect=maybe\n",
+		"cert without ca": "ServerActive=proxy1\nTLSConnect=cert\n",
+		"include loop":    "ServerActive=proxy1\nInclude=" + filepath.Join(dir, "include loop.conf") + "\n",
+	} {
+		path := writeAgentConfig(t, dir, name+".conf", content)
+		if _, _, err := NewSenderFromConfig(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, _, err := NewSenderFromConfig(filepath.Join(dir, "missing.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestNewSenderFromConfigCert(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	mock := newMockTLSZabbixServer(t, cert)
+	defer mock.Close()
+
+	dir := t.TempDir()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certFile := writeAgentConfig(t, dir, "agent.crt", string(certPEM))
+	keyFile := writeAgentConfig(t, dir, "agent.key", string(keyPEM))
+
+	config := func(subject string) string {
+		return writeAgentConfig(t, dir, "zabbix_agentd.conf", "ServerActive="+mock.address+"\n"+
+			"TLSConnect=cert\nTLSCAFile="+certFile+"\nTLSCertFile="+certFile+"\nTLSKeyFile="+keyFile+"\n"+
+			"TLSServerCertSubject="+subject+"\n")
+	}
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	done := make(chan error, 1)
+	go serveOnce(mock, done)
+	s, _, err := NewSenderFromConfig(config("CN=zabbix test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("error sending with the agent certificate: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	go serveOnce(mock, done)
+	s, _, err = NewSenderFromConfig(config("CN=other"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Send(packet); err == nil || !strings.Contains(err.Error(), "subject") {
+		t.Errorf("expected a subject mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BroadcastResult is the outcome of a Broadcast to one host.
+type BroadcastResult struct {
+	Host     string
+	Response Response
+	Err      error
+}
+
+// Broadcast sends packet to every host concurrently, instead of only the first
+// working one, e.g. to feed parallel Zabbix installations. Redirects are followed
+// per host and PrimaryHost is left untouched.
+//
+// The packet is marshalled once and the same wire bytes are sent to each host;
+// only hosts with a different compression mode (see CompressAuto) get their own
+// framing. The results are in host order. The error is only set when the packet
+// can not be sent at all.
+func (s *Sender) Broadcast(ctx context.Context, packet *Packet) ([]BroadcastResult, error) {
+	if packet.isEmptyData() {
+		return nil, fmt.Errorf("broadcasting %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+
+	enc, err := s.encodePacket(packet, s.packetCorrelationID(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, logical, err := s.resolveHosts()
+	if len(hosts) == 0 && err != nil {
+		return nil, fmt.Errorf("broadcasting packet: %w", err)
+	}
+	return s.broadcast(ctx, enc, hosts, logical), nil
+}
+
+// broadcast sends enc to each of hosts concurrently, logical are the configured
+// hosts they were resolved from.
+func (s *Sender) broadcast(ctx context.Context, enc *EncodedPacket, hosts, logical []string) []BroadcastResult {
+	tmo := s.timeouts(Timeouts{})
+	results := make([]BroadcastResult, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(r *BroadcastResult, host string, hostCtx context.Context) {
+			defer wg.Done()
+			r.Host = host
+			r.Response, _, r.Err = s.sendWithRedirects(hostCtx, enc, host, tmo)
+		}(&results[i], host, withHost(ctx, logical[i]))
+	}
+	wg.Wait()
+
+	return results
+}
+
+// BroadcastGroups sends packet to each HA group concurrently, one Sender per
+// group. Each group delivers it once, to its first working host like Send does;
+// the result of a group has the host that answered. The results are in group order.
+//
+// The packet is encoded for each group before any send starts, the metrics are
+// not accessed concurrently and the value of a NewMetricReader metric is read once.
+func BroadcastGroups(ctx context.Context, packet *Packet, groups ...*Sender) []BroadcastResult {
+	results := make([]BroadcastResult, len(groups))
+	encs := make([]*EncodedPacket, len(groups))
+	for i, s := range groups {
+		if packet.isEmptyData() {
+			results[i].Err = fmt.Errorf("broadcasting %q packet: %w", packet.Request, ErrEmptyPacket)
+			continue
+		}
+		encs[i], results[i].Err = s.encodePacket(packet, s.packetCorrelationID(ctx))
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range groups {
+		if encs[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *BroadcastResult, s *Sender, enc *EncodedPacket) {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				r.Err = fmt.Errorf("sending packet: %w", err)
+				return
+			}
+			r.Response, r.Host, r.Err = s.sendEncodedHost(ctx, enc, s.timeouts(Timeouts{}))
+		}(&results[i], s, encs[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// SendParallel sends packet to all hosts concurrently and returns the first
+// success response, canceling the sends still in flight, so an unreachable host
+// does not delay the send by its timeouts. Like Send, hosts in their HostCooldown
+// are skipped, the host that succeeded is cached as PrimaryHost (see UpdateHost)
+// and RetryOnFailedInfo applies. A slower host may still store the packet before
+// it is canceled; only use it where an occasional duplicate is acceptable.
+//
+// When no host succeeds, the first clean rejection is returned like Send does,
+// otherwise a *SendError with the attempt of each host, in host order.
+func (s *Sender) SendParallel(packet *Packet) (Response, error) {
+	return s.SendParallelContext(context.Background(), packet)
+}
+
+// SendParallelContext is like SendParallel but bounds the sends by ctx.
+func (s *Sender) SendParallelContext(ctx context.Context, packet *Packet) (res Response, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("sending packet: %w", err)
+	}
+	if packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+
+	enc, err := s.encodePacket(packet, s.packetCorrelationID(ctx))
+	if err != nil {
+		return res, err
+	}
+
+	var host string
+	ctx = withPacketCorrelationID(ctx, enc.packet)
+	defer func() { s.logOutcome(ctx, enc.packet, host, res, err) }()
+
+	tmo := s.timeouts(Timeouts{})
+	res, host, err = s.retryFailedInfo(ctx, func() (Response, string, error) {
+		return s.sendParallel(ctx, enc, tmo)
+	})
+	return res, err
+}
+
+// sendParallel sends enc to the available hosts concurrently, like sendToHosts
+// it records the health of each host and caches the host that succeeded. host is
+// the host that answered, the last one redirected to, when one did.
+func (s *Sender) sendParallel(ctx context.Context, enc *EncodedPacket, tmo Timeouts) (res Response, host string, err error) {
+	hosts, logical, err := s.resolveHosts()
+	if len(hosts) == 0 {
+		if err != nil {
+			return res, "", fmt.Errorf("sending packet: %w", err)
+		}
+		return res, "", fmt.Errorf("sending packet: no hosts configured")
+	}
+	hosts, logical = s.availableHosts(hosts, logical)
+
+	type result struct {
+		index     int
+		res       Response
+		redirects []string
+		err       error
+	}
+
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(hosts))
+	for i, host := range hosts {
+		go func(i int, host string) {
+			res, redirects, err := s.sendWithRedirects(withHost(sendCtx, logical[i]), enc, host, tmo)
+			results <- result{i, res, redirects, err}
+		}(i, host)
+	}
+
+	attempts := make([]HostAttempt, len(hosts))
+	var rejected *result
+	for range hosts {
+		r := <-results
+		host := hosts[r.index]
+		s.recordHost(sendCtx, host, r.err)
+		if r.err == nil {
+			s.cacheHost(host, r.redirects)
+			return r.res, finalHost(host, r.redirects), nil
+		}
+
+		var rejectedErr *ServerRejectedError
+		if rejected == nil && errors.As(r.err, &rejectedErr) {
+			rejected = &r
+		}
+		attempts[r.index] = HostAttempt{Host: host, Redirects: r.redirects, Err: r.err}
+		s.sendLogger(ctx).Warnf("sending to host %s failed: %v", host, r.err)
+	}
+
+	if rejected != nil {
+		return rejected.res, finalHost(hosts[rejected.index], rejected.redirects), rejected.err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return res, "", fmt.Errorf("sending packet: %w", ctxErr)
+	}
+	return res, "", &SendError{Hosts: len(hosts), Attempts: attempts}
+}
+
+// SendQuorum sends packet to the hosts in order until k distinct hosts confirmed
+// it with a success response, for metrics that must not depend on a single server.
+// Hosts reached through redirects count as the host they redirected to. It returns
+// the last success response, or an error when fewer than k hosts confirmed.
+//
+// Hosts in their HostCooldown are skipped and PrimaryHost is left untouched.
+func (s *Sender) SendQuorum(packet *Packet, k int) (Response, error) {
+	return s.SendQuorumContext(context.Background(), packet, k)
+}
+
+// SendQuorumContext is like SendQuorum but bounds the sends by ctx.
+func (s *Sender) SendQuorumContext(ctx context.Context, packet *Packet, k int) (res Response, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("sending packet: %w", err)
+	}
+	if packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+	if k < 1 {
+		k = 1
+	}
+
+	enc, err := s.encodePacket(packet, s.packetCorrelationID(ctx))
+	if err != nil {
+		return res, err
+	}
+
+	var last string
+	ctx = withPacketCorrelationID(ctx, enc.packet)
+	defer func() { s.logOutcome(ctx, enc.packet, last, res, err) }()
+
+	hosts, logical, resolveErr := s.resolveHosts()
+	available, availableLogical := s.availableHosts(hosts, logical)
+
+	tmo := s.timeouts(Timeouts{})
+	confirmed := make(map[string]bool, k)
+	var attempts []HostAttempt
+	for i, host := range available {
+		if ctx.Err() != nil {
+			break
+		}
+		hostCtx := withHost(ctx, availableLogical[i])
+		var redirects []string
+		hostRes, _, err := s.retryFailedInfo(ctx, func() (res Response, _ string, err error) {
+			res, redirects, err = s.sendWithRedirects(hostCtx, enc, host, tmo)
+			return res, "", err
+		})
+		s.recordHost(ctx, host, err)
+		if err != nil {
+			attempts = append(attempts, HostAttempt{Host: host, Redirects: redirects, Err: err})
+			s.sendLogger(ctx).Warnf("sending to host %s failed: %v", host, err)
+			continue
+		}
+
+		last = finalHost(host, redirects)
+		confirmed[normalizeHost(last)] = true
+		res = hostRes
+		if len(confirmed) >= k {
+			return res, nil
+		}
+	}
+
+	cause := resolveErr
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		cause = ctxErr
+	} else if len(attempts) > 0 {
+		cause = &SendError{Hosts: len(available), Attempts: attempts}
+	}
+	if cause == nil {
+		return res, fmt.Errorf("quorum of %d hosts not reached: %d of %d hosts confirmed", k, len(confirmed), len(hosts))
+	}
+	return res, fmt.Errorf("quorum of %d hosts not reached: %d of %d hosts confirmed: %w", k, len(confirmed), len(hosts), cause)
+}
@@ -0,0 +1,304 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveBroadcastMock answers every request with success, counting the metrics received.
+func serveBroadcastMock(mock *mockZabbixServer, received *int32) {
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(received, int32(len(request.Data)))
+			jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, len(request.Data), len(request.Data))
+			mock.writeZabbixResponse(conn, jsonResp)
+		}()
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	var received [2]int32
+	var hosts []string
+	for i := range received {
+		mock := newMockZabbixServer(t)
+		defer mock.Close()
+		go serveBroadcastMock(mock, &received[i])
+		hosts = append(hosts, mock.address)
+	}
+	dead := newMockZabbixServer(t)
+	dead.Close()
+	hosts = append(hosts, dead.address)
+
+	s := NewSenderHosts(hosts)
+	s.IncludeCorrelationID = true
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	results, err := s.Broadcast(WithCorrelationID(context.Background(), "req-7f3a"), packet)
+	if err != nil {
+		t.Fatalf("error broadcasting: %v", err)
+	}
+	if packet.CorrelationID != "" {
+		t.Errorf("the caller's packet was modified: %+v", packet)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if r.Host != hosts[i] {
+			t.Errorf("result %d: expected host %s, got %s", i, hosts[i], r.Host)
+		}
+	}
+	for i := range received {
+		if results[i].Err != nil || results[i].Response.Response != "success" {
+			t.Errorf("host %d: expected success, got %v", i, results[i].Err)
+		}
+		if n := atomic.LoadInt32(&received[i]); n != 1 {
+			t.Errorf("host %d: expected 1 metric, got %d", i, n)
+		}
+	}
+	if results[2].Err == nil {
+		t.Error("expected an error for the unreachable host")
+	}
+	if s.PrimaryHost != "" {
+		t.Errorf("Broadcast should not cache a primary host, got %s", s.PrimaryHost)
+	}
+}
+
+func TestBroadcastGroups(t *testing.T) {
+	var received [4]int32
+	var groups []*Sender
+	for g := 0; g < 2; g++ {
+		var hosts []string
+		for h := 0; h < 2; h++ {
+			mock := newMockZabbixServer(t)
+			defer mock.Close()
+			go serveBroadcastMock(mock, &received[g*2+h])
+			hosts = append(hosts, mock.address)
+		}
+		groups = append(groups, NewSenderHosts(hosts))
+	}
+
+	// The groups send concurrently, a reader backed value must be shared safely
+	packet := NewPacket([]*Metric{
+		NewMetric("zabbixTrapper1", "ping", "13", false),
+		NewMetricReader("zabbixTrapper1", "log", strings.NewReader("started"), false),
+	}, false)
+	results := BroadcastGroups(context.Background(), packet, groups...)
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 group results, got %d", len(results))
+	}
+	for g, r := range results {
+		if r.Err != nil || r.Response.Response != "success" {
+			t.Errorf("group %d: expected success, got %v", g, r.Err)
+		}
+		if r.Host != groups[g].Hosts[0] {
+			t.Errorf("group %d: expected first host %s, got %s", g, groups[g].Hosts[0], r.Host)
+		}
+	}
+	// One delivery per group, to its first host
+	for i, expected := range []int32{2, 0, 2, 0} {
+		if n := atomic.LoadInt32(&received[i]); n != expected {
+			t.Errorf("host %d: expected %d metrics, got %d", i, expected, n)
+		}
+	}
+}
+
+func TestSendQuorum(t *testing.T) {
+	var received [3]int32
+	var hosts []string
+	for i := range received {
+		mock := newMockZabbixServer(t)
+		defer mock.Close()
+		go serveBroadcastMock(mock, &received[i])
+		hosts = append(hosts, mock.address)
+	}
+
+	s := NewSenderHosts(hosts)
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "alert", "1", false)}, false)
+
+	res, err := s.SendQuorum(packet, 2)
+	if err != nil {
+		t.Fatalf("expected quorum of 2: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %s", res.Response)
+	}
+	for i, expected := range []int32{1, 1, 0} {
+		if n := atomic.LoadInt32(&received[i]); n != expected {
+			t.Errorf("host %d: expected %d metrics, got %d", i, expected, n)
+		}
+	}
+
+	// Only the first host is reachable
+	dead := newMockZabbixServer(t)
+	dead.Close()
+	s = NewSenderHosts([]string{hosts[0], dead.address})
+
+	_, err = s.SendQuorum(packet, 2)
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || len(sendErr.Attempts) != 1 || sendErr.Attempts[0].Host != dead.address {
+		t.Errorf("expected quorum error with the failed host attempt, got %v", err)
+	}
+	if h := s.HostStatus(); h[1].ConsecutiveFailures != 1 {
+		t.Errorf("expected the failure of %s to be recorded, got %+v", dead.address, h[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.SendQuorumContext(ctx, packet, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSendParallel(t *testing.T) {
+	// The first host accepts but never answers, like a hung proxy
+	hung := newMockZabbixServer(t)
+	defer hung.Close()
+	go func() {
+		for {
+			conn, err := hung.listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	var received int32
+	working := newMockZabbixServer(t)
+	defer working.Close()
+	go serveBroadcastMock(working, &received)
+
+	s := NewSenderHosts([]string{hung.address, working.address})
+	s.ReadTimeout = 5 * time.Second
+
+	start := time.Now()
+	res, err := s.SendParallel(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error sending in parallel: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the hung host not to delay the send, took %v", elapsed)
+	}
+	if res.Response != "success" || atomic.LoadInt32(&received) != 1 {
+		t.Errorf("expected success from the working host, got %s", res.Response)
+	}
+	if s.PrimaryHost != working.address {
+		t.Errorf("expected PrimaryHost %s, got %s", working.address, s.PrimaryHost)
+	}
+}
+
+func TestSendParallelAllFail(t *testing.T) {
+	var hosts []string
+	for i := 0; i < 2; i++ {
+		dead := newMockZabbixServer(t)
+		dead.Close()
+		hosts = append(hosts, dead.address)
+	}
+
+	s := NewSenderHosts(hosts)
+	_, err := s.SendParallel(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("expected *SendError, got %v", err)
+	}
+	if len(sendErr.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(sendErr.Attempts))
+	}
+	for i, a := range sendErr.Attempts {
+		if a.Host != hosts[i] || a.Err == nil {
+			t.Errorf("attempt %d: expected failure of %s, got %+v", i, hosts[i], a)
+		}
+	}
+
+	// The failures are recorded like Send does
+	for _, h := range s.HostStatus() {
+		if h.ConsecutiveFailures != 1 {
+			t.Errorf("expected 1 recorded failure of %s, got %d", h.Host, h.ConsecutiveFailures)
+		}
+	}
+}
+
+func benchmarkMetrics() []*Metric {
+	metrics := make([]*Metric, 200)
+	for i := range metrics {
+		metrics[i] = NewMetric("zabbixTrapper1", fmt.Sprintf("item[%d]", i), fmt.Sprintf("%d.5", i), false)
+	}
+	return metrics
+}
+
+// BenchmarkEncodeTargets compares building the wire bytes for N identical
+// targets once (as Broadcast does) against once per target.
+func BenchmarkEncodeTargets(b *testing.B) {
+	const targets = 8
+	s := NewSender("localhost")
+	packet := NewPacket(benchmarkMetrics(), false)
+
+	b.Run("single marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc, err := s.EncodePacket(packet)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for t := 0; t < targets; t++ {
+				enc.frame(false)
+			}
+		}
+	})
+
+	b.Run("per target", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for t := 0; t < targets; t++ {
+				if _, _, err := s.frame(packet, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	const targets = 4
+	var received int32
+	var hosts []string
+	for i := 0; i < targets; i++ {
+		mock := newMockZabbixServer(b)
+		defer mock.Close()
+		go serveBroadcastMock(mock, &received)
+		hosts = append(hosts, mock.address)
+	}
+
+	s := NewSenderHosts(hosts)
+	packet := NewPacket(benchmarkMetrics(), false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := s.Broadcast(context.Background(), packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				b.Fatal(r.Err)
+			}
+		}
+	}
+}
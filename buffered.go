@@ -0,0 +1,157 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBufferClosed is returned when adding metrics to a BufferedSender after Shutdown.
+var ErrBufferClosed = errors.New("buffered sender is shut down")
+
+// BufferedSender accumulates metrics and sends them in batches through a Sender.
+// A batch is flushed when maxBatch metrics are pending or every flush interval.
+// The metrics of a batch that failed with a transient error are kept for the next
+// flush; the failures of periodic flushes are logged through the Sender Logger.
+type BufferedSender struct {
+	sender   *Sender
+	maxBatch int
+
+	mu      sync.Mutex
+	pending []*Metric
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBufferedSender creates a BufferedSender on top of s.
+// maxBatch <= 0 disables size based flushing, interval <= 0 disables periodic flushing.
+func NewBufferedSender(s *Sender, maxBatch int, interval time.Duration) *BufferedSender {
+	b := &BufferedSender{
+		sender:   s,
+		maxBatch: maxBatch,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop(interval)
+	return b
+}
+
+// loop flushes pending metrics periodically until Shutdown.
+func (b *BufferedSender) loop(interval time.Duration) {
+	defer close(b.done)
+	if interval <= 0 {
+		<-b.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				b.sender.logger().Warnf("buffered sender: %v", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add queues metrics for sending. The batch is flushed inline once maxBatch is reached.
+func (b *BufferedSender) Add(metrics ...*Metric) error {
+	for _, m := range metrics {
+		if m == nil {
+			return fmt.Errorf("%w: nil metric", ErrInvalidMetric)
+		}
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBufferClosed
+	}
+	b.pending = append(b.pending, metrics...)
+	full := b.maxBatch > 0 && len(b.pending) >= b.maxBatch
+	b.mu.Unlock()
+
+	if full {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush sends all pending metrics.
+func (b *BufferedSender) Flush() error {
+	return b.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush but bounds the sends by ctx. The metrics of a
+// category that failed are pending again, unless the failure is permanent, e.g.
+// the server rejected the packet: those are dropped with the error.
+func (b *BufferedSender) FlushContext(ctx context.Context) error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	_, errActive, _, errTrapper := b.sender.SendMetricsContext(ctx, batch)
+	if errActive == nil && errTrapper == nil {
+		return nil
+	}
+
+	var kept []*Metric
+	if errActive != nil && !permanentError(errActive) {
+		kept = append(kept, categoryMetrics(batch, true)...)
+	}
+	if errTrapper != nil && !permanentError(errTrapper) {
+		kept = append(kept, categoryMetrics(batch, false)...)
+	}
+	if len(kept) > 0 {
+		b.mu.Lock()
+		b.pending = append(kept, b.pending...)
+		b.mu.Unlock()
+	}
+	return fmt.Errorf("flushing %d metrics, %d kept: %w", len(batch), len(kept), errors.Join(errActive, errTrapper))
+}
+
+// Shutdown stops accepting new metrics and flushes the pending ones, bounded by
+// ctx: it returns when the flush is done or ctx expires, whichever comes first.
+// The error reports the metrics that could not be sent. Those of a transient
+// failure, and all of them when ctx expired before the flush, stay pending (see
+// Len): Shutdown can be called again to retry the flush.
+func (b *BufferedSender) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	b.mu.Unlock()
+
+	// Wait for a periodic flush in flight
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown: %d metrics pending: %w", b.Len(), ctx.Err())
+	}
+
+	if err := b.FlushContext(ctx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of pending metrics.
+func (b *BufferedSender) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
@@ -0,0 +1,167 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferedSenderShutdownFlushes(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan int, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		received <- len(request.Data)
+
+		jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, len(request.Data), len(request.Data))
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	b := NewBufferedSender(NewSender(mock.address), 100, 0)
+
+	if err := b.Add(NewMetric("zabbixTrapper1", "ping", "1", false), NewMetric("zabbixTrapper1", "pong", "2", false)); err != nil {
+		t.Fatalf("error adding metrics: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should flush without error: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	if n := <-received; n != 2 {
+		t.Errorf("expected 2 flushed metrics, got %d", n)
+	}
+
+	if err := b.Add(NewMetric("zabbixTrapper1", "late", "3", false)); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("expected ErrBufferClosed after Shutdown, got %v", err)
+	}
+}
+
+func TestBufferedSenderShutdownContextExpires(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// Accept but never answer, so the flush blocks on read
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second)
+	}()
+
+	s := NewSender(mock.address)
+	s.ReadTimeout = 2 * time.Second
+	b := NewBufferedSender(s, 0, 0)
+	b.Add(NewMetric("zabbixTrapper1", "ping", "1", false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if n := b.Len(); n != 1 {
+		t.Fatalf("expected the metric still pending, got %d", n)
+	}
+
+	// A second Shutdown flushes it again
+	var received int32
+	other := newMockZabbixServer(t)
+	defer other.Close()
+	go serveBroadcastMock(other, &received)
+	s.Hosts = []string{other.address}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("second Shutdown failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&received); n != 1 || b.Len() != 0 {
+		t.Errorf("expected the pending metric delivered, got %d delivered, %d pending", n, b.Len())
+	}
+}
+
+func TestBufferedSenderKeepsFailedMetrics(t *testing.T) {
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	s := NewSender(dead.address)
+	b := NewBufferedSender(s, 0, 0)
+	b.Add(NewMetric("zabbixTrapper1", "ping", "1", false), NewMetric("zabbixTrapper1", "pong", "2", true))
+
+	if err := b.Flush(); err == nil {
+		t.Fatal("expected an error flushing to an unreachable host")
+	}
+	if len(b.pending) != 2 {
+		t.Fatalf("expected the 2 metrics to be kept, got %d", len(b.pending))
+	}
+
+	// The next flush delivers them
+	var received int32
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	go serveBroadcastMock(mock, &received)
+	s.Hosts = []string{mock.address}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should flush the kept metrics: %v", err)
+	}
+	if n := atomic.LoadInt32(&received); n != 2 {
+		t.Errorf("expected 2 delivered metrics, got %d", n)
+	}
+
+	if err := b.Add(nil); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("expected ErrInvalidMetric for a nil metric, got %v", err)
+	}
+}
+
+// expiredCtx has a deadline in the past but does not report it yet, as a ctx
+// whose timer did not fire while a connection deadline capped by it expired.
+type expiredCtx struct{ context.Context }
+
+func (expiredCtx) Deadline() (time.Time, bool) {
+	return time.Now().Add(-time.Millisecond), true
+}
+
+func TestContextErrReportsPassedDeadline(t *testing.T) {
+	ctx := expiredCtx{context.Background()}
+	if err := contextErr(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := contextErr(context.Background()); err != nil {
+		t.Errorf("expected no error without deadline, got %v", err)
+	}
+
+	// A send failing at the deadline reports it, not the connection error
+	dead := newMockZabbixServer(t)
+	dead.Close()
+	_, err := NewSender(dead.address).SendContext(ctx, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)}, false))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package zabbix_sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// sendMetricsPacket sends metrics as an "agent data" or "sender data" packet.
+// With MaxPacketBytes set they are split into several packets sent one after
+// the other, and the response aggregates their statistics with one part per packet.
+// A failed packet stops the send: the packets before it were stored, the failed
+// response then has the responses so far in Parts.
+func (s *Sender) sendMetricsPacket(ctx context.Context, metrics []*Metric, active bool, tmo Timeouts) (Response, error) {
+	return s.sendChunked(ctx, metrics, active, func(packet *Packet) (Response, error) {
+		return s.send(ctx, packet, tmo)
+	})
+}
+
+// sendChunked is sendMetricsPacket sending each packet with send.
+func (s *Sender) sendChunked(ctx context.Context, metrics []*Metric, active bool, send func(*Packet) (Response, error)) (Response, error) {
+	if s.MaxPacketBytes <= 0 {
+		return send(NewPacket(metrics, active))
+	}
+
+	batches, err := s.chunkMetrics(ctx, NewPacket(nil, active).Request, metrics)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(batches) == 1 {
+		return send(NewPacket(batches[0], active))
+	}
+
+	parts := make([]Response, 0, len(batches))
+	for i, batch := range batches {
+		res, err := send(NewPacket(batch, active))
+		parts = append(parts, res)
+		if err != nil {
+			res.Parts = parts
+			return res, fmt.Errorf("sending packet %d of %d: %w", i+1, len(batches), err)
+		}
+	}
+	return aggregateResponses(parts), nil
+}
+
+// chunkMetrics splits metrics into batches whose verb packet, as encoded by
+// EncodePacket, is at most MaxPacketBytes of JSON. A metric exceeding the limit
+// on its own is sent alone.
+func (s *Sender) chunkMetrics(ctx context.Context, verb string, metrics []*Metric) ([][]*Metric, error) {
+	envelope := Packet{Request: verb, Client: s.ClientName, CorrelationID: s.packetCorrelationID(ctx)}
+	data, err := json.Marshal(&envelope)
+	if err != nil {
+		return nil, fmt.Errorf("encoding packet: %w", err)
+	}
+	overhead := len(data) + len(`,"data":[]`)
+
+	var batches [][]*Metric
+	var batch []*Metric
+	size := overhead
+	for _, m := range metrics {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("encoding packet: %w", err)
+		}
+		n := len(data)
+		if len(batch) > 0 {
+			n++ // separating comma
+		}
+		if len(batch) > 0 && size+n > s.MaxPacketBytes {
+			batches = append(batches, batch)
+			batch, size, n = nil, overhead, len(data)
+		}
+		batch = append(batch, m)
+		size += n
+	}
+	return append(batches, batch), nil
+}
@@ -0,0 +1,128 @@
+package zabbix_sender
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSendMetricsMaxPacketBytes(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	const limit = 300
+	var sizes []int
+	var keys []string
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 13)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				conn.Close()
+				continue
+			}
+			data := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+			if _, err := io.ReadFull(conn, data); err != nil {
+				conn.Close()
+				continue
+			}
+			var request ZabbixRequest
+			json.Unmarshal(data, &request)
+			sizes = append(sizes, len(data))
+			for _, d := range request.Data {
+				keys = append(keys, d.Key)
+			}
+			n := len(request.Data)
+			mock.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000100"}`, n, n))
+			conn.Close()
+		}
+	}()
+
+	var metrics []*Metric
+	for i := 0; i < 20; i++ {
+		metrics = append(metrics, NewMetric("zabbixTrapper1", fmt.Sprintf("key%02d", i), "42", false))
+	}
+
+	s := NewSender(mock.address)
+	s.MaxPacketBytes = limit
+	_, _, resTrapper, errTrapper := s.SendMetrics(metrics)
+	if errTrapper != nil {
+		t.Fatalf("unexpected error: %v", errTrapper)
+	}
+
+	if len(sizes) < 2 {
+		t.Fatalf("expected multiple packets, got %d", len(sizes))
+	}
+	for i, size := range sizes {
+		if size > limit {
+			t.Errorf("packet %d is %d bytes, over the %d limit", i, size, limit)
+		}
+	}
+	if len(resTrapper.Parts) != len(sizes) {
+		t.Errorf("expected %d parts, got %d", len(sizes), len(resTrapper.Parts))
+	}
+
+	info, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("unexpected info %q: %v", resTrapper.Info, err)
+	}
+	if info.Processed != len(metrics) || info.Total != len(metrics) {
+		t.Errorf("expected processed and total %d, got %+v", len(metrics), info)
+	}
+	for i, key := range keys {
+		if want := fmt.Sprintf("key%02d", i); key != want {
+			t.Fatalf("expected %s at %d, got %s", want, i, key)
+		}
+	}
+	if len(keys) != len(metrics) {
+		t.Errorf("expected %d metrics sent, got %d", len(metrics), len(keys))
+	}
+}
+
+func TestSendPacketSizeLimit(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	s := NewSender(mock.address)
+	s.MaxPacketBytes = 128
+	large := NewMetric("zabbixTrapper1", "log", strings.Repeat("x", 256), false)
+	small := NewMetric("zabbixTrapper1", "ping", "1", false)
+
+	// Chunking can not split a single metric, it is sent alone
+	if _, _, _, errTrapper := s.SendMetrics([]*Metric{small, large}); errTrapper != nil {
+		t.Fatalf("unexpected error: %v", errTrapper)
+	}
+	if n := atomic.LoadInt32(&received); n != 2 {
+		t.Fatalf("expected both metrics sent, got %d", n)
+	}
+
+	s.PacketSizeLimit = 128
+	_, err := s.Send(NewPacket([]*Metric{large}, false))
+	if !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("expected ErrPacketTooLarge, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "the limit is 128") {
+		t.Errorf("expected the limit in %v", err)
+	}
+	_, _, _, errTrapper := s.SendMetrics([]*Metric{small, large})
+	if !errors.Is(errTrapper, ErrPacketTooLarge) {
+		t.Fatalf("expected ErrPacketTooLarge for the large metric, got %v", errTrapper)
+	}
+
+	if _, err := s.Send(NewPacket([]*Metric{small}, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&received); n != 4 {
+		t.Errorf("expected only the small packets written, got %d metrics", n)
+	}
+}
@@ -0,0 +1,109 @@
+package zabbix_sender
+
+import "time"
+
+// SenderConfig is a snapshot of the effective settings of a Sender, see Sender.Config.
+type SenderConfig struct {
+	Hosts        []string // normalized host:port addresses
+	PrimaryHost  string
+	MaxRedirects int
+	UpdateHost   bool
+
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	ClientName            string
+	UseLocalHostname      bool
+	Compression           bool
+	CompressMinBytes      int
+	CompressAuto          bool
+	MaxPacketBytes        int
+	PacketSizeLimit       int
+	KeepAlive             bool
+	StrictValidation      bool
+	MaxUniqueKeys         int
+	DuplicateActiveChecks DuplicateChecks
+	IncludeCorrelationID  bool
+
+	SuccessIfAnyProcessed bool
+	SampleRate            float64
+	CaptureUnknownFields  bool
+	RedirectsInGroupOnly  bool
+	RedirectAllowlist     []string
+
+	TLS               bool   // TLSConfig or TLSConfigForHost is set
+	TLSPSKIdentity    string // the PSK itself is never included
+	Resolver          bool   // a Resolver is set
+	Dialer            bool   // a custom Dialer is set
+	DialContext       bool   // a DialContext hook is set
+	Transforms        int    // number of Transforms
+	OnSend            bool   // an OnSend hook is set
+	OnWire            bool   // an OnWire hook is set
+	OnRedirect        bool   // an OnRedirect hook is set
+	Logger            bool   // a Logger is set
+	RetryOnFailedInfo bool   // a RetryOnFailedInfo predicate is set
+
+	HostCooldown         time.Duration
+	RetryPolicy          RetryPolicy
+	FailedInfoRetries    int // effective, defaults applied
+	FailedInfoRetryDelay time.Duration
+}
+
+// Config returns a snapshot of the effective settings of s, e.g. to log at startup.
+// Changing the snapshot does not affect s.
+func (s *Sender) Config() SenderConfig {
+	hosts := make([]string, len(s.Hosts))
+	for i, h := range s.Hosts {
+		hosts[i] = normalizeHost(h)
+	}
+
+	c := SenderConfig{
+		Hosts:                 hosts,
+		PrimaryHost:           s.primaryHost(),
+		MaxRedirects:          s.MaxRedirects,
+		UpdateHost:            s.UpdateHost,
+		ConnectTimeout:        s.ConnectTimeout,
+		ReadTimeout:           s.ReadTimeout,
+		WriteTimeout:          s.WriteTimeout,
+		ClientName:            s.ClientName,
+		UseLocalHostname:      s.UseLocalHostname,
+		Compression:           s.Compression,
+		CompressMinBytes:      s.CompressMinBytes,
+		CompressAuto:          s.CompressAuto,
+		MaxPacketBytes:        s.MaxPacketBytes,
+		PacketSizeLimit:       s.PacketSizeLimit,
+		KeepAlive:             s.KeepAlive,
+		StrictValidation:      s.StrictValidation,
+		MaxUniqueKeys:         s.MaxUniqueKeys,
+		DuplicateActiveChecks: s.DuplicateActiveChecks,
+		IncludeCorrelationID:  s.IncludeCorrelationID,
+		SuccessIfAnyProcessed: s.SuccessIfAnyProcessed,
+		SampleRate:            s.SampleRate,
+		CaptureUnknownFields:  s.CaptureUnknownFields,
+		RedirectsInGroupOnly:  s.RedirectsInGroupOnly,
+		RedirectAllowlist:     append([]string(nil), s.RedirectAllowlist...),
+		TLS:                   s.TLSConfig != nil || s.TLSConfigForHost != nil,
+		TLSPSKIdentity:        s.TLSPSKIdentity,
+		Resolver:              s.Resolver != nil,
+		Dialer:                s.Dialer != nil,
+		DialContext:           s.DialContext != nil,
+		Transforms:            len(s.Transforms),
+		OnSend:                s.OnSend != nil,
+		OnWire:                s.OnWire != nil,
+		OnRedirect:            s.OnRedirect != nil,
+		Logger:                s.Logger != nil,
+		RetryOnFailedInfo:     s.RetryOnFailedInfo != nil,
+		HostCooldown:          s.HostCooldown,
+		RetryPolicy:           s.RetryPolicy,
+		FailedInfoRetries:     s.FailedInfoRetries,
+		FailedInfoRetryDelay:  s.FailedInfoRetryDelay,
+	}
+	if c.FailedInfoRetries <= 0 {
+		c.FailedInfoRetries = defaultFailedInfoRetries
+	}
+	if c.FailedInfoRetryDelay <= 0 {
+		c.FailedInfoRetryDelay = defaultFailedInfoRetryDelay
+	}
+	return c
+}
@@ -0,0 +1,43 @@
+package zabbix_sender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSenderConfig(t *testing.T) {
+	s := NewSenderHosts([]string{"proxy1", "proxy2:10052", "2001:db8::1"})
+	s.Hosts = append(s.Hosts, " proxy3 ") // set directly, not normalized yet
+	s.Compression = true
+	s.Transforms = []func(*Metric) *Metric{func(m *Metric) *Metric { return m }}
+
+	c := s.Config()
+
+	expectedHosts := []string{"proxy1:10051", "proxy2:10052", "[2001:db8::1]:10051", "proxy3:10051"}
+	if len(c.Hosts) != len(expectedHosts) {
+		t.Fatalf("expected hosts %v, got %v", expectedHosts, c.Hosts)
+	}
+	for i := range expectedHosts {
+		if c.Hosts[i] != expectedHosts[i] {
+			t.Errorf("host %d: expected %s, got %s", i, expectedHosts[i], c.Hosts[i])
+		}
+	}
+	if c.ConnectTimeout != defaultConnectTimeout || c.ReadTimeout != defaultReadTimeout || c.WriteTimeout != defaultWriteTimeout {
+		t.Errorf("unexpected timeouts %v %v %v", c.ConnectTimeout, c.ReadTimeout, c.WriteTimeout)
+	}
+	if c.MaxRedirects != defaultMaxRedirects || !c.Compression || c.CompressAuto {
+		t.Errorf("unexpected flags %+v", c)
+	}
+	if c.Transforms != 1 || c.Resolver || c.OnSend {
+		t.Errorf("unexpected hooks %+v", c)
+	}
+	if c.FailedInfoRetries != 1 || c.FailedInfoRetryDelay != 100*time.Millisecond {
+		t.Errorf("expected retry defaults applied, got %d %v", c.FailedInfoRetries, c.FailedInfoRetryDelay)
+	}
+
+	// The snapshot is a copy
+	c.Hosts[0] = "changed"
+	if s.Hosts[0] != "proxy1:10051" {
+		t.Errorf("changing the snapshot should not affect the sender, got %s", s.Hosts[0])
+	}
+}
@@ -0,0 +1,51 @@
+package zabbix_sender
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// SendOverConn sends packet over conn, e.g. a pre-established tunnel, and reads
+// the response. conn is neither dialed nor closed: the caller owns its lifecycle
+// and may reuse it. The WriteTimeout and ReadTimeout deadlines are cleared on return.
+//
+// Redirects are not followed, a redirect is returned as a *ServerRejectedError
+// with Response.Redirect set for the caller to handle.
+func (s *Sender) SendOverConn(conn net.Conn, packet *Packet) (res Response, err error) {
+	if packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+	buffer, _, err := s.frame(packet, s.Compression)
+	if err != nil {
+		return res, err
+	}
+
+	host := "connection"
+	if addr := conn.RemoteAddr(); addr != nil {
+		host = addr.String()
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	if err := writeFrame(conn, buffer); err != nil {
+		return res, fmt.Errorf("sending the data to %s (timeout=%v): %w", host, s.WriteTimeout, err)
+	}
+	s.onWire(WireWrite, buffer)
+
+	// readFrame reads no further than the frame, conn stays usable for the next one
+	conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	response, err := readFrame(conn)
+	if err != nil {
+		return res, fmt.Errorf("reading the response from %s (timeout=%v): %w", host, s.ReadTimeout, err)
+	}
+	s.onWire(WireRead, response)
+
+	if res, err = s.decode(response, host); err != nil {
+		return res, err
+	}
+	if res.Response != "success" {
+		return res, rejectedError(res, host)
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSendOverConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	mock := &mockZabbixServer{t: t}
+	requests := make(chan *ZabbixRequest, 2)
+	go func() {
+		responses := []string{
+			`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`,
+			`{"response":"failed","redirect":{"revision":1,"address":"10.0.0.2:10051"}}`,
+		}
+		for _, response := range responses {
+			request, err := mock.readZabbixRequest(server)
+			if err != nil {
+				close(requests)
+				return
+			}
+			requests <- request
+			mock.writeZabbixResponse(server, response)
+		}
+	}()
+
+	s := NewSender("unused:10051")
+	res, err := s.SendOverConn(client, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, _ := res.GetInfo(); info.Processed != 1 {
+		t.Errorf("expected 1 processed, got %q", res.Info)
+	}
+	request := <-requests
+	if request == nil || request.Request != "sender data" || len(request.Data) != 1 || request.Data[0].Key != "ping" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	// The connection stays open and a redirect is left to the caller
+	res, err = s.SendOverConn(client, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "pong", "14", false)}, false))
+	var rejected *ServerRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("expected a rejection, got %v", err)
+	}
+	if res.Redirect == nil || res.Redirect.Address != "10.0.0.2:10051" {
+		t.Errorf("expected the redirect in the response, got %+v", res.Redirect)
+	}
+	if request := <-requests; request == nil || request.Data[0].Key != "pong" {
+		t.Errorf("unexpected second request %+v", request)
+	}
+}
@@ -0,0 +1,226 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrItemTypeMismatch is returned when the server reports that an item is configured
+// with a different type than the data sent (trapper value for an active item or vice versa).
+var ErrItemTypeMismatch = errors.New("item type mismatch: check the metric Active flag against the item type")
+
+// ErrEmptyPacket is returned when sending a data packet without metrics.
+// SendMetrics without metrics sends nothing and is not an error.
+var ErrEmptyPacket = errors.New("data packet without metrics")
+
+// ErrInconsistentInfo is returned by GetInfoOptions when the response statistics
+// do not add up (total != processed + failed), a sign of a misbehaving proxy.
+var ErrInconsistentInfo = errors.New("inconsistent response info: total != processed + failed")
+
+// ErrSendPending is reported for the category still in flight when
+// SendMetricsOptions.FirstSuccess returns early.
+var ErrSendPending = errors.New("send not finished: returned on first successful category")
+
+// ErrPSKUnsupported is returned when TLSPSKIdentity or TLSPSKKey is set without a
+// PSKHandshake, and by NewSenderFromConfig for TLSConnect=psk: the standard
+// library does not implement TLS PSK cipher suites.
+var ErrPSKUnsupported = errors.New("TLS PSK requires a PSKHandshake implementation")
+
+// ErrRedirectNotAllowed is returned when Sender.RedirectsInGroupOnly is set and
+// a server redirects outside of Hosts and RedirectAllowlist.
+var ErrRedirectNotAllowed = errors.New("redirect outside of the host group")
+
+// ErrRedirectLoop is returned when a server redirects to a host already visited
+// while sending the packet, e.g. two proxies of a group redirecting to each other.
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// ErrTooManyKeys is returned by SendMetrics when a batch has more distinct keys
+// than Sender.MaxUniqueKeys.
+var ErrTooManyKeys = errors.New("too many distinct keys in batch")
+
+// ErrPacketTooLarge is returned, before anything is sent, for a packet with more
+// JSON data than Sender.PacketSizeLimit.
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// ErrAllHostsFailed is matched by errors.Is when a send failed on every host,
+// see SendError.
+var ErrAllHostsFailed = errors.New("all hosts failed")
+
+// ErrInvalidHeader is returned when a frame does not start with the "ZBXD" header.
+var ErrInvalidHeader = errors.New("invalid header")
+
+// ErrResponseTooShort is returned when a response ends before its 13 byte header does.
+var ErrResponseTooShort = errors.New("response too short")
+
+// ErrRegistrationFailed is returned by RegisterHost when the server rejects the
+// host, e.g. it does not exist and autoregistration did not create it.
+var ErrRegistrationFailed = errors.New("autoregistration failed")
+
+// ErrServerReported is matched by errors.Is when a server answered "failed"
+// without redirect, see ServerRejectedError for the Info it reported.
+var ErrServerReported = errors.New("server reported failure")
+
+// ErrResponseTooLarge is returned when a response header declares more data, or
+// more decompressed data, than the 1 GiB a Sender accepts, before allocating it.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// ErrTruncatedResponse is returned when the connection ends before the response
+// body reaches the length declared in its header.
+var ErrTruncatedResponse = errors.New("truncated response")
+
+// permanentError reports whether a resend can not change the outcome of a send
+// that failed with err: the server rejected the packet, or the metrics or the
+// packet are invalid. Connection errors and timeouts are transient. Rejections
+// reported as transient by RetryOnFailedInfo were already retried by the Sender.
+func permanentError(err error) bool {
+	var rejected *ServerRejectedError
+	var mismatch *ItemTypeMismatchError
+	return errors.As(err, &rejected) || errors.As(err, &mismatch) ||
+		errors.Is(err, ErrInvalidMetric) || errors.Is(err, ErrInvalidKey) ||
+		errors.Is(err, ErrTooManyKeys) || errors.Is(err, ErrPacketTooLarge) ||
+		errors.Is(err, ErrEmptyPacket) || errors.Is(err, ErrPSKUnsupported) ||
+		errors.Is(err, ErrRedirectNotAllowed)
+}
+
+// HostAttempt is one failed attempt of a send: the host tried, the redirects
+// followed from it and the final error.
+type HostAttempt struct {
+	Host      string
+	Redirects []string
+	Err       error
+}
+
+// SendError is returned by Send when all hosts failed. Error reports the last
+// failure; Detail, or formatting with %+v, lists every host attempted.
+// errors.Is and errors.As match the error of any attempt.
+type SendError struct {
+	Hosts    int // number of configured (resolved) hosts
+	Attempts []HostAttempt
+}
+
+func (e *SendError) Error() string {
+	if len(e.Attempts) == 0 {
+		return fmt.Sprintf("all %d hosts failed", e.Hosts)
+	}
+	return fmt.Sprintf("all %d hosts failed: %v", e.Hosts, e.Attempts[len(e.Attempts)-1].Err)
+}
+
+// Detail lists each host attempted with its redirect chain and error, one per line.
+func (e *SendError) Detail() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "all %d hosts failed:", e.Hosts)
+	for _, a := range e.Attempts {
+		fmt.Fprintf(&b, "\n  %s", a.Host)
+		for _, r := range a.Redirects {
+			fmt.Fprintf(&b, " -> %s", r)
+		}
+		fmt.Fprintf(&b, ": %v", a.Err)
+	}
+	return b.String()
+}
+
+// Format prints Detail for %+v and Error otherwise.
+func (e *SendError) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('+') {
+		fmt.Fprint(f, e.Detail())
+		return
+	}
+	fmt.Fprintf(f, fmt.FormatString(f, verb), e.Error())
+}
+
+// Is reports whether target is ErrAllHostsFailed and each of the hosts has a
+// failed attempt.
+func (e *SendError) Is(target error) bool {
+	if target != ErrAllHostsFailed {
+		return false
+	}
+	attempted := make(map[string]bool, len(e.Attempts))
+	for _, a := range e.Attempts {
+		attempted[a.Host] = true // the cached primary host may be attempted twice
+	}
+	return e.Hosts > 0 && len(attempted) == e.Hosts
+}
+
+// Unwrap returns the errors of all attempts.
+func (e *SendError) Unwrap() []error {
+	errs := make([]error, len(e.Attempts))
+	for i, a := range e.Attempts {
+		errs[i] = a.Err
+	}
+	return errs
+}
+
+// ServerRejectedError is returned with the populated Response when a server answers
+// with a clean non-success response (e.g. "failed") and no redirect.
+// It matches ErrServerReported; use errors.As to inspect Response.Info.
+type ServerRejectedError struct {
+	Host     string
+	Response Response
+}
+
+func (e *ServerRejectedError) Error() string {
+	if e.typeMismatch() {
+		return fmt.Sprintf("%s (from %s: %s)", ErrItemTypeMismatch, e.Host, e.Response.Info)
+	}
+	return fmt.Sprintf("failed without redirect from %s: %s (%s)", e.Host, e.Response.Response, e.Response.Info)
+}
+
+// Is reports whether target is ErrServerReported.
+func (e *ServerRejectedError) Is(target error) bool {
+	return target == ErrServerReported
+}
+
+// Unwrap exposes ErrItemTypeMismatch when the server reported an item type mismatch.
+func (e *ServerRejectedError) Unwrap() error {
+	if e.typeMismatch() {
+		return ErrItemTypeMismatch
+	}
+	return nil
+}
+
+// typeMismatch reports whether the info or a failed item of the response gives
+// an item type mismatch as the reason.
+func (e *ServerRejectedError) typeMismatch() bool {
+	return isItemTypeMismatch(e.Response.Info) || len(mismatchedItems(e.Response)) > 0
+}
+
+// ItemTypeMismatchError is returned with the populated Response when the server
+// processed a data packet, but reported items that failed because of an item
+// type mismatch. The other items were stored. It matches ErrItemTypeMismatch.
+type ItemTypeMismatchError struct {
+	Host     string
+	Response Response
+	Items    []ItemResult // the failed items with an item type mismatch
+}
+
+func (e *ItemTypeMismatchError) Error() string {
+	return fmt.Sprintf("%s: %d items from %s (%s)", ErrItemTypeMismatch, len(e.Items), e.Host, e.Response.Info)
+}
+
+// Unwrap returns ErrItemTypeMismatch.
+func (e *ItemTypeMismatchError) Unwrap() error {
+	return ErrItemTypeMismatch
+}
+
+// itemTypeMismatchReason is the reason the Zabbix server gives for a value sent
+// to an item whose type does not accept it, "Unsupported item type.", in the
+// per-item results of history.push. The sender protocol itself only counts such
+// items as failed, the mismatch can not be told from other failures there.
+const itemTypeMismatchReason = "unsupported item type"
+
+// isItemTypeMismatch reports whether reason describes an item type mismatch.
+func isItemTypeMismatch(reason string) bool {
+	return strings.Contains(strings.ToLower(reason), itemTypeMismatchReason)
+}
+
+// mismatchedItems returns the failed items of res with an item type mismatch as reason.
+func mismatchedItems(res Response) []ItemResult {
+	var items []ItemResult
+	for _, item := range res.Items {
+		if item.Failed() && isItemTypeMismatch(item.Reason) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,109 @@
+package zabbix_sender
+
+import (
+	"context"
+	"fmt"
+)
+
+// FindFailing sends metrics and identifies the ones the server counts as failed.
+//
+// The sender protocol only reports how many items failed, not which ones, so a
+// batch with failures is split in halves that are sent again until each failing
+// metric is isolated. Metrics of a split batch are therefore stored more than
+// once; only use it for items where duplicate values are harmless. A metric whose
+// failure was transient succeeds when resent and is not reported. The returned
+// metrics are the caller's, not the copies Transforms were applied to.
+func (s *Sender) FindFailing(ctx context.Context, metrics []*Metric) ([]*Metric, error) {
+	prepared, origin, err := s.prepareFailing(metrics)
+	if err != nil {
+		return nil, err
+	}
+	failing, err := s.findFailing(ctx, prepared)
+	return originMetrics(failing, origin), err
+}
+
+// SendMetricsRetryFailed sends metrics like FindFailing, then resends the failing
+// ones up to retries more times. It returns the metrics that still fail. Metrics
+// are prepared (Transforms, SampleRate) once, not again on each resend.
+func (s *Sender) SendMetricsRetryFailed(ctx context.Context, metrics []*Metric, retries int) ([]*Metric, error) {
+	prepared, origin, err := s.prepareFailing(metrics)
+	if err != nil {
+		return nil, err
+	}
+	failing, err := s.findFailing(ctx, prepared)
+	for i := 0; i < retries && err == nil && len(failing) > 0; i++ {
+		failing, err = s.findFailing(ctx, failing)
+	}
+	return originMetrics(failing, origin), err
+}
+
+// prepareFailing prepares and validates metrics as SendMetrics does and maps each
+// prepared metric to the caller's metric it was made from.
+func (s *Sender) prepareFailing(metrics []*Metric) ([]*Metric, map[*Metric]*Metric, error) {
+	origin := make(map[*Metric]*Metric, len(metrics))
+	prepared, err := s.prepareValid(metrics, origin)
+	return prepared, origin, err
+}
+
+// originMetrics returns the caller's metrics of the prepared metrics.
+func originMetrics(prepared []*Metric, origin map[*Metric]*Metric) []*Metric {
+	metrics := make([]*Metric, len(prepared))
+	for i, p := range prepared {
+		metrics[i] = origin[p]
+	}
+	return metrics
+}
+
+// findFailing is FindFailing for metrics already prepared.
+func (s *Sender) findFailing(ctx context.Context, metrics []*Metric) ([]*Metric, error) {
+	active, trapper := splitMetrics(metrics)
+
+	var failing []*Metric
+	for _, group := range []struct {
+		metrics []*Metric
+		active  bool
+	}{{trapper, false}, {active, true}} {
+		if len(group.metrics) == 0 {
+			continue
+		}
+		groupFailing, err := s.isolateFailing(ctx, group.metrics, group.active)
+		failing = append(failing, groupFailing...)
+		if err != nil {
+			return failing, err
+		}
+	}
+	return failing, nil
+}
+
+// isolateFailing sends metrics of one type and bisects them down to the failing ones.
+func (s *Sender) isolateFailing(ctx context.Context, metrics []*Metric, active bool) ([]*Metric, error) {
+	res, err := s.SendContext(ctx, NewPacket(metrics, active))
+	if err != nil {
+		return nil, err
+	}
+	info, err := res.GetInfo()
+	if err != nil {
+		return nil, fmt.Errorf("finding failing metrics: %w", err)
+	}
+
+	return s.bisectFailing(ctx, metrics, active, info.Failed)
+}
+
+// bisectFailing isolates the failing metrics of a batch of one type sent with
+// failed items counted failed by the server, without sending the whole batch again.
+func (s *Sender) bisectFailing(ctx context.Context, metrics []*Metric, active bool, failed int) ([]*Metric, error) {
+	switch {
+	case failed == 0:
+		return nil, nil
+	case failed >= len(metrics):
+		return metrics, nil
+	}
+
+	half := len(metrics) / 2
+	failing, err := s.isolateFailing(ctx, metrics[:half], active)
+	if err != nil {
+		return failing, err
+	}
+	right, err := s.isolateFailing(ctx, metrics[half:], active)
+	return append(failing, right...), err
+}
@@ -0,0 +1,131 @@
+package zabbix_sender
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// serveFailingMock fails every "bad" item, and each "flaky" item only the first time.
+func serveFailingMock(mock *mockZabbixServer, requests *int32) {
+	flakySeen := false
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		atomic.AddInt32(requests, 1)
+
+		failed := 0
+		for _, d := range request.Data {
+			switch {
+			case d.Key == "bad":
+				failed++
+			case d.Key == "flaky" && !flakySeen:
+				flakySeen = true
+				failed++
+			}
+		}
+
+		total := len(request.Data)
+		jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: %d; total: %d; seconds spent: 0.000030"}`, total-failed, failed, total)
+		mock.writeZabbixResponse(conn, jsonResp)
+		conn.Close()
+	}
+}
+
+func TestSendMetricsRetryFailed(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go serveFailingMock(mock, &requests)
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ok1", "1", false),
+		NewMetric("zabbixTrapper1", "flaky", "2", false),
+		NewMetric("zabbixTrapper1", "bad", "3", false),
+		NewMetric("zabbixTrapper1", "ok2", "4", false),
+	}
+
+	s := NewSender(mock.address)
+	failing, err := s.SendMetricsRetryFailed(context.Background(), metrics, 2)
+	if err != nil {
+		t.Fatalf("error sending metrics: %v", err)
+	}
+
+	if len(failing) != 1 || failing[0].Key != "bad" {
+		keys := make([]string, len(failing))
+		for i, m := range failing {
+			keys[i] = m.Key
+		}
+		t.Fatalf("expected only 'bad' to keep failing, got %v", keys)
+	}
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Error("expected the mock server to receive requests")
+	}
+}
+
+func TestFindFailingNoFailures(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go serveFailingMock(mock, &requests)
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ok1", "1", false),
+		NewMetric("zabbixTrapper1", "ok2", "2", false),
+	}
+
+	failing, err := NewSender(mock.address).FindFailing(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("error sending metrics: %v", err)
+	}
+	if len(failing) != 0 {
+		t.Errorf("expected no failing metrics, got %d", len(failing))
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected a single request without failures, got %d", n)
+	}
+}
+
+func TestSendMetricsRetryFailedTransformsOnce(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go serveFailingMock(mock, &requests)
+
+	var transforms int32
+	s := NewSender(mock.address)
+	s.Transforms = []func(*Metric) *Metric{func(m *Metric) *Metric {
+		atomic.AddInt32(&transforms, 1)
+		m.Key = m.Key[len("raw."):]
+		return m
+	}}
+
+	bad := NewMetric("zabbixTrapper1", "raw.bad", "3", false)
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "raw.ok1", "1", false),
+		bad,
+		NewMetric("zabbixTrapper1", "raw.ok2", "4", false),
+	}
+	failing, err := s.SendMetricsRetryFailed(context.Background(), metrics, 2)
+	if err != nil {
+		t.Fatalf("error sending metrics: %v", err)
+	}
+	if len(failing) != 1 || failing[0] != bad {
+		t.Fatalf("expected the caller's 'raw.bad' metric, got %v", failing)
+	}
+	if n := atomic.LoadInt32(&transforms); n != int32(len(metrics)) {
+		t.Errorf("expected each metric transformed once, got %d transforms", n)
+	}
+}
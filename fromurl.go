@@ -0,0 +1,109 @@
+package zabbix_sender
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewSenderFromURL creates a Sender from a single configuration string, e.g.
+// from an environment variable:
+//
+//	zbx://proxy1:10051,proxy2?connect_timeout=3s&compress=true
+//
+// The scheme selects the transport: "zbx" for plain TCP, "zbx+tls" for TLS
+// verified against the system CAs, "zbx+unix" for Unix domain sockets, e.g.
+// zbx+unix:///run/zabbix/proxy.sock. Hosts are comma separated, the port defaults
+// to 10051. Supported query options:
+//
+//	connect_timeout, read_timeout, write_timeout  durations, e.g. 3s
+//	max_redirects, compress_min_bytes,
+//	max_packet_bytes, packet_size_limit,
+//	max_unique_keys                               integers
+//	compress, compress_auto, use_local_hostname,
+//	strict_validation, update_host, keep_alive,
+//	redirects_in_group_only                       booleans
+//	client_name                                   string
+func NewSenderFromURL(s string) (*Sender, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return nil, fmt.Errorf("sender URL %q: missing scheme", s)
+	}
+	switch scheme {
+	case "zbx", "zbx+tls", "zbx+unix":
+	default:
+		return nil, fmt.Errorf("sender URL %q: unknown scheme %s", s, scheme)
+	}
+
+	hostList, rawQuery, _ := strings.Cut(rest, "?")
+	var hosts []string
+	for _, h := range strings.Split(hostList, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			return nil, fmt.Errorf("sender URL %q: empty host", s)
+		}
+		if scheme == "zbx+unix" {
+			h = unixPrefix + h
+		}
+		hosts = append(hosts, h)
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("sender URL %q: %w", s, err)
+	}
+
+	sender := NewSenderHosts(hosts)
+	if scheme == "zbx+tls" {
+		sender.TLSConfig = &tls.Config{}
+	}
+	for name, values := range query {
+		if err := sender.setURLOption(name, values[len(values)-1]); err != nil {
+			return nil, fmt.Errorf("sender URL %q: option %s: %w", s, name, err)
+		}
+	}
+	return sender, nil
+}
+
+// setURLOption applies one NewSenderFromURL query option.
+func (s *Sender) setURLOption(name, value string) (err error) {
+	switch name {
+	case "connect_timeout":
+		s.ConnectTimeout, err = time.ParseDuration(value)
+	case "read_timeout":
+		s.ReadTimeout, err = time.ParseDuration(value)
+	case "write_timeout":
+		s.WriteTimeout, err = time.ParseDuration(value)
+	case "max_redirects":
+		s.MaxRedirects, err = strconv.Atoi(value)
+	case "compress_min_bytes":
+		s.CompressMinBytes, err = strconv.Atoi(value)
+	case "max_packet_bytes":
+		s.MaxPacketBytes, err = strconv.Atoi(value)
+	case "packet_size_limit":
+		s.PacketSizeLimit, err = strconv.Atoi(value)
+	case "max_unique_keys":
+		s.MaxUniqueKeys, err = strconv.Atoi(value)
+	case "compress":
+		s.Compression, err = strconv.ParseBool(value)
+	case "compress_auto":
+		s.CompressAuto, err = strconv.ParseBool(value)
+	case "use_local_hostname":
+		s.UseLocalHostname, err = strconv.ParseBool(value)
+	case "strict_validation":
+		s.StrictValidation, err = strconv.ParseBool(value)
+	case "redirects_in_group_only":
+		s.RedirectsInGroupOnly, err = strconv.ParseBool(value)
+	case "keep_alive":
+		s.KeepAlive, err = strconv.ParseBool(value)
+	case "update_host":
+		s.UpdateHost, err = strconv.ParseBool(value)
+	case "client_name":
+		s.ClientName = value
+	default:
+		return fmt.Errorf("unknown option")
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package zabbix_sender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSenderFromURL(t *testing.T) {
+	s, err := NewSenderFromURL("zbx://proxy1:10052,proxy2?connect_timeout=3s&read_timeout=1m&compress=true&compress_min_bytes=1024&max_redirects=5&packet_size_limit=4096&client_name=billing%2F1.4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.Hosts) != 2 || s.Hosts[0] != "proxy1:10052" || s.Hosts[1] != "proxy2:10051" {
+		t.Errorf("unexpected hosts %v", s.Hosts)
+	}
+	if s.ConnectTimeout != 3*time.Second || s.ReadTimeout != time.Minute {
+		t.Errorf("unexpected timeouts connect=%v read=%v", s.ConnectTimeout, s.ReadTimeout)
+	}
+	if s.WriteTimeout != defaultWriteTimeout {
+		t.Errorf("WriteTimeout: expected default %v, got %v", defaultWriteTimeout, s.WriteTimeout)
+	}
+	if !s.Compression || s.CompressMinBytes != 1024 || s.MaxRedirects != 5 || s.PacketSizeLimit != 4096 {
+		t.Errorf("unexpected options %+v", s)
+	}
+	if s.ClientName != "billing/1.4" {
+		t.Errorf("ClientName: expected billing/1.4, got %s", s.ClientName)
+	}
+
+	s, err = NewSenderFromURL("zbx://[2001:db8::1]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Hosts) != 1 || s.Hosts[0] != "[2001:db8::1]:10051" {
+		t.Errorf("unexpected hosts %v", s.Hosts)
+	}
+	if s.TLSConfig != nil {
+		t.Error("expected plaintext for zbx scheme")
+	}
+
+	s, err = NewSenderFromURL("zbx+tls://proxy1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.TLSConfig == nil {
+		t.Error("expected TLS for zbx+tls scheme")
+	}
+
+	s, err = NewSenderFromURL("zbx+unix:///run/zabbix/proxy.sock?connect_timeout=1s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Hosts) != 1 || s.Hosts[0] != "unix:///run/zabbix/proxy.sock" {
+		t.Errorf("unexpected hosts %v", s.Hosts)
+	}
+}
+
+func TestNewSenderFromURLInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"proxy1:10051",
+		"http://proxy1",
+		"zbx+unix://",
+		"zbx://",
+		"zbx://proxy1,,proxy2",
+		"zbx://proxy1?connect_timeout=soon",
+		"zbx://proxy1?compress=maybe",
+		"zbx://proxy1?max_redirects=many",
+		"zbx://proxy1?unknown=1",
+		"zbx://proxy1?connect_timeout=%zz",
+	} {
+		if _, err := NewSenderFromURL(raw); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HostHealth is the health of one host as seen by a Sender, see Sender.HostStatus.
+type HostHealth struct {
+	Host                string
+	ConsecutiveFailures int
+	LastFailure         time.Time // zero when it never failed
+	LastSuccess         time.Time // zero when it never answered
+	LastError           string
+	InCooldown          bool // skipped by sends, see Sender.HostCooldown
+}
+
+// hostState is the tracked health of a host.
+type hostState struct {
+	failures    int
+	lastFailure time.Time
+	lastSuccess time.Time
+	lastErr     string
+}
+
+// recordHost updates the health of host after a send, unless it failed because
+// ctx ended; a rejection counts as the host being up.
+func (s *Sender) recordHost(ctx context.Context, host string, err error) {
+	var rejected *ServerRejectedError
+	switch {
+	case err == nil || errors.As(err, &rejected):
+		s.recordHostResult(host, nil)
+	case ctx.Err() == nil:
+		s.recordHostResult(host, err)
+	}
+}
+
+// recordHostResult records a success (nil err) or failure of host.
+func (s *Sender) recordHostResult(host string, err error) {
+	host = normalizeHost(host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hostStates == nil {
+		s.hostStates = make(map[string]*hostState)
+	}
+	st := s.hostStates[host]
+	if st == nil {
+		st = &hostState{}
+		s.hostStates[host] = st
+	}
+
+	now := time.Now()
+	if err == nil {
+		st.failures = 0
+		st.lastSuccess = now
+		return
+	}
+	st.failures++
+	st.lastFailure = now
+	st.lastErr = err.Error()
+}
+
+// inCooldown reports whether st failed less than cooldown ago. Callers hold s.mu.
+func (st *hostState) inCooldown(cooldown time.Duration, now time.Time) bool {
+	return cooldown > 0 && st != nil && st.failures > 0 && now.Sub(st.lastFailure) < cooldown
+}
+
+// availableHosts returns the hosts not in cooldown, with the configured hosts
+// they were resolved from. When all are in cooldown, all are returned.
+func (s *Sender) availableHosts(hosts, logical []string) ([]string, []string) {
+	if s.HostCooldown <= 0 {
+		return hosts, logical
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var available, availableLogical []string
+	for i, h := range hosts {
+		if !s.hostStates[normalizeHost(h)].inCooldown(s.HostCooldown, now) {
+			available = append(available, h)
+			availableLogical = append(availableLogical, logical[i])
+		}
+	}
+	if len(available) == 0 {
+		return hosts, logical
+	}
+	return available, availableLogical
+}
+
+// Ping checks that the hosts accept connections, without sending anything: it
+// connects to each one concurrently (with TLS when configured) and closes the
+// connection. The results update HostStatus. It returns nil when at least one
+// host is reachable, or a *SendError with the failure of each host.
+func (s *Sender) Ping(ctx context.Context) error {
+	hosts, logical, err := s.resolveHosts()
+	if len(hosts) == 0 {
+		if err != nil {
+			return fmt.Errorf("pinging hosts: %w", err)
+		}
+		return fmt.Errorf("pinging hosts: no hosts configured")
+	}
+
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			conn, err := s.dial(withHost(ctx, logical[i]), host, s.ConnectTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			s.recordHost(ctx, host, err)
+			errs[i] = err
+		}(i, host)
+	}
+	wg.Wait()
+
+	var attempts []HostAttempt
+	for i, err := range errs {
+		if err == nil {
+			return nil
+		}
+		attempts = append(attempts, HostAttempt{Host: hosts[i], Err: err})
+	}
+	return &SendError{Hosts: len(hosts), Attempts: attempts}
+}
+
+// HostStatus returns a snapshot of the health of the configured Hosts, followed
+// by other hosts sent to (resolved addresses, redirect targets) in address order.
+func (s *Sender) HostStatus() []HostHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	health := func(host string) HostHealth {
+		h := HostHealth{Host: host}
+		if st := s.hostStates[host]; st != nil {
+			h.ConsecutiveFailures = st.failures
+			h.LastFailure = st.lastFailure
+			h.LastSuccess = st.lastSuccess
+			h.LastError = st.lastErr
+			h.InCooldown = st.inCooldown(s.HostCooldown, now)
+		}
+		return h
+	}
+
+	seen := make(map[string]bool, len(s.Hosts))
+	var status []HostHealth
+	for _, h := range s.Hosts {
+		h = normalizeHost(h)
+		if !seen[h] {
+			seen[h] = true
+			status = append(status, health(h))
+		}
+	}
+
+	var others []string
+	for h := range s.hostStates {
+		if !seen[h] {
+			others = append(others, h)
+		}
+	}
+	sort.Strings(others)
+	for _, h := range others {
+		status = append(status, health(h))
+	}
+	return status
+}
@@ -0,0 +1,136 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveResetMock resets every connection, counting them.
+func serveResetMock(mock *mockZabbixServer, accepted *int32) {
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(accepted, 1)
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}
+}
+
+func TestHostCooldown(t *testing.T) {
+	failing := newMockZabbixServer(t)
+	defer failing.Close()
+	var failingAccepted int32
+	go serveResetMock(failing, &failingAccepted)
+
+	working := newMockZabbixServer(t)
+	defer working.Close()
+	var received int32
+	go serveBroadcastMock(working, &received)
+
+	s := NewSenderHosts([]string{failing.address, working.address})
+	s.HostCooldown = time.Minute
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	for i := 0; i < 3; i++ {
+		s.setPrimaryHost("") // force the fallback over the hosts
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&failingAccepted); n != 1 {
+		t.Errorf("expected the failing host to be tried once, got %d", n)
+	}
+	if n := atomic.LoadInt32(&received); n != 3 {
+		t.Errorf("expected 3 deliveries, got %d", n)
+	}
+
+	status := s.HostStatus()
+	if len(status) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", status)
+	}
+	if h := status[0]; h.Host != failing.address || h.ConsecutiveFailures != 1 || !h.InCooldown || h.LastFailure.IsZero() || h.LastError == "" {
+		t.Errorf("unexpected failing host status %+v", h)
+	}
+	if h := status[1]; h.Host != working.address || h.ConsecutiveFailures != 0 || h.InCooldown || h.LastSuccess.IsZero() {
+		t.Errorf("unexpected working host status %+v", h)
+	}
+}
+
+func TestHostCooldownAllHosts(t *testing.T) {
+	failing := newMockZabbixServer(t)
+	defer failing.Close()
+	var accepted int32
+	go serveResetMock(failing, &accepted)
+
+	s := NewSender(failing.address)
+	s.HostCooldown = time.Minute
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Send(packet); err == nil {
+			t.Fatalf("send %d: expected error", i)
+		}
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected the only host to be tried although in cooldown, got %d", n)
+	}
+	if h := s.HostStatus()[0]; h.ConsecutiveFailures != 2 {
+		t.Errorf("expected 2 consecutive failures, got %d", h.ConsecutiveFailures)
+	}
+}
+
+func TestHostStatusConcurrent(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	s := NewSender(mock.address)
+	s.HostCooldown = time.Minute
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+			s.HostStatus()
+		}()
+	}
+	wg.Wait()
+
+	if h := s.HostStatus()[0]; h.ConsecutiveFailures != 0 || h.LastSuccess.IsZero() {
+		t.Errorf("unexpected host status %+v", h)
+	}
+}
+
+func TestPing(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	s := NewSenderHosts([]string{dead.address, mock.address})
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatalf("expected a reachable host, got %v", err)
+	}
+	status := s.HostStatus()
+	if status[0].ConsecutiveFailures != 1 || status[1].LastSuccess.IsZero() {
+		t.Errorf("expected ping results in host status, got %+v", status)
+	}
+
+	var sendErr *SendError
+	if err := NewSender(dead.address).Ping(context.Background()); !errors.As(err, &sendErr) {
+		t.Errorf("expected *SendError, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Package healthz serves the Zabbix connectivity of a zabbix_sender.Sender over
+// HTTP, e.g. as the /healthz endpoint of a service. It is a separate package so
+// that users of the sender do not depend on net/http.
+package healthz
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	zabbix_sender "github.com/christos-diamantis/zabbix_sender"
+)
+
+// Status is the JSON body of Handler.
+type Status struct {
+	Status string       `json:"status"` // "ok" or "unavailable"
+	Error  string       `json:"error,omitempty"`
+	Hosts  []HostStatus `json:"hosts"`
+}
+
+// HostStatus is the health of one host in Status.
+type HostStatus struct {
+	Host                string     `json:"host"`
+	Up                  bool       `json:"up"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// Handler returns a handler that pings the hosts of s (see Sender.Ping) and
+// answers 200 when at least one is reachable, 503 otherwise, with a Status body.
+// The ping is bounded by the request context and the ConnectTimeout of s.
+func Handler(s *zabbix_sender.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := Status{Status: "ok"}
+		code := http.StatusOK
+		if err := s.Ping(r.Context()); err != nil {
+			status.Status = "unavailable"
+			status.Error = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+
+		for _, h := range s.HostStatus() {
+			hs := HostStatus{
+				Host:                h.Host,
+				Up:                  h.ConsecutiveFailures == 0 && !h.LastSuccess.IsZero(),
+				ConsecutiveFailures: h.ConsecutiveFailures,
+				LastError:           h.LastError,
+			}
+			if !h.LastFailure.IsZero() {
+				hs.LastFailure = &h.LastFailure
+			}
+			if !h.LastSuccess.IsZero() {
+				hs.LastSuccess = &h.LastSuccess
+			}
+			status.Hosts = append(status.Hosts, hs)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	}
+}
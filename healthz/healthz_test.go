@@ -0,0 +1,85 @@
+package healthz
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	zabbix_sender "github.com/christos-diamantis/zabbix_sender"
+)
+
+// listen returns a listener accepting and closing connections, like a Zabbix
+// server that is up.
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+// deadAddress returns an address nothing listens on.
+func deadAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func serve(t *testing.T, s *zabbix_sender.Sender) (int, Status) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler(s)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON body: %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %s", ct)
+	}
+	return rec.Code, status
+}
+
+func TestHandler(t *testing.T) {
+	up := listen(t)
+	defer up.Close()
+	dead := deadAddress(t)
+
+	code, status := serve(t, zabbix_sender.NewSenderHosts([]string{up.Addr().String(), dead}))
+	if code != http.StatusOK || status.Status != "ok" {
+		t.Fatalf("expected 200 ok, got %d %+v", code, status)
+	}
+	if len(status.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", status.Hosts)
+	}
+	if h := status.Hosts[0]; h.Host != up.Addr().String() || !h.Up || h.LastSuccess == nil {
+		t.Errorf("expected %s up, got %+v", up.Addr(), h)
+	}
+	if h := status.Hosts[1]; h.Host != dead || h.Up || h.ConsecutiveFailures != 1 || h.LastError == "" {
+		t.Errorf("expected %s down, got %+v", dead, h)
+	}
+}
+
+func TestHandlerUnavailable(t *testing.T) {
+	code, status := serve(t, zabbix_sender.NewSender(deadAddress(t)))
+	if code != http.StatusServiceUnavailable || status.Status != "unavailable" || status.Error == "" {
+		t.Fatalf("expected 503 unavailable, got %d %+v", code, status)
+	}
+}
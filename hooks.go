@@ -0,0 +1,94 @@
+package zabbix_sender
+
+import (
+	"context"
+	"time"
+)
+
+// SendEvent describes one attempt to send a packet to a host, see Sender.OnSend.
+type SendEvent struct {
+	Host          string
+	Request       string
+	CorrelationID string // from WithCorrelationID, empty if not set
+	Duration      time.Duration
+	Response      Response
+	Err           error
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context carrying a correlation ID for tracing.
+// Sends using the context report it in SendEvent and, when
+// Sender.IncludeCorrelationID is set, in the packet.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok
+}
+
+// withPacketCorrelationID returns ctx carrying the correlation ID of packet when
+// ctx has none, so that the log lines and events of its send carry it.
+func withPacketCorrelationID(ctx context.Context, packet *Packet) context.Context {
+	if _, ok := CorrelationIDFromContext(ctx); ok || packet.CorrelationID == "" {
+		return ctx
+	}
+	return WithCorrelationID(ctx, packet.CorrelationID)
+}
+
+// packetCorrelationID returns the correlation ID of ctx to include in packets,
+// empty unless IncludeCorrelationID is set.
+func (s *Sender) packetCorrelationID(ctx context.Context) string {
+	if !s.IncludeCorrelationID {
+		return ""
+	}
+	id, _ := CorrelationIDFromContext(ctx)
+	return id
+}
+
+// Directions of the bytes passed to Sender.OnWire.
+const (
+	WireWrite = "write"
+	WireRead  = "read"
+)
+
+// onWire passes the bytes of a frame to the OnWire hook.
+func (s *Sender) onWire(direction string, data []byte) {
+	if s.OnWire != nil {
+		s.OnWire(direction, data)
+	}
+}
+
+type hostKey struct{}
+
+// withHost returns a context carrying the configured host a send goes to.
+func withHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, hostKey{}, host)
+}
+
+// HostFromContext returns the configured host (one of Sender.Hosts) being sent
+// to, from the context passed to Sender.DialContext. The dialed address differs
+// from it when a Resolver expanded it or a redirect was followed from it.
+func HostFromContext(ctx context.Context) (string, bool) {
+	host, ok := ctx.Value(hostKey{}).(string)
+	return host, ok
+}
+
+// onSend reports a send attempt to the OnSend hook.
+func (s *Sender) onSend(ctx context.Context, packet *Packet, host string, start time.Time, res Response, err error) {
+	if s.OnSend == nil {
+		return
+	}
+	id, _ := CorrelationIDFromContext(ctx)
+	s.OnSend(SendEvent{
+		Host:          host,
+		Request:       packet.Request,
+		CorrelationID: id,
+		Duration:      time.Since(start),
+		Response:      res,
+		Err:           err,
+	})
+}
@@ -0,0 +1,277 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSendCorrelationID(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan string, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		received <- request.CorrelationID
+
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	var events []SendEvent
+	s := NewSender(mock.address)
+	s.IncludeCorrelationID = true
+	s.OnSend = func(e SendEvent) { events = append(events, e) }
+
+	s.ClientName = "billing-service/1.4.2"
+
+	ctx := WithCorrelationID(context.Background(), "req-7f3a")
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	if _, err := s.SendContext(ctx, packet); err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+	if packet.CorrelationID != "" || packet.Client != "" {
+		t.Errorf("the caller's packet was modified: %+v", packet)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 send event, got %d", len(events))
+	}
+	e := events[0]
+	if e.CorrelationID != "req-7f3a" {
+		t.Errorf("event CorrelationID: expected req-7f3a, got %q", e.CorrelationID)
+	}
+	if e.Host != mock.address || e.Request != "sender data" || e.Err != nil {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if id := <-received; id != "req-7f3a" {
+		t.Errorf("packet correlation_id: expected req-7f3a, got %q", id)
+	}
+}
+
+func TestPacketCorrelationIDOptIn(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		if request.CorrelationID != "" {
+			done <- fmt.Errorf("expected no correlation_id without IncludeCorrelationID, got %q", request.CorrelationID)
+			return
+		}
+
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(mock.address)
+	ctx := WithCorrelationID(context.Background(), "req-7f3a")
+	if _, err := s.SendContext(ctx, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestHostFromContextInDialer(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			serveOnce(mock, done)
+		}
+	}()
+
+	var dialed []string
+	s := NewSender("zabbix-proxies")
+	s.Hosts = []string{"zabbix-proxies"}
+	s.Resolver = func(logical string) ([]string, error) {
+		return []string{mock.address}, nil
+	}
+	s.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, ok := HostFromContext(ctx)
+		if !ok {
+			return nil, fmt.Errorf("no host in the dial context")
+		}
+		dialed = append(dialed, host+" "+addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	// The second send uses the cached PrimaryHost
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Mock server error: %v", err)
+		}
+	}
+
+	expected := "zabbix-proxies " + mock.address
+	if len(dialed) != 2 || dialed[0] != expected || dialed[1] != expected {
+		t.Errorf("expected dials [%s %s], got %v", expected, expected, dialed)
+	}
+}
+
+func TestSenderDialer(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remote <- conn.RemoteAddr()
+		if _, err := mock.readZabbixRequest(conn); err == nil {
+			mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+		}
+	}()
+
+	// Pick a free source port to bind
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	var controlled bool
+	s := NewSender(mock.address)
+	s.Dialer = &net.Dialer{
+		LocalAddr: local,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = true
+			return nil
+		},
+	}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	if got := (<-remote).(*net.TCPAddr); got.Port != local.Port {
+		t.Errorf("expected source port %d, got %d", local.Port, got.Port)
+	}
+	if !controlled {
+		t.Error("expected the Dialer Control function to be called")
+	}
+	if s.Dialer.Timeout != 0 {
+		t.Error("the configured Dialer must not be modified")
+	}
+}
+
+func TestOnWire(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	var directions []string
+	var frames [][]byte
+	s := NewSender(mock.address)
+	s.OnWire = func(direction string, data []byte) {
+		directions = append(directions, direction)
+		frames = append(frames, append([]byte(nil), data...))
+	}
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(directions) != 2 || directions[0] != WireWrite || directions[1] != WireRead {
+		t.Fatalf("expected a write then a read, got %v", directions)
+	}
+	for i, frame := range frames {
+		if !bytes.HasPrefix(frame, []byte("ZBXD\x01")) {
+			t.Errorf("%s: expected the ZBXD header, got %q", directions[i], frame)
+		}
+	}
+	if !bytes.Contains(frames[0], []byte(`"key":"ping"`)) {
+		t.Errorf("expected the request data, got %q", frames[0])
+	}
+	if !bytes.Contains(frames[1], []byte(`"response":"success"`)) {
+		t.Errorf("expected the response data, got %q", frames[1])
+	}
+}
+
+func TestOnRedirect(t *testing.T) {
+	target := newMockZabbixServer(t)
+	defer target.Close()
+	var received int32
+	go serveBroadcastMock(target, &received)
+
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	go func() {
+		conn, err := redirecting.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := redirecting.readZabbixRequest(conn); err == nil {
+			redirecting.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"failed","redirect":{"revision":42,"address":"%s"}}`, target.address))
+		}
+	}()
+
+	type redirect struct {
+		from, to string
+		revision int
+	}
+	var redirects []redirect
+	s := NewSender(redirecting.address)
+	s.OnRedirect = func(from, to string, revision int) {
+		redirects = append(redirects, redirect{from, to, revision})
+	}
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := redirect{redirecting.address, target.address, 42}
+	if len(redirects) != 1 || redirects[0] != expected {
+		t.Errorf("expected one redirect %+v, got %+v", expected, redirects)
+	}
+}
@@ -0,0 +1,116 @@
+package zabbix_sender
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ItemResult is the outcome of one item of a data packet, for receivers that
+// report per-item results in the response "data".
+type ItemResult struct {
+	Index  int    // position of the metric in the packet
+	Key    string // item key, empty if not reported
+	Status string // "success" or "failed"
+	Reason string // why the item failed, empty if not reported
+}
+
+// Failed reports whether the item was not stored.
+func (i ItemResult) Failed() bool {
+	return i.Status != "success"
+}
+
+// UnmarshalJSON decodes a per-item result. The field names differ between
+// receivers: the status may be reported as "status" or "response", the reason
+// as "reason", "info" or "error". Results without status as history.push returns
+// them, an "itemid" for a stored value or an "error", are decoded too. The index
+// defaults to the position in "data".
+func (i *ItemResult) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Index    *int   `json:"index"`
+		Key      string `json:"key"`
+		ItemID   string `json:"itemid"`
+		Status   string `json:"status"`
+		Response string `json:"response"`
+		Reason   string `json:"reason"`
+		Info     string `json:"info"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	i.Index = -1
+	if aux.Index != nil {
+		i.Index = *aux.Index
+	}
+	i.Key = aux.Key
+	i.Status = firstNonEmpty(aux.Status, aux.Response)
+	i.Reason = firstNonEmpty(aux.Reason, aux.Info, aux.Error)
+	if i.Status == "" && aux.Key == "" {
+		switch {
+		case aux.Error != "":
+			i.Status = "failed"
+		case aux.ItemID != "":
+			i.Status = "success"
+		}
+	}
+	return nil
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// itemResults parses the per-item results of a data packet response. It returns
+// nil when data holds none, e.g. for the items of an "active checks" response,
+// which have no status.
+func itemResults(data json.RawMessage) []ItemResult {
+	if len(data) == 0 || data[0] != '[' {
+		return nil
+	}
+	var items []ItemResult
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil
+	}
+	for n := range items {
+		if items[n].Status == "" {
+			return nil
+		}
+		if items[n].Index < 0 {
+			items[n].Index = n
+		}
+	}
+	return items
+}
+
+// FailedItems returns the items of the packet that failed. With per-item
+// results (see Items) they identify each failed metric. Otherwise the failures
+// can not be attributed: when the aggregate counters report failed items, a
+// single ItemResult with Index -1 carries their count in Reason, see also FindFailing.
+func (r *Response) FailedItems() []ItemResult {
+	if r.Items != nil {
+		var failed []ItemResult
+		for _, item := range r.Items {
+			if item.Failed() {
+				failed = append(failed, item)
+			}
+		}
+		return failed
+	}
+
+	info, err := r.parseStats(InfoOptions{})
+	if err != nil || info.Failed == 0 {
+		return nil
+	}
+	return []ItemResult{{
+		Index:  -1,
+		Status: "failed",
+		Reason: fmt.Sprintf("%d of %d items failed, the receiver did not report which", info.Failed, info.Total),
+	}}
+}
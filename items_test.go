@@ -0,0 +1,66 @@
+package zabbix_sender
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFailedItems(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			return
+		}
+		mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 2; failed: 1; total: 3; seconds spent: 0.000030",`+
+			`"data":[{"key":"ping","status":"success"},`+
+			`{"key":"cpu.load","response":"failed","info":"item is disabled"},`+
+			`{"index":2,"key":"mem.free","status":"success"}]}`)
+	}()
+
+	s := NewSender(mock.address)
+	res, err := s.Send(NewPacket([]*Metric{
+		NewMetric("zabbixTrapper1", "ping", "1", false),
+		NewMetric("zabbixTrapper1", "cpu.load", "2", false),
+		NewMetric("zabbixTrapper1", "mem.free", "3", false),
+	}, false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Items) != 3 || res.Items[1].Index != 1 || res.Items[2].Index != 2 {
+		t.Fatalf("unexpected items %+v", res.Items)
+	}
+	failed := res.FailedItems()
+	if len(failed) != 1 {
+		t.Fatalf("expected 1 failed item, got %+v", failed)
+	}
+	if f := failed[0]; f.Index != 1 || f.Key != "cpu.load" || f.Status != "failed" || f.Reason != "item is disabled" {
+		t.Errorf("unexpected failed item %+v", f)
+	}
+}
+
+func TestFailedItemsAggregateFallback(t *testing.T) {
+	r := Response{Response: "success", Info: "processed: 1; failed: 2; total: 3; seconds spent: 0.000030"}
+	failed := r.FailedItems()
+	if len(failed) != 1 || failed[0].Index != -1 || !strings.Contains(failed[0].Reason, "2 of 3 items failed") {
+		t.Errorf("expected one unattributed entry with the counters, got %+v", failed)
+	}
+
+	r.Info = "processed: 3; failed: 0; total: 3; seconds spent: 0.000030"
+	if failed := r.FailedItems(); failed != nil {
+		t.Errorf("expected no failed items, got %+v", failed)
+	}
+
+	// The items of an "active checks" response are not per-item results
+	if items := itemResults(json.RawMessage(`[{"key":"agent.ping","delay":60,"lastlogsize":0,"mtime":0}]`)); items != nil {
+		t.Errorf("expected no item results for active checks, got %+v", items)
+	}
+}
@@ -0,0 +1,63 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// maxIdleConnsPerHost bounds the connections KeepAlive keeps open per host,
+// the others are closed after their send.
+const maxIdleConnsPerHost = 4
+
+// getConn returns an idle connection to host with KeepAlive, reported as reused,
+// or a new one.
+func (s *Sender) getConn(ctx context.Context, host string, timeout time.Duration) (conn net.Conn, reused bool, err error) {
+	if s.KeepAlive {
+		s.mu.Lock()
+		if idle := s.idleConns[host]; len(idle) > 0 {
+			conn = idle[len(idle)-1]
+			s.idleConns[host] = idle[:len(idle)-1]
+		}
+		s.mu.Unlock()
+		if conn != nil {
+			return conn, true, nil
+		}
+	}
+	conn, err = s.dial(ctx, host, timeout)
+	return conn, false, err
+}
+
+// putConn keeps conn open for the next send to host.
+func (s *Sender) putConn(host string, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idleConns[host]) >= maxIdleConnsPerHost {
+		conn.Close()
+		return
+	}
+	if s.idleConns == nil {
+		s.idleConns = make(map[string][]net.Conn)
+	}
+	s.idleConns[host] = append(s.idleConns[host], conn)
+}
+
+// Close closes the connections KeepAlive kept open. The Sender remains usable,
+// later sends open new connections.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	idle := s.idleConns
+	s.idleConns = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for _, conns := range idle {
+		for _, conn := range conns {
+			if err := conn.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,157 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// servePersistentMock answers every request on each accepted connection until
+// the client closes it, counting the connections in accepted.
+func servePersistentMock(mock *mockZabbixServer, accepted *int32) {
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(accepted, 1)
+		go func() {
+			defer conn.Close()
+			for {
+				if _, err := mock.readZabbixRequest(conn); err != nil {
+					return
+				}
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}
+		}()
+	}
+}
+
+func TestKeepAliveReusesConnection(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var accepted int32
+	go servePersistentMock(mock, &accepted)
+
+	s := NewSender(mock.address)
+	s.KeepAlive = true
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	for i := 0; i < 5; i++ {
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("send after Close: %v", err)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected a new connection after Close, got %d connections", n)
+	}
+	s.Close()
+}
+
+func TestKeepAliveRedialsClosedConnection(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// Like Zabbix, the mock closes the connection after each response
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	s := NewSender(mock.address)
+	s.KeepAlive = true
+	defer s.Close()
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&received); n != 3 {
+		t.Errorf("expected 3 metrics received once each, got %d", n)
+	}
+}
+
+func TestKeepAliveNoResendAfterReadTimeout(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// The first request is answered, the second one is received but not answered
+	var received int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					if _, err := mock.readZabbixRequest(conn); err != nil {
+						return
+					}
+					if atomic.AddInt32(&received, 1) == 1 {
+						mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+					}
+				}
+			}()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.KeepAlive = true
+	s.ReadTimeout = 100 * time.Millisecond
+	defer s.Close()
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+
+	// The packet may have been stored, it must not be sent again on a new connection.
+	// Without a cached primary host the host is tried once.
+	s.PrimaryHost = ""
+	_, err := s.Send(packet)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a read timeout, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&received); n != 2 {
+		t.Errorf("expected the timed out packet sent once, got %d requests", n)
+	}
+}
+
+// BenchmarkKeepAlive compares dialing for every send against reusing the connection.
+func BenchmarkKeepAlive(b *testing.B) {
+	mock := newMockZabbixServer(b)
+	defer mock.Close()
+	var accepted int32
+	go servePersistentMock(mock, &accepted)
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	for _, keepAlive := range []bool{false, true} {
+		name := "dial per send"
+		if keepAlive {
+			name = "reused connection"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := NewSender(mock.address)
+			s.KeepAlive = keepAlive
+			defer s.Close()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Send(packet); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package zabbix_sender
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyStats summarizes the durations of successful sends, see Sender.LatencyStats.
+// Percentiles are approximate, within the ~20% resolution of the histogram.
+type LatencyStats struct {
+	Count         int64
+	Min, Max      time.Duration
+	Mean          time.Duration
+	P50, P95, P99 time.Duration
+}
+
+// latencySubBuckets is the number of histogram buckets per power of two.
+const latencySubBuckets = 4
+
+// latencyHistogram is a lock free log-linear histogram of durations in nanoseconds.
+type latencyHistogram struct {
+	count, sum atomic.Int64
+	min, max   atomic.Int64 // min is stored +1, 0 means no value yet
+	buckets    [64 * latencySubBuckets]atomic.Int64
+}
+
+// latencyBucket returns the bucket index of d nanoseconds.
+func latencyBucket(d int64) int {
+	if d < latencySubBuckets {
+		return int(d)
+	}
+	exp := bits.Len64(uint64(d)) - 1
+	sub := (d >> (exp - 2)) & (latencySubBuckets - 1)
+	return exp*latencySubBuckets + int(sub)
+}
+
+// latencyBucketRange returns the bounds [lower, upper) of bucket i in nanoseconds.
+func latencyBucketRange(i int) (lower, upper int64) {
+	if i < latencySubBuckets {
+		return int64(i), int64(i) + 1
+	}
+	exp, sub := i/latencySubBuckets, int64(i%latencySubBuckets)
+	return (latencySubBuckets + sub) << (exp - 2), (latencySubBuckets + sub + 1) << (exp - 2)
+}
+
+// record adds one duration to the histogram.
+func (h *latencyHistogram) record(d time.Duration) {
+	ns := int64(d)
+	if ns < 0 {
+		ns = 0
+	}
+
+	h.count.Add(1)
+	h.sum.Add(ns)
+	h.buckets[latencyBucket(ns)].Add(1)
+
+	for cur := h.min.Load(); cur == 0 || ns+1 < cur; cur = h.min.Load() {
+		if h.min.CompareAndSwap(cur, ns+1) {
+			break
+		}
+	}
+	for cur := h.max.Load(); ns > cur; cur = h.max.Load() {
+		if h.max.CompareAndSwap(cur, ns) {
+			break
+		}
+	}
+}
+
+// stats summarizes the histogram. Sends recorded concurrently may be partially included.
+func (h *latencyHistogram) stats() LatencyStats {
+	var counts [len(h.buckets)]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return LatencyStats{}
+	}
+
+	st := LatencyStats{
+		Count: total,
+		Min:   time.Duration(h.min.Load() - 1),
+		Max:   time.Duration(h.max.Load()),
+		Mean:  time.Duration(h.sum.Load() / h.count.Load()),
+	}
+
+	percentile := func(q float64) time.Duration {
+		rank := int64(math.Ceil(q * float64(total)))
+		var acc int64
+		for i, n := range counts {
+			if acc += n; acc >= rank {
+				lower, upper := latencyBucketRange(i)
+				d := time.Duration((lower + upper) / 2)
+				if d < st.Min {
+					d = st.Min
+				}
+				if d > st.Max {
+					d = st.Max
+				}
+				return d
+			}
+		}
+		return st.Max
+	}
+	st.P50, st.P95, st.P99 = percentile(0.50), percentile(0.95), percentile(0.99)
+
+	return st
+}
+
+// cumulative returns the number of durations in buckets ending at or below each
+// of bounds, in increasing order, with the total count and the sum in nanoseconds.
+// Bounds should be powers of two, which are bucket boundaries, to be exact.
+func (h *latencyHistogram) cumulative(bounds []int64) (counts []int64, total, sum int64) {
+	counts = make([]int64, len(bounds))
+	for i := range h.buckets {
+		n := h.buckets[i].Load()
+		if n == 0 {
+			continue
+		}
+		total += n
+		_, upper := latencyBucketRange(i)
+		for j, bound := range bounds {
+			if upper <= bound {
+				counts[j] += n
+			}
+		}
+	}
+	return counts, total, h.sum.Load()
+}
+
+// LatencyStats returns statistics of the durations of successful sends to a
+// host, since the Sender was created. Each redirect hop counts as one send.
+func (s *Sender) LatencyStats() LatencyStats {
+	return s.latency.stats()
+}
@@ -0,0 +1,85 @@
+package zabbix_sender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// Nine fast answers, then one slow one
+	delays := []time.Duration{20, 20, 20, 20, 20, 20, 20, 20, 20, 120}
+	go func() {
+		for _, delay := range delays {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				time.Sleep(delay * time.Millisecond)
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	if st := s.LatencyStats(); st != (LatencyStats{}) {
+		t.Errorf("expected empty stats before sending, got %+v", st)
+	}
+
+	for range delays {
+		if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+
+	st := s.LatencyStats()
+	inRange := func(name string, d, lower, upper time.Duration) {
+		t.Helper()
+		if d < lower || d > upper {
+			t.Errorf("%s: expected %v in [%v, %v]", name, d, lower, upper)
+		}
+	}
+
+	if st.Count != 10 {
+		t.Errorf("Count: expected 10, got %d", st.Count)
+	}
+	inRange("Min", st.Min, 20*time.Millisecond, 60*time.Millisecond)
+	inRange("Max", st.Max, 120*time.Millisecond, 200*time.Millisecond)
+	inRange("Mean", st.Mean, 30*time.Millisecond, 80*time.Millisecond)
+	inRange("P50", st.P50, 16*time.Millisecond, 60*time.Millisecond)
+	inRange("P95", st.P95, 96*time.Millisecond, 200*time.Millisecond)
+	inRange("P99", st.P99, 96*time.Millisecond, 200*time.Millisecond)
+}
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+	for _, ns := range []int64{0, 1, 3, 4, 7, 8, 1000, 123456789, 1 << 40} {
+		lower, upper := latencyBucketRange(latencyBucket(ns))
+		if ns < lower || ns >= upper {
+			t.Errorf("%d: bucket range [%d, %d) does not contain it", ns, lower, upper)
+		}
+	}
+
+	var h latencyHistogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	st := h.stats()
+	if st.Min != time.Millisecond || st.Max != 100*time.Millisecond {
+		t.Errorf("expected min 1ms and max 100ms, got %v and %v", st.Min, st.Max)
+	}
+	if st.Mean != 50500*time.Microsecond {
+		t.Errorf("expected mean 50.5ms, got %v", st.Mean)
+	}
+	// Percentiles are within the bucket resolution
+	for _, p := range []struct {
+		got, expected time.Duration
+	}{{st.P50, 50 * time.Millisecond}, {st.P95, 95 * time.Millisecond}, {st.P99, 99 * time.Millisecond}} {
+		if p.got < p.expected*8/10 || p.got > p.expected*12/10 {
+			t.Errorf("expected ~%v, got %v", p.expected, p.got)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LineWriter is an io.Writer turning newline-delimited "host key value [timestamp]"
+// records into trapper metrics, sent in batches through a BufferedSender.
+// Fields are separated by whitespace; fields containing whitespace are double
+// quoted, with \" and \\ escapes. Malformed lines are skipped and counted.
+type LineWriter struct {
+	buffered *BufferedSender
+
+	mu      sync.Mutex
+	partial []byte
+
+	malformed int64
+}
+
+// NewLineWriter creates a LineWriter sending through s, flushing every maxBatch
+// metrics or interval (see NewBufferedSender).
+func NewLineWriter(s *Sender, maxBatch int, interval time.Duration) *LineWriter {
+	return &LineWriter{buffered: NewBufferedSender(s, maxBatch, interval)}
+}
+
+// Write parses the complete lines of p. An incomplete last line is kept until
+// the next Write or Close. The returned error is a failed batch send.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.partial = append(w.partial, p...)
+	var metrics []*Metric
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if m := w.parse(string(w.partial[:i])); m != nil {
+			metrics = append(metrics, m)
+		}
+		w.partial = w.partial[i+1:]
+	}
+	w.mu.Unlock()
+
+	if len(metrics) == 0 {
+		return len(p), nil
+	}
+	return len(p), w.buffered.Add(metrics...)
+}
+
+// parse parses one record, counting it when malformed. Blank lines are ignored.
+func (w *LineWriter) parse(line string) *Metric {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	m, err := parseLine(line)
+	if err != nil {
+		atomic.AddInt64(&w.malformed, 1)
+		return nil
+	}
+	return m
+}
+
+// parseLine parses a "host key value [timestamp]" record.
+func parseLine(line string) (*Metric, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(fields) {
+	case 3:
+		return NewMetric(fields[0], fields[1], fields[2], false), nil
+	case 4:
+		clock, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[3])
+		}
+		return NewMetric(fields[0], fields[1], fields[2], false, time.Unix(clock, 0)), nil
+	default:
+		return nil, fmt.Errorf("expected 3 or 4 fields, got %d", len(fields))
+	}
+}
+
+// splitFields splits a line into whitespace separated fields, honoring double
+// quoted fields with \" and \\ escapes.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		if line[i] != '"' {
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			fields = append(fields, line[start:i])
+			continue
+		}
+
+		var field strings.Builder
+		closed := false
+		for i++; i < len(line); i++ {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				i++
+				c = line[i]
+			} else if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			field.WriteByte(c)
+		}
+		if !closed {
+			return nil, fmt.Errorf("unterminated quoted field")
+		}
+		if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			return nil, fmt.Errorf("unexpected %q after quoted field", line[i])
+		}
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// Malformed returns the number of malformed lines skipped so far.
+func (w *LineWriter) Malformed() int64 {
+	return atomic.LoadInt64(&w.malformed)
+}
+
+// Flush sends the metrics parsed so far.
+func (w *LineWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+// Close parses a trailing line without newline, sends everything pending and
+// stops the writer.
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	m := w.parse(string(w.partial))
+	w.partial = nil
+	w.mu.Unlock()
+
+	if m != nil {
+		if err := w.buffered.Add(m); err != nil {
+			return err
+		}
+	}
+	return w.buffered.Shutdown(context.Background())
+}
@@ -0,0 +1,109 @@
+package zabbix_sender
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan *ZabbixRequest, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		received <- request
+
+		jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, len(request.Data), len(request.Data))
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	w := NewLineWriter(NewSender(mock.address), 100, 0)
+
+	input := "web01 nginx.requests 1520\n" +
+		"web01 nginx.status \"up and running\" 1700000000\n" +
+		"broken line\n" +
+		"\n" +
+		"web02 nginx.requests 98"
+
+	// Feed in small chunks to split records across writes
+	if _, err := io.CopyBuffer(w, strings.NewReader(input), make([]byte, 7)); err != nil {
+		t.Fatalf("error writing lines: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing line writer: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	request := <-received
+
+	if request.Request != "sender data" {
+		t.Errorf("expected 'sender data', got '%s'", request.Request)
+	}
+	expected := []ZabbixRequestData{
+		{Host: "web01", Key: "nginx.requests", Value: "1520"},
+		{Host: "web01", Key: "nginx.status", Value: "up and running", Clock: 1700000000},
+		{Host: "web02", Key: "nginx.requests", Value: "98"},
+	}
+	if len(request.Data) != len(expected) {
+		t.Fatalf("expected %d metrics, got %d: %+v", len(expected), len(request.Data), request.Data)
+	}
+	for i, e := range expected {
+		if request.Data[i] != e {
+			t.Errorf("metric %d: expected %+v, got %+v", i, e, request.Data[i])
+		}
+	}
+
+	if n := w.Malformed(); n != 1 {
+		t.Errorf("expected 1 malformed line, got %d", n)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		valid    bool
+	}{
+		{`host key value`, []string{"host", "key", "value"}, true},
+		{"host\tkey  value", []string{"host", "key", "value"}, true},
+		{`"my host" key "say \"hi\" \\ bye"`, []string{"my host", "key", `say "hi" \ bye`}, true},
+		{`host key ""`, []string{"host", "key", ""}, true},
+		{`host key "unterminated`, nil, false},
+		{`host key "value"x`, nil, false},
+	}
+
+	for _, tt := range tests {
+		fields, err := splitFields(tt.line)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("splitFields(%s): expected error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitFields(%s): unexpected error %v", tt.line, err)
+			continue
+		}
+		if strings.Join(fields, "|") != strings.Join(tt.expected, "|") || len(fields) != len(tt.expected) {
+			t.Errorf("splitFields(%s): expected %q, got %q", tt.line, tt.expected, fields)
+		}
+	}
+}
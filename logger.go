@@ -0,0 +1,58 @@
+package zabbix_sender
+
+import "context"
+
+// Logger receives the diagnostics of sends, see Sender.Logger: dial attempts,
+// redirect hops and the outcome of each send at debug level, failures at warn level.
+// The lines of a send end with its correlation ID, if any: " [correlation_id=ID]".
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// nopLogger discards everything, it is used when Sender.Logger is nil.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+
+// logger returns the Logger of s, a no-op one when unset.
+func (s *Sender) logger() Logger {
+	if s.Logger == nil {
+		return nopLogger{}
+	}
+	return s.Logger
+}
+
+// sendLogger returns the Logger of s for the lines of a send: they carry the
+// correlation ID of ctx, see WithCorrelationID, when it has one.
+func (s *Sender) sendLogger(ctx context.Context) Logger {
+	id, ok := CorrelationIDFromContext(ctx)
+	if !ok || id == "" {
+		return s.logger()
+	}
+	return correlatedLogger{s.logger(), id}
+}
+
+// correlatedLogger appends a correlation ID to each line.
+type correlatedLogger struct {
+	Logger
+	id string
+}
+
+func (l correlatedLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(format+" [correlation_id=%s]", append(args, l.id)...)
+}
+
+func (l correlatedLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warnf(format+" [correlation_id=%s]", append(args, l.id)...)
+}
+
+// logOutcome logs the final outcome of sending packet, answered by host.
+func (s *Sender) logOutcome(ctx context.Context, packet *Packet, host string, res Response, err error) {
+	if err != nil {
+		s.sendLogger(ctx).Warnf("sending %q packet failed: %v", packet.Request, err)
+		return
+	}
+	s.sendLogger(ctx).Debugf("sent %q packet to %s: %s", packet.Request, host, res.Info)
+}
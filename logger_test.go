@@ -0,0 +1,131 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+// stdLogger adapts a *log.Logger to Logger.
+type stdLogger struct {
+	*log.Logger
+}
+
+func (l stdLogger) Debugf(format string, args ...interface{}) {
+	l.Output(2, "DEBUG "+fmt.Sprintf(format, args...))
+}
+
+func (l stdLogger) Warnf(format string, args ...interface{}) {
+	l.Output(2, "WARN "+fmt.Sprintf(format, args...))
+}
+
+func TestSenderLogger(t *testing.T) {
+	target := newMockZabbixServer(t)
+	defer target.Close()
+	var received int32
+	go serveBroadcastMock(target, &received)
+
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	go func() {
+		conn, err := redirecting.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := redirecting.readZabbixRequest(conn); err == nil {
+			redirecting.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"failed","redirect":{"revision":1,"address":"%s"}}`, target.address))
+		}
+	}()
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	var buf bytes.Buffer
+	s := NewSenderHosts([]string{dead.address, redirecting.address})
+	s.Logger = stdLogger{log.New(&buf, "", 0)}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+
+	for _, expected := range []string{
+		"DEBUG dialing " + dead.address,
+		"WARN sending to host " + dead.address + " failed",
+		"DEBUG dialing " + redirecting.address,
+		"DEBUG redirect from " + redirecting.address + " to " + target.address,
+		"DEBUG dialing " + target.address,
+		`DEBUG sent "sender data" packet to ` + target.address, // the host that answered
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected log line %q in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestSenderLoggerFailure(t *testing.T) {
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	var buf bytes.Buffer
+	s := NewSender(dead.address)
+	s.Logger = stdLogger{log.New(&buf, "", 0)}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(buf.String(), `WARN sending "sender data" packet failed: all 1 hosts failed`) {
+		t.Errorf("expected the failure to be logged, got:\n%s", buf.String())
+	}
+}
+
+func TestSenderLoggerCorrelationID(t *testing.T) {
+	target := newMockZabbixServer(t)
+	defer target.Close()
+	var received int32
+	go serveBroadcastMock(target, &received)
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	var buf bytes.Buffer
+	s := NewSenderHosts([]string{dead.address, target.address})
+	s.Logger = stdLogger{log.New(&buf, "", 0)}
+	s.IncludeCorrelationID = true
+
+	ctx := WithCorrelationID(context.Background(), "req-7f3a")
+	if _, err := s.SendContext(ctx, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+
+	assertCorrelated(t, buf.String(), "req-7f3a", 4) // dials, failure, outcome
+
+	// Set on the packet only
+	buf.Reset()
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	packet.CorrelationID = "req-9c21"
+	s = NewSender(dead.address)
+	s.Logger = stdLogger{log.New(&buf, "", 0)}
+	if _, err := s.Send(packet); err == nil {
+		t.Fatal("expected error")
+	}
+	assertCorrelated(t, buf.String(), "req-9c21", 3)
+}
+
+// assertCorrelated checks that output has at least n log lines, each ending
+// with the correlation ID id.
+func assertCorrelated(t *testing.T, output, id string, n int) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < n {
+		t.Fatalf("expected at least %d log lines, got:\n%s", n, output)
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "[correlation_id="+id+"]") {
+			t.Errorf("expected correlation ID %s in log line %q", id, line)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package zabbix_sender
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SendNoWait writes packet to the first host accepting the connection, the cached
+// PrimaryHost first, and returns as soon as the write completed. The response is
+// never read: there is no Response, no ResponseInfo and no redirect handling, a
+// nil error only means the frame was written. Connection errors and WriteTimeout
+// move on to the next host. Compression applies, CompressAuto does not.
+func (s *Sender) SendNoWait(packet *Packet) error {
+	if packet.isEmptyData() {
+		return fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+	enc, err := s.EncodePacket(packet)
+	if err != nil {
+		return err
+	}
+
+	hosts, logical, resolveErr := s.resolveHosts()
+	if len(hosts) == 0 && resolveErr != nil {
+		return fmt.Errorf("sending packet: %w", resolveErr)
+	}
+	hosts, logical = s.primaryFirst(hosts, logical)
+
+	ctx := withPacketCorrelationID(context.Background(), enc.packet)
+	var attempts []HostAttempt
+	for i, host := range hosts {
+		if err := s.writeNoWait(withHost(ctx, logical[i]), enc, host); err != nil {
+			attempts = append(attempts, HostAttempt{Host: host, Err: err})
+			s.sendLogger(ctx).Warnf("sending to host %s without waiting failed: %v", host, err)
+			continue
+		}
+		return nil
+	}
+	return &SendError{Hosts: len(hosts), Attempts: attempts}
+}
+
+// SendMetricsNoWait is like SendMetrics with SendNoWait: it returns once the
+// packets are written, with no responses.
+func (s *Sender) SendMetricsNoWait(metrics []*Metric) (errActive, errTrapper error) {
+	metrics, err := s.prepareValid(metrics, nil)
+	if err != nil {
+		return err, err
+	}
+	activeMetrics, trapperMetrics := splitMetrics(metrics)
+
+	if len(trapperMetrics) > 0 {
+		errTrapper = s.SendNoWait(NewPacket(trapperMetrics, false))
+	}
+
+	if len(activeMetrics) > 0 {
+		errActive = s.SendNoWait(NewPacket(activeMetrics, true))
+	}
+
+	return errActive, errTrapper
+}
+
+// writeNoWait writes the frame of enc to host over a new connection and closes it.
+func (s *Sender) writeNoWait(ctx context.Context, enc *EncodedPacket, host string) error {
+	conn, err := s.dial(ctx, host, s.ConnectTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buffer, _ := enc.frame(s.Compression)
+	conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	if err := writeFrame(conn, buffer); err != nil {
+		return fmt.Errorf("sending the data to %s (timeout=%v): %w", host, s.WriteTimeout, err)
+	}
+	s.onWire(WireWrite, buffer)
+	return nil
+}
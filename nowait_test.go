@@ -0,0 +1,84 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendNoWait(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	release := make(chan struct{})
+	received := make(chan *ZabbixRequest, 2)
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			received <- request
+			<-release // the response is only written once the sender returned
+			mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			conn.Close()
+		}
+	}()
+
+	s := NewSenderHosts([]string{dead.address, mock.address})
+	returned := make(chan error, 1)
+	go func() {
+		errActive, errTrapper := s.SendMetricsNoWait([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)})
+		if errActive != nil {
+			errTrapper = errors.Join(errTrapper, errActive)
+		}
+		returned <- errTrapper
+	}()
+
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendMetricsNoWait waited for the response")
+	}
+	close(release)
+
+	select {
+	case request := <-received:
+		if request.Request != "sender data" || len(request.Data) != 1 || request.Data[0].Key != "ping" {
+			t.Errorf("unexpected request %+v", request)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the packet never reached the server")
+	}
+
+	// Connection errors are reported
+	var sendErr *SendError
+	if err := NewSender(dead.address).SendNoWait(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); !errors.As(err, &sendErr) {
+		t.Errorf("expected a SendError, got %v", err)
+	}
+
+	// The cached primary host is tried once, and counted once
+	other := newMockZabbixServer(t)
+	other.Close()
+	s = NewSenderHosts([]string{dead.address, other.address})
+	s.PrimaryHost = other.address
+	err := s.SendNoWait(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.As(err, &sendErr) || sendErr.Hosts != 2 || len(sendErr.Attempts) != 2 || !errors.Is(err, ErrAllHostsFailed) {
+		t.Errorf("expected both hosts failed once, got %+v", err)
+	}
+	if _, err := s.OpenSession(); !errors.Is(err, ErrAllHostsFailed) || !strings.Contains(err.Error(), "(2 hosts)") {
+		t.Errorf("expected both hosts failed, got %v", err)
+	}
+}
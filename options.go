@@ -0,0 +1,41 @@
+package zabbix_sender
+
+import "time"
+
+// Option configures a Sender created by NewSenderWithOptions.
+type Option func(*Sender)
+
+// NewSenderWithOptions creates a sender for hosts (HA or Proxy Group) configured
+// by opts. Settings without an option keep the defaults of NewSenderHosts.
+func NewSenderWithOptions(hosts []string, opts ...Option) *Sender {
+	s := NewSenderHosts(hosts)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithConnectTimeout sets the timeout to resolve and connect to a host.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(s *Sender) { s.ConnectTimeout = d }
+}
+
+// WithReadTimeout sets the timeout to read a response.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Sender) { s.ReadTimeout = d }
+}
+
+// WithWriteTimeout sets the timeout to write a packet.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Sender) { s.WriteTimeout = d }
+}
+
+// WithMaxRedirects sets the number of redirects followed before failing.
+func WithMaxRedirects(n int) Option {
+	return func(s *Sender) { s.MaxRedirects = n }
+}
+
+// WithUpdateHost sets Sender.UpdateHost.
+func WithUpdateHost(update bool) Option {
+	return func(s *Sender) { s.UpdateHost = update }
+}
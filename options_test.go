@@ -0,0 +1,33 @@
+package zabbix_sender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSenderWithOptions(t *testing.T) {
+	s := NewSenderWithOptions([]string{"proxy1", "proxy2:10052"},
+		WithConnectTimeout(2*time.Second),
+		WithMaxRedirects(7),
+		WithUpdateHost(true),
+	)
+
+	if len(s.Hosts) != 2 || s.Hosts[0] != "proxy1:10051" || s.Hosts[1] != "proxy2:10052" {
+		t.Errorf("expected normalized hosts, got %v", s.Hosts)
+	}
+	if s.ConnectTimeout != 2*time.Second || s.MaxRedirects != 7 || !s.UpdateHost {
+		t.Errorf("options not applied: %+v", s.Config())
+	}
+	// Not supplied, defaults apply
+	if s.ReadTimeout != defaultReadTimeout || s.WriteTimeout != defaultWriteTimeout {
+		t.Errorf("expected default read/write timeouts, got %v/%v", s.ReadTimeout, s.WriteTimeout)
+	}
+
+	s = NewSenderWithOptions([]string{"proxy1"}, WithReadTimeout(time.Second), WithWriteTimeout(3*time.Second))
+	if s.ReadTimeout != time.Second || s.WriteTimeout != 3*time.Second {
+		t.Errorf("expected read 1s and write 3s, got %v/%v", s.ReadTimeout, s.WriteTimeout)
+	}
+	if s.ConnectTimeout != defaultConnectTimeout || s.MaxRedirects != defaultMaxRedirects || s.UpdateHost != defaultUpdateHost {
+		t.Errorf("expected defaults, got %+v", s.Config())
+	}
+}
@@ -1,31 +1,72 @@
 package zabbix_sender
 
 import (
+	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
 	"time"
 )
 
+// Protocol flags carried in the 5th header byte.
+const (
+	flagZabbix     byte = 0x01
+	flagCompressed byte = 0x02
+	flagLarge      byte = 0x04 // 8 byte data and reserved lengths
+)
+
+// HeaderFlags are the protocol flags of a response frame header.
+type HeaderFlags byte
+
+// Compressed reports whether the frame data was zlib compressed.
+func (f HeaderFlags) Compressed() bool {
+	return byte(f)&flagCompressed != 0
+}
+
+// Large reports whether the frame header had 8 byte lengths, for large packets.
+func (f HeaderFlags) Large() bool {
+	return byte(f)&flagLarge != 0
+}
+
 // Packet struct.
 type Packet struct {
-	Request      string    `json:"request"`
-	Data         []*Metric `json:"data,omitempty"`
-	Clock        int64     `json:"clock,omitempty"`
-	NS           int       `json:"ns,omitempty"`
-	Host         string    `json:"host,omitempty"`
-	HostMetadata string    `json:"host_metadata,omitempty"`
+	Request       string    `json:"request"`
+	Data          []*Metric `json:"data,omitempty"`
+	Clock         int64     `json:"clock,omitempty"`
+	NS            int       `json:"ns,omitempty"`
+	Host          string    `json:"host,omitempty"`
+	HostMetadata  string    `json:"host_metadata,omitempty"`
+	Client        string    `json:"client,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+
+	// Fields are additional top-level fields of the request, e.g. the body of a
+	// "command" request. They must not repeat the fields above.
+	Fields map[string]interface{} `json:"-"`
 }
 
+// Request verbs of data packets.
+const (
+	RequestAgentData  = "agent data"
+	RequestSenderData = "sender data"
+	RequestProxyData  = "proxy data"
+)
+
 // NewPacket returns a zabbix packet with a list of metrics
 func NewPacket(data []*Metric, agentActive bool, t ...time.Time) *Packet {
-	var request string
 	if agentActive {
-		request = "agent data"
-	} else {
-		request = "sender data"
+		return NewDataPacket(RequestAgentData, data, t...)
 	}
+	return NewDataPacket(RequestSenderData, data, t...)
+}
 
-	p := &Packet{Request: request, Data: data}
+// NewDataPacket returns a zabbix packet with the given request verb and list of metrics.
+// t optionally sets the packet timestamp.
+func NewDataPacket(verb string, data []*Metric, t ...time.Time) *Packet {
+	p := &Packet{Request: verb, Data: data}
 	if len(t) > 0 {
 		p.Clock = t[0].Unix()
 		p.NS = t[0].Nanosecond()
@@ -33,10 +74,151 @@ func NewPacket(data []*Metric, agentActive bool, t ...time.Time) *Packet {
 	return p
 }
 
-// DataLen Packet class method, return 8 bytes with packet length in little endian order
+// NewRequestPacket returns a zabbix packet with an arbitrary request verb, like
+// "command", and its top-level fields.
+func NewRequestPacket(request string, fields map[string]interface{}) *Packet {
+	return &Packet{Request: request, Fields: fields}
+}
+
+// packetKeys are the JSON keys of the Packet fields, which Fields can not set.
+var packetKeys = []string{"request", "data", "clock", "ns", "host", "host_metadata", "client", "correlation_id"}
+
+// MarshalJSON encodes the packet with its additional Fields.
+func (p *Packet) MarshalJSON() ([]byte, error) {
+	type packet Packet
+	data, err := json.Marshal((*packet)(p))
+	if err != nil || len(p.Fields) == 0 {
+		return data, err
+	}
+
+	for _, key := range packetKeys {
+		if _, ok := p.Fields[key]; ok {
+			return nil, fmt.Errorf("field %q of %q request is a packet field", key, p.Request)
+		}
+	}
+	fields, err := json.Marshal(p.Fields)
+	if err != nil {
+		return nil, err
+	}
+	// Both are JSON objects, request first: splice the fields in
+	data = append(data[:len(data)-1], ',')
+	return append(data, fields[1:]...), nil
+}
+
+// isEmptyData reports whether p is an "agent data" or "sender data" packet without metrics.
+// Other requests, like "active checks", legitimately carry no data.
+func (p *Packet) isEmptyData() bool {
+	return (p.Request == RequestAgentData || p.Request == RequestSenderData) && len(p.Data) == 0
+}
+
+// DataLen Packet class method, return 8 bytes with packet length in little endian order:
+// the 4 byte data length and 4 reserved bytes of the header. Above 4 GiB it returns
+// the 16 bytes of a large packet header instead.
+// It marshals the packet to compute the length; use Encode to build a whole frame
+// with a single marshal. The send path never calls it.
 func (p *Packet) DataLen() []byte {
-	dataLen := make([]byte, 8)
 	JSONData, _ := json.Marshal(p)
-	binary.LittleEndian.PutUint32(dataLen, uint32(len(JSONData)))
+	return encodeDataLen(uint64(len(JSONData)))
+}
+
+// Encode returns the wire bytes of the uncompressed frame of p (header, data
+// length and JSON data), marshalling it once.
+func (p *Packet) Encode() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("encoding packet: %w", err)
+	}
+	return plainFrame(data), nil
+}
+
+// plainFrame returns the wire bytes of an uncompressed frame for JSON data.
+func plainFrame(data []byte) []byte {
+	header := frameHeader(uint64(len(data)))
+	frame := make([]byte, 0, len(header)+len(data))
+	frame = append(frame, header...)
+	return append(frame, data...)
+}
+
+// frameHeader returns the header of an uncompressed frame for n bytes of data.
+// Above 4 GiB it is a large packet header, flagLarge with 8 byte lengths.
+func frameHeader(n uint64) []byte {
+	flags := flagZabbix
+	if n > math.MaxUint32 {
+		flags |= flagLarge
+	}
+	return append([]byte{'Z', 'B', 'X', 'D', flags}, encodeDataLen(n)...)
+}
+
+// encodeDataLen returns the little endian data length and reserved length, 0,
+// of a frame header: 4 bytes each, or 8 bytes each above 4 GiB (flagLarge).
+func encodeDataLen(n uint64) []byte {
+	if n > math.MaxUint32 {
+		dataLen := make([]byte, 16)
+		binary.LittleEndian.PutUint64(dataLen, n)
+		return dataLen
+	}
+	dataLen := make([]byte, 8)
+	binary.LittleEndian.PutUint32(dataLen, uint32(n))
 	return dataLen
 }
+
+// EncodedPacket holds a packet marshalled once, with its wire bytes built on
+// first use and reused for every host, redirect and retry it is sent to.
+type EncodedPacket struct {
+	packet           *Packet
+	data             []byte // JSON data
+	compressMinBytes int
+
+	plainOnce, compressedOnce sync.Once
+	plain, compressed         []byte
+}
+
+// frame returns the wire bytes of the packet. They are compressed when compress
+// is set and the data exceeds the compression threshold; compressed reports whether they are.
+func (e *EncodedPacket) frame(compress bool) (buffer []byte, compressed bool) {
+	if compress && len(e.data) > e.compressMinBytes {
+		e.compressedOnce.Do(func() { e.compressed = compressedFrame(e.data) })
+		return e.compressed, true
+	}
+
+	e.plainOnce.Do(func() { e.plain = plainFrame(e.data) })
+	return e.plain, false
+}
+
+// compressedFrame returns the wire bytes of a zlib compressed frame for JSON data.
+// The header carries the compressed length followed by the uncompressed length.
+func compressedFrame(data []byte) []byte {
+	var body bytes.Buffer
+	w := zlib.NewWriter(&body)
+	w.Write(data)
+	w.Close()
+
+	frame := make([]byte, 13, 13+body.Len())
+	copy(frame, "ZBXD")
+	frame[4] = flagZabbix | flagCompressed
+	binary.LittleEndian.PutUint32(frame[5:9], uint32(body.Len()))
+	binary.LittleEndian.PutUint32(frame[9:13], uint32(len(data)))
+	return append(frame, body.Bytes()...)
+}
+
+// inflate decompresses the zlib data of a compressed frame of size uncompressed bytes.
+// The output grows with the data actually inflated, size only bounds it.
+func inflate(data []byte, size uint64) ([]byte, error) {
+	if size > maxResponseBytes {
+		return nil, fmt.Errorf("decompressing data: %w: %d bytes, the limit is %d", ErrResponseTooLarge, size, maxResponseBytes)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing data: %w", err)
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing data: %w", err)
+	}
+	if uint64(len(out)) < size {
+		return nil, fmt.Errorf("decompressing data: %w: got %d of %d bytes", io.ErrUnexpectedEOF, len(out), size)
+	}
+	return out, nil
+}
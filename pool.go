@@ -0,0 +1,94 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is reported for metrics submitted after Close.
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+// WorkerPool sends metrics on a bounded number of workers, decoupling producers
+// from network latency. Submit blocks while the queue is full.
+type WorkerPool struct {
+	ctx    context.Context
+	sender *Sender
+	jobs   chan poolJob
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// poolJob is a batch of metrics waiting for a worker.
+type poolJob struct {
+	metrics []*Metric
+	result  chan<- SendMetricsResult
+}
+
+// NewWorkerPool starts workers sending through s, at least one. Up to queueSize
+// batches wait for a free worker before Submit blocks.
+func NewWorkerPool(s *Sender, workers, queueSize int) *WorkerPool {
+	return NewWorkerPoolContext(context.Background(), s, workers, queueSize)
+}
+
+// NewWorkerPoolContext is like NewWorkerPool but bounds the sends by ctx: once
+// it is done, the batches still queued fail with its error.
+func NewWorkerPoolContext(ctx context.Context, s *Sender, workers, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &WorkerPool{ctx: ctx, sender: s, jobs: make(chan poolJob, queueSize)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work sends queued batches until the pool is closed and drained.
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		resActive, errActive, resTrapper, errTrapper := p.sender.SendMetricsContext(p.ctx, job.metrics)
+		job.result <- newSendMetricsResult(job.metrics, resActive, errActive, resTrapper, errTrapper)
+		close(job.result)
+	}
+}
+
+// Submit queues metrics for sending and returns a channel receiving the result,
+// like SendMetrics does. It blocks while the queue is full. After Close the
+// result reports ErrPoolClosed.
+func (p *WorkerPool) Submit(metrics []*Metric) <-chan SendMetricsResult {
+	result := make(chan SendMetricsResult, 1)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		var res Response
+		result <- newSendMetricsResult(metrics, res, ErrPoolClosed, res, ErrPoolClosed)
+		close(result)
+		return result
+	}
+
+	p.jobs <- poolJob{metrics: metrics, result: result}
+	return result
+}
+
+// Close stops accepting metrics and waits until the queued and in-flight
+// batches are sent.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,146 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var inFlight, maxInFlight, received int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for m := atomic.LoadInt32(&maxInFlight); n > m && !atomic.CompareAndSwapInt32(&maxInFlight, m, n); m = atomic.LoadInt32(&maxInFlight) {
+				}
+
+				if _, err := mock.readZabbixRequest(conn); err != nil {
+					return
+				}
+				atomic.AddInt32(&received, 1)
+				time.Sleep(5 * time.Millisecond)
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}()
+		}
+	}()
+
+	const workers, batches = 3, 30
+	pool := NewWorkerPool(NewSender(mock.address), workers, 2)
+
+	results := make([]<-chan SendMetricsResult, batches)
+	for i := range results {
+		results[i] = pool.Submit([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)})
+	}
+	pool.Close()
+
+	for i, ch := range results {
+		r, ok := <-ch
+		if !ok {
+			t.Fatalf("batch %d: result channel closed without result", i)
+		}
+		if err := r.Err(); err != nil {
+			t.Errorf("batch %d: unexpected error %v", i, err)
+		}
+		if r.Trapper == nil || r.Trapper.Response != "success" || r.Active != nil {
+			t.Errorf("batch %d: unexpected result %+v", i, r)
+		}
+	}
+
+	if n := atomic.LoadInt32(&received); n != batches {
+		t.Errorf("expected %d batches sent, got %d", batches, n)
+	}
+	if n := atomic.LoadInt32(&maxInFlight); n > workers {
+		t.Errorf("expected at most %d concurrent sends, got %d", workers, n)
+	}
+
+	r := <-pool.Submit([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)})
+	if !errors.Is(r.Err(), ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed after Close, got %v", r.Err())
+	}
+}
+
+func TestWorkerPoolBackpressure(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	release := make(chan struct{})
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := mock.readZabbixRequest(conn); err != nil {
+					return
+				}
+				<-release
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}()
+		}
+	}()
+
+	pool := NewWorkerPool(NewSender(mock.address), 1, 1)
+	metrics := []*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)}
+
+	// One batch in flight, one queued: the third Submit blocks
+	pool.Submit(metrics)
+	pool.Submit(metrics)
+
+	var wg sync.WaitGroup
+	submitted := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pool.Submit(metrics)
+		close(submitted)
+	}()
+
+	select {
+	case <-submitted:
+		t.Fatal("expected Submit to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+	pool.Close()
+}
+
+func TestWorkerPoolContext(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewWorkerPoolContext(ctx, NewSender(mock.address), 1, 1)
+	metrics := []*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)}
+	if err := (<-p.Submit(metrics)).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Once ctx is done, the batches fail with its error
+	cancel()
+	if err := (<-p.Submit(metrics)).Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	p.Close()
+	if n := atomic.LoadInt32(&received); n != 1 {
+		t.Errorf("expected 1 delivered metric, got %d", n)
+	}
+}
@@ -0,0 +1,39 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ItemProbeValue is the value CheckItemsExist sends to each probed item.
+const ItemProbeValue = "0"
+
+// CheckItemsExist reports which trapper item keys of host the server accepts.
+//
+// Each key is probed with its own "sender data" packet carrying ItemProbeValue,
+// and is accepted when the server counts it as processed. The probe value is
+// stored like any other value, so only probe items where that is harmless.
+func (s *Sender) CheckItemsExist(host string, keys []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+		res, err := s.Send(NewPacket([]*Metric{NewMetric(host, key, ItemProbeValue, false)}, false))
+
+		var rejected *ServerRejectedError
+		if errors.As(err, &rejected) {
+			exists[key] = false
+			continue
+		}
+		if err != nil {
+			return exists, fmt.Errorf("probing %s: %w", key, err)
+		}
+
+		info, err := res.GetInfo()
+		if err != nil {
+			return exists, fmt.Errorf("probing %s: %w", key, err)
+		}
+		exists[key] = info.Processed == 1
+	}
+
+	return exists, nil
+}
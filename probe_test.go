@@ -0,0 +1,50 @@
+package zabbix_sender
+
+import (
+	"testing"
+)
+
+func TestCheckItemsExist(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	configured := map[string]bool{"app.requests": true, "app.errors": true}
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+
+			jsonResp := `{"response":"success","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000030"}`
+			if configured[request.Data[0].Key] {
+				jsonResp = `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+			}
+			mock.writeZabbixResponse(conn, jsonResp)
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	exists, err := s.CheckItemsExist("AppServer", []string{"app.requests", "app.typo", "app.errors"})
+	if err != nil {
+		t.Fatalf("error checking items: %v", err)
+	}
+
+	expected := map[string]bool{"app.requests": true, "app.typo": false, "app.errors": true}
+	if len(exists) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(exists))
+	}
+	for key, want := range expected {
+		if got, ok := exists[key]; !ok || got != want {
+			t.Errorf("%s: expected %t, got %t (present: %t)", key, want, got, ok)
+		}
+	}
+}
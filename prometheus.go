@@ -0,0 +1,55 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// promDurationBounds are the upper bounds of the send duration histogram, powers
+// of two nanoseconds from about 1ms to 34s, which are exact histogram boundaries.
+var promDurationBounds = func() []int64 {
+	var bounds []int64
+	for exp := 20; exp <= 35; exp++ {
+		bounds = append(bounds, 1<<exp)
+	}
+	return bounds
+}()
+
+// WritePrometheus writes the counters of Stats and the durations of LatencyStats
+// in the Prometheus text exposition format, e.g. to serve them on /metrics
+// without a Prometheus client library.
+func (s *Sender) WritePrometheus(w io.Writer) error {
+	st := s.Stats()
+
+	var b bytes.Buffer
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("zabbix_sender_sends_total", "Packets sent to a host, each redirect hop counted.", st.PacketsSent+st.PacketsFailed)
+	counter("zabbix_sender_send_failures_total", "Sends to a host that failed without a response.", st.PacketsFailed)
+	counter("zabbix_sender_redirects_total", "Redirects followed.", st.Redirects)
+	counter("zabbix_sender_written_bytes_total", "Bytes of the frames written.", st.BytesWritten)
+	counter("zabbix_sender_read_bytes_total", "Bytes of the frames read.", st.BytesRead)
+	counter("zabbix_sender_connect_errors_total", "Failed connections to a host.", st.ConnectErrors)
+	counter("zabbix_sender_overflow_responses_total", "Responses with more bytes than declared in their header.", st.OverflowResponses)
+	counter("zabbix_sender_overflow_bytes_total", "Bytes beyond the declared response length.", st.OverflowBytes)
+
+	const name = "zabbix_sender_send_duration_seconds"
+	counts, total, sum := s.latency.cumulative(promDurationBounds)
+	fmt.Fprintf(&b, "# HELP %s Duration of successful sends to a host.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range promDurationBounds {
+		fmt.Fprintf(&b, "%s_bucket{le=%q} %d\n", name, seconds(bound), counts[i])
+	}
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n", name, total)
+	fmt.Fprintf(&b, "%s_sum %s\n%s_count %d\n", name, seconds(sum), name, total)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// seconds formats nanoseconds as seconds.
+func seconds(ns int64) string {
+	return strconv.FormatFloat(float64(ns)/1e9, 'g', -1, 64)
+}
@@ -0,0 +1,106 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	promCommentRe = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	promSampleRe  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? (\S+)$`)
+)
+
+// parsePrometheus checks text is valid Prometheus text exposition and returns
+// its samples by name and labels, e.g. `x_bucket{le="+Inf"}`.
+func parsePrometheus(t *testing.T, text string) map[string]float64 {
+	t.Helper()
+
+	types := make(map[string]string)
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if m := promCommentRe.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := promSampleRe.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid line %q", line)
+		}
+		base := m[1]
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if trimmed := strings.TrimSuffix(base, suffix); types[trimmed] == "histogram" {
+				base = trimmed
+			}
+		}
+		if types[base] == "" {
+			t.Fatalf("sample %q without TYPE", line)
+		}
+		value, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			t.Fatalf("invalid value in %q: %v", line, err)
+		}
+		samples[m[1]+m[2]] = value
+	}
+	return samples
+}
+
+func TestWritePrometheus(t *testing.T) {
+	working := newMockZabbixServer(t)
+	defer working.Close()
+	var received int32
+	go serveBroadcastMock(working, &received)
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	s := NewSenderHosts([]string{dead.address, working.address})
+	for i := 0; i < 2; i++ {
+		if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := s.WritePrometheus(&b); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	samples := parsePrometheus(t, b.String())
+
+	for name, expected := range map[string]float64{
+		"zabbix_sender_sends_total":                             3,
+		"zabbix_sender_send_failures_total":                     1,
+		"zabbix_sender_redirects_total":                         0,
+		"zabbix_sender_connect_errors_total":                    1,
+		"zabbix_sender_overflow_responses_total":                0,
+		"zabbix_sender_send_duration_seconds_count":             2,
+		`zabbix_sender_send_duration_seconds_bucket{le="+Inf"}`: 2,
+	} {
+		if got, ok := samples[name]; !ok || got != expected {
+			t.Errorf("%s: expected %v, got %v (present %t)", name, expected, got, ok)
+		}
+	}
+	if st := s.Stats(); samples["zabbix_sender_written_bytes_total"] != float64(st.BytesWritten) || samples["zabbix_sender_read_bytes_total"] != float64(st.BytesRead) {
+		t.Errorf("expected the byte counters %+v, got %v written and %v read", st, samples["zabbix_sender_written_bytes_total"], samples["zabbix_sender_read_bytes_total"])
+	}
+	if sum := samples["zabbix_sender_send_duration_seconds_sum"]; sum <= 0 {
+		t.Errorf("expected a positive duration sum, got %v", sum)
+	}
+
+	// Buckets are cumulative
+	prev := 0.0
+	for _, bound := range promDurationBounds {
+		n := samples[`zabbix_sender_send_duration_seconds_bucket{le="`+seconds(bound)+`"}`]
+		if n < prev {
+			t.Errorf("bucket %s: count %v below the previous %v", seconds(bound), n, prev)
+		}
+		prev = n
+	}
+	if prev > 2 {
+		t.Errorf("expected at most 2 sends in the buckets, got %v", prev)
+	}
+}
@@ -0,0 +1,337 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed is returned when enqueuing metrics after Shutdown.
+var ErrQueueClosed = errors.New("queue is shut down")
+
+// ErrQueueFull is returned when enqueuing metrics beyond the queue capacity.
+var ErrQueueFull = errors.New("queue is full")
+
+// QueueOptions configures a Queue. Zero values use the defaults.
+type QueueOptions struct {
+	Capacity  int           // max queued metrics, 0 = unbounded
+	BatchSize int           // max metrics per send, default 100
+	BaseDelay time.Duration // first retry delay, default 100ms
+	MaxDelay  time.Duration // retry delay cap, default 10s
+
+	// Persist is called on Shutdown with the metrics that could not be delivered,
+	// e.g. to write them to disk and enqueue them again on the next start.
+	Persist func([]*Metric) error
+
+	// DeadLetter is called with metrics that can not be delivered, with the reason:
+	// the send failed permanently (see Queue) or the server counted them as failed
+	// items. They are removed from the queue. Without DeadLetter they are dropped and
+	// the reason is logged and passed to OnError.
+	DeadLetter func(metrics []*Metric, reason error)
+
+	// OnError is called with each failed delivery attempt before retrying.
+	OnError func(error)
+}
+
+// Queue delivers metrics in the background with at-least-once semantics.
+//
+// Metrics stay queued until the server acknowledges them as processed. Transient
+// failures (connection errors, timeouts) are retried with exponential backoff and
+// jitter, so a metric may be delivered more than once, but is never dropped
+// silently: on Shutdown the undelivered ones are handed to QueueOptions.Persist.
+//
+// Permanent failures are not retried, they would block the queue: metrics the
+// Sender rejects on validation, batches failing with ErrTooManyKeys or
+// ErrPacketTooLarge and packets the server rejects (ServerRejectedError) are
+// handed to QueueOptions.DeadLetter. When the server counts items of a batch as
+// failed (bad value, unknown item), they are identified by bisection as
+// FindFailing does, resending the halves of the batch, and dead-lettered; the
+// processed ones are acknowledged.
+type Queue struct {
+	sender *Sender
+	opts   QueueOptions
+
+	mu     sync.Mutex
+	items  []*Metric
+	closed bool
+
+	notify  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewQueue creates a Queue delivering through s and starts its worker.
+func NewQueue(s *Sender, opts QueueOptions) *Queue {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		sender:  s,
+		opts:    opts,
+		notify:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue adds metrics to the queue.
+func (q *Queue) Enqueue(metrics ...*Metric) error {
+	for _, m := range metrics {
+		if m == nil {
+			return fmt.Errorf("%w: nil metric", ErrInvalidMetric)
+		}
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	if q.opts.Capacity > 0 && len(q.items)+len(metrics) > q.opts.Capacity {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.items = append(q.items, metrics...)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of metrics not yet acknowledged.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// run delivers batches until the queue is drained after Shutdown or stopped.
+func (q *Queue) run() {
+	defer close(q.done)
+	for {
+		batch, ok := q.next()
+		if !ok {
+			return
+		}
+		pending, ok := q.deliver(batch)
+		q.ack(len(batch), pending)
+		if !ok {
+			return
+		}
+	}
+}
+
+// next waits for the next batch. It returns false when the queue is closed and
+// empty, or stopped.
+func (q *Queue) next() ([]*Metric, bool) {
+	for {
+		q.mu.Lock()
+		n := len(q.items)
+		if n > q.opts.BatchSize {
+			n = q.opts.BatchSize
+		}
+		batch := append([]*Metric(nil), q.items[:n]...)
+		q.mu.Unlock()
+
+		if len(batch) > 0 {
+			return batch, true
+		}
+
+		select {
+		case <-q.notify:
+		case <-q.closing:
+			if q.Len() == 0 {
+				return nil, false
+			}
+		case <-q.ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// deliver sends batch until each metric is acknowledged or dead-lettered. When
+// stopped first it returns false with the metrics still pending.
+func (q *Queue) deliver(batch []*Metric) ([]*Metric, bool) {
+	pending := q.dropInvalid(batch)
+	delay := q.opts.BaseDelay
+	for len(pending) > 0 {
+		resActive, errActive, resTrapper, errTrapper := q.sender.SendMetricsContext(q.ctx, pending)
+		retryActive, errActive := q.settle(categoryMetrics(pending, true), resActive, errActive)
+		retryTrapper, errTrapper := q.settle(categoryMetrics(pending, false), resTrapper, errTrapper)
+		if !retryActive && !retryTrapper {
+			return nil, true
+		}
+
+		// only the categories that failed are sent again
+		retry := pending[:0:0]
+		for _, m := range pending {
+			if (m.Active && retryActive) || (!m.Active && retryTrapper) {
+				retry = append(retry, m)
+			}
+		}
+		pending = retry
+
+		if q.opts.OnError != nil {
+			q.opts.OnError(errors.Join(errActive, errTrapper))
+		}
+
+		// full jitter: wait a random duration up to the current delay
+		wait := time.Duration(rand.Int63n(int64(delay)) + 1)
+		select {
+		case <-time.After(wait):
+		case <-q.ctx.Done():
+			return pending, false
+		}
+
+		delay *= 2
+		if delay > q.opts.MaxDelay {
+			delay = q.opts.MaxDelay
+		}
+	}
+	return nil, true
+}
+
+// dropInvalid dead-letters the metrics of batch the Sender rejects on validation,
+// so that they do not fail the valid metrics of their category, and returns the others.
+func (q *Queue) dropInvalid(batch []*Metric) []*Metric {
+	if !q.sender.StrictValidation {
+		return batch
+	}
+	valid := make([]*Metric, 0, len(batch))
+	for _, m := range batch {
+		var err error
+		for _, p := range q.sender.prepareMetrics([]*Metric{m}) {
+			err = p.Validate()
+		}
+		if err != nil {
+			q.deadLetter([]*Metric{m}, err)
+			continue
+		}
+		valid = append(valid, m)
+	}
+	return valid
+}
+
+// settle handles the result of sending the metrics of one category. It reports
+// whether they must be sent again after a transient failure, with the error.
+func (q *Queue) settle(metrics []*Metric, res Response, err error) (retry bool, _ error) {
+	if len(metrics) == 0 {
+		return false, nil
+	}
+	var mismatch *ItemTypeMismatchError
+	if err == nil || errors.As(err, &mismatch) {
+		info, infoErr := res.GetInfo()
+		if infoErr != nil || info.Failed == 0 || q.sender.anyProcessedAccepted(res) {
+			return false, nil
+		}
+		// the batch was sent already: bisection starts from its halves
+		prepared, origin, _ := q.sender.prepareFailing(metrics) // validated by dropInvalid
+		var failing []*Metric
+		if failing, err = q.sender.bisectFailing(q.ctx, prepared, metrics[0].Active, info.Failed); err == nil {
+			if len(failing) > 0 {
+				q.deadLetter(originMetrics(failing, origin), fmt.Errorf("counted as failed items by the server (%s)", res.Info))
+			}
+			return false, nil
+		}
+	}
+	if permanentError(err) {
+		q.deadLetter(metrics, err)
+		return false, nil
+	}
+	return true, err
+}
+
+// deadLetter hands metrics that can not be delivered to QueueOptions.DeadLetter,
+// or drops them and reports the reason.
+func (q *Queue) deadLetter(metrics []*Metric, reason error) {
+	if q.opts.DeadLetter != nil {
+		q.opts.DeadLetter(metrics, reason)
+		return
+	}
+	err := fmt.Errorf("dropping %d undeliverable metrics: %w", len(metrics), reason)
+	q.sender.logger().Warnf("queue: %v", err)
+	if q.opts.OnError != nil {
+		q.opts.OnError(err)
+	}
+}
+
+// categoryMetrics returns the active or trapper metrics of metrics, in order.
+func categoryMetrics(metrics []*Metric, active bool) []*Metric {
+	var category []*Metric
+	for _, m := range metrics {
+		if m.Active == active {
+			category = append(category, m)
+		}
+	}
+	return category
+}
+
+// ack replaces the first n metrics of the queue, a batch, by the ones of the
+// batch still pending.
+func (q *Queue) ack(n int, pending []*Metric) {
+	q.mu.Lock()
+	if len(pending) == 0 {
+		q.items = q.items[n:]
+	} else {
+		q.items = append(pending, q.items[n:]...)
+	}
+	q.mu.Unlock()
+}
+
+// Shutdown stops accepting metrics and waits for the queue to drain.
+// When ctx expires first, delivery is stopped and the undelivered metrics are
+// passed to QueueOptions.Persist. Without Persist they are reported as an error.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.closing)
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+	}
+	q.cancel()
+	<-q.done
+
+	q.mu.Lock()
+	remainder := q.items
+	q.items = nil
+	q.mu.Unlock()
+
+	if len(remainder) == 0 {
+		return nil
+	}
+	if q.opts.Persist == nil {
+		return fmt.Errorf("%d metrics not delivered: %w", len(remainder), ctx.Err())
+	}
+	if err := q.opts.Persist(remainder); err != nil {
+		return fmt.Errorf("persisting %d undelivered metrics: %w", len(remainder), err)
+	}
+	return nil
+}
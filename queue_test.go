@@ -0,0 +1,255 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveQueueMock answers each connection, failing the first `fail` of them.
+func serveQueueMock(mock *mockZabbixServer, fail int32, delivered *int32) {
+	var conns int32
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		if atomic.AddInt32(&conns, 1) <= fail {
+			// Drop the connection without answering
+			conn.Close()
+			continue
+		}
+
+		atomic.AddInt32(delivered, int32(len(request.Data)))
+		jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, len(request.Data), len(request.Data))
+		mock.writeZabbixResponse(conn, jsonResp)
+		conn.Close()
+	}
+}
+
+func TestQueueEnqueueDrain(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var delivered int32
+	go serveQueueMock(mock, 0, &delivered)
+
+	q := NewQueue(NewSender(mock.address), QueueOptions{BatchSize: 4})
+	for i := 0; i < 10; i++ {
+		if err := q.Enqueue(NewMetric("zabbixTrapper1", fmt.Sprintf("item%d", i), "1", false)); err != nil {
+			t.Fatalf("error enqueuing: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should drain the queue: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&delivered); n != 10 {
+		t.Errorf("expected 10 delivered metrics, got %d", n)
+	}
+	if q.Len() != 0 {
+		t.Errorf("expected empty queue, got %d", q.Len())
+	}
+	if err := q.Enqueue(NewMetric("zabbixTrapper1", "late", "1", false)); err != ErrQueueClosed {
+		t.Errorf("expected ErrQueueClosed after Shutdown, got %v", err)
+	}
+}
+
+func TestQueueRetriesFailedDelivery(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var delivered int32
+	go serveQueueMock(mock, 2, &delivered)
+
+	var failures int32
+	q := NewQueue(NewSender(mock.address), QueueOptions{
+		BaseDelay: 10 * time.Millisecond,
+		OnError:   func(error) { atomic.AddInt32(&failures, 1) },
+	})
+	q.Enqueue(NewMetric("zabbixTrapper1", "ping", "1", false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should deliver after retries: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&failures); n != 2 {
+		t.Errorf("expected 2 failed attempts, got %d", n)
+	}
+	if n := atomic.LoadInt32(&delivered); n != 1 {
+		t.Errorf("expected 1 delivered metric, got %d", n)
+	}
+}
+
+func TestQueueShutdownPersistsRemainder(t *testing.T) {
+	// Nothing listens here, every delivery fails
+	mock := newMockZabbixServer(t)
+	address := mock.address
+	mock.Close()
+
+	var persisted []*Metric
+	q := NewQueue(NewSender(address), QueueOptions{
+		BaseDelay: 10 * time.Millisecond,
+		Persist: func(metrics []*Metric) error {
+			persisted = metrics
+			return nil
+		},
+	})
+	q.Enqueue(NewMetric("zabbixTrapper1", "ping", "1", false), NewMetric("zabbixTrapper1", "pong", "2", false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown with Persist should not fail: %v", err)
+	}
+
+	if len(persisted) != 2 || persisted[0].Key != "ping" || persisted[1].Key != "pong" {
+		t.Errorf("expected both undelivered metrics persisted in order, got %v", persisted)
+	}
+}
+
+func TestQueueDeadLettersPermanentFailures(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go serveFailingMock(mock, &requests)
+
+	s := NewSender(mock.address)
+	s.StrictValidation = true
+
+	var mu sync.Mutex
+	dead := make(map[string]error)
+	q := NewQueue(s, QueueOptions{
+		BaseDelay: 10 * time.Millisecond,
+		DeadLetter: func(metrics []*Metric, reason error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, m := range metrics {
+				dead[m.Key] = reason
+			}
+		},
+	})
+	q.Enqueue(
+		NewMetric("zabbixTrapper1", "ok1", "1", false),
+		NewMetric("zabbixTrapper1", "bad", "2", false),
+		NewMetric("zabbixTrapper1", "net.if.in[eth0", "3", false),
+		NewMetric("zabbixTrapper1", "ok2", "4", true),
+	)
+	if err := q.Enqueue(nil); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("expected ErrInvalidMetric for a nil metric, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should not block on permanent failures: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) != 2 {
+		t.Fatalf("expected the failed item and the invalid metric dead-lettered, got %v", dead)
+	}
+	if !errors.Is(dead["net.if.in[eth0"], ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for the invalid metric, got %v", dead["net.if.in[eth0"])
+	}
+	if dead["bad"] == nil {
+		t.Errorf("expected the item the server failed dead-lettered, got %v", dead)
+	}
+}
+
+func TestQueueBisectsWithoutResendingBatch(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go serveFailingMock(mock, &requests)
+
+	var dead []*Metric
+	q := NewQueue(NewSender(mock.address), QueueOptions{
+		DeadLetter: func(metrics []*Metric, reason error) {
+			dead = append(dead, metrics...)
+		},
+	})
+	q.Enqueue(
+		NewMetric("zabbixTrapper1", "ok1", "1", false),
+		NewMetric("zabbixTrapper1", "ok2", "2", false),
+		NewMetric("zabbixTrapper1", "bad", "3", false),
+		NewMetric("zabbixTrapper1", "ok3", "4", false),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	if len(dead) != 1 || dead[0].Key != "bad" {
+		t.Fatalf("expected only the failed item dead-lettered, got %v", dead)
+	}
+	// the batch, its two halves and the two quarters of the failing half
+	if got := atomic.LoadInt32(&requests); got != 5 {
+		t.Errorf("expected 5 requests, got %d", got)
+	}
+}
+
+func TestQueueDropsRejectedPackets(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(&requests, 1)
+				mock.writeZabbixResponse(conn, `{"response":"failed","info":"cannot parse request"}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	var errs int32
+	q := NewQueue(NewSender(mock.address), QueueOptions{
+		BaseDelay: 10 * time.Millisecond,
+		OnError: func(err error) {
+			var rejected *ServerRejectedError
+			if errors.As(err, &rejected) {
+				atomic.AddInt32(&errs, 1)
+			}
+		},
+	})
+	q.Enqueue(NewMetric("zabbixTrapper1", "ping", "1", false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown should not block on a rejected packet: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected the rejected packet not to be retried, got %d requests", n)
+	}
+	if n := atomic.LoadInt32(&errs); n != 1 {
+		t.Errorf("expected the drop reported once, got %d", n)
+	}
+}
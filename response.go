@@ -1,8 +1,13 @@
 package zabbix_sender
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +24,97 @@ type Response struct {
 	Response string        `json:"response"`
 	Info     string        `json:"info"`
 	Redirect *RedirectInfo `json:"redirect,omitempty"`
+
+	// Data and Regexp are set by "active checks" responses, see ActiveChecks.
+	Data   json.RawMessage `json:"data,omitempty"`
+	Regexp []Regexp        `json:"regexp,omitempty"`
+
+	// Items holds the per-item results of a data packet, parsed from Data, for
+	// receivers reporting them; nil otherwise. See FailedItems.
+	Items []ItemResult `json:"-"`
+
+	// Structured statistics, set only by receivers that report them as JSON
+	// fields instead of embedding them in Info.
+	Processed    *int     `json:"processed,omitempty"`
+	Failed       *int     `json:"failed,omitempty"`
+	Total        *int     `json:"total,omitempty"`
+	SecondsSpent *float64 `json:"seconds_spent,omitempty"`
+
+	// Parts holds the individual responses when the receiver answered with a JSON
+	// array of per sub-batch responses, or when SendMetrics split the metrics
+	// (see Sender.MaxPacketBytes); the other fields are then their aggregate.
+	Parts []Response `json:"-"`
+
+	// Extra holds the fields not modeled above, see Sender.CaptureUnknownFields.
+	Extra map[string]json.RawMessage `json:"-"`
+
+	// Flags are the protocol flags of the response frame, e.g. whether the
+	// server compressed its reply.
+	Flags HeaderFlags `json:"-"`
+}
+
+// responseFields are the JSON names of the fields of Response.
+var responseFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Response{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// unknownFields returns the fields of a JSON response object that Response does not model.
+func unknownFields(data []byte) map[string]json.RawMessage {
+	var all map[string]json.RawMessage
+	if json.Unmarshal(data, &all) != nil {
+		return nil
+	}
+	for name := range all {
+		if responseFields[name] {
+			delete(all, name)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return all
+}
+
+// aggregateResponses combines the responses of sub-batches into one. The first
+// non-success part determines the result; otherwise the statistics are summed.
+func aggregateResponses(parts []Response) Response {
+	res := Response{Response: "success", Parts: parts}
+
+	var sum ResponseInfo
+	var infos []string
+	parsed := true
+	for i := range parts {
+		if parts[i].Response != "success" {
+			failed := parts[i]
+			failed.Parts = parts
+			return failed
+		}
+		infos = append(infos, parts[i].Info)
+
+		info, err := parts[i].GetInfo()
+		if err != nil {
+			parsed = false
+			continue
+		}
+		sum.Processed += info.Processed
+		sum.Failed += info.Failed
+		sum.Total += info.Total
+		sum.Spent += info.Spent
+	}
+
+	if parsed {
+		res.Info = fmt.Sprintf("processed: %d; failed: %d; total: %d; seconds spent: %.6f", sum.Processed, sum.Failed, sum.Total, sum.Spent.Seconds())
+	} else {
+		res.Info = strings.Join(infos, " | ")
+	}
+	return res
 }
 
 // ResponseInfo struct holds parsed statistics from response "info" field.
@@ -39,54 +135,249 @@ func parseHostPort(addr string) (string, error) {
 	return addr, nil
 }
 
+// unixPrefix prefixes the socket path of hosts reached over a Unix domain socket,
+// e.g. "unix:///run/zabbix/proxy.sock".
+const unixPrefix = "unix://"
+
+// hostNetwork returns the network and address to dial host with.
+func hostNetwork(host string) (network, address string) {
+	if path, ok := strings.CutPrefix(host, unixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", host
+}
+
 // normalizeHost ensures the address has a port; defaults to 10051 if missing.
+// IPv6 literals, bare or bracketed, are returned in the bracketed [addr]:port form.
+// Unix socket addresses are returned unchanged.
 func normalizeHost(addr string) string {
 	addr = strings.TrimSpace(addr)
-	if addr == "" {
+	if addr == "" || strings.HasPrefix(addr, unixPrefix) {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
 		return addr
 	}
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		return net.JoinHostPort(addr[1:len(addr)-1], "10051")
+	}
+	if strings.Count(addr, ":") > 1 {
+		// bare IPv6 literal, can not carry a port
+		return net.JoinHostPort(addr, "10051")
+	}
 	if strings.Contains(addr, ":") {
 		return addr
 	}
 	return addr + ":10051"
 }
 
-// GetInfo parses success response "info" field into statistics.
+// InfoOptions configures GetInfoOptions.
+type InfoOptions struct {
+	// CheckConsistency makes GetInfoOptions return ErrInconsistentInfo, together with
+	// the parsed statistics, when total != processed + failed.
+	CheckConsistency bool
+
+	// SpentUnits maps additional "info" keys reporting the time spent to their unit,
+	// e.g. {"ns spent": time.Nanosecond}, for receivers not covered by DefaultSpentUnits.
+	SpentUnits map[string]time.Duration
+
+	// GroupSeparators are characters removed from the "info" counts before parsing,
+	// for receivers formatting them with thousands separators, e.g. "," for "1,234".
+	GroupSeparators string
+}
+
+// atoi parses an "info" count, removing the GroupSeparators first.
+func (o InfoOptions) atoi(value string) (int, error) {
+	if o.GroupSeparators != "" {
+		value = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(o.GroupSeparators, r) {
+				return -1
+			}
+			return r
+		}, value)
+	}
+	return strconv.Atoi(value)
+}
+
+// DefaultSpentUnits are the "info" keys recognized as the time spent, with their unit.
+// Zabbix reports "seconds spent"; the others are used by forked receivers.
+var DefaultSpentUnits = map[string]time.Duration{
+	"seconds spent":      time.Second,
+	"ms spent":           time.Millisecond,
+	"milliseconds spent": time.Millisecond,
+	"us spent":           time.Microsecond,
+	"µs spent":           time.Microsecond,
+	"microseconds spent": time.Microsecond,
+}
+
+// spentUnit returns the unit of the time spent reported under key.
+func (o InfoOptions) spentUnit(key string) (time.Duration, bool) {
+	if unit, ok := o.SpentUnits[key]; ok {
+		return unit, true
+	}
+	unit, ok := DefaultSpentUnits[key]
+	return unit, ok
+}
+
+// GetInfo parses success response statistics.
+// Structured JSON fields are preferred when present, otherwise the "info" field is parsed.
 func (r *Response) GetInfo() (*ResponseInfo, error) {
-	ret := new(ResponseInfo)
+	return r.GetInfoOptions(InfoOptions{})
+}
+
+// GetInfoOptions is like GetInfo with additional checks of the parsed statistics.
+func (r *Response) GetInfoOptions(opts InfoOptions) (*ResponseInfo, error) {
+	info, err := r.parseInfo(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CheckConsistency && info.Total != info.Processed+info.Failed {
+		return info, fmt.Errorf("%w (processed: %d; failed: %d; total: %d)", ErrInconsistentInfo, info.Processed, info.Failed, info.Total)
+	}
+
+	return info, nil
+}
 
+// parseInfo parses the statistics from structured fields or the "info" field.
+func (r *Response) parseInfo(opts InfoOptions) (*ResponseInfo, error) {
 	if r.Response != "success" {
 		return nil, fmt.Errorf("Can not process info if response not Success (%s)", r.Response)
 	}
+	return r.parseStats(opts)
+}
+
+// parseStats parses the statistics regardless of the response status.
+// The "info" field is read as ";" separated "label: value" pairs in any order;
+// unknown labels are ignored, processed, failed and total are required.
+func (r *Response) parseStats(opts InfoOptions) (*ResponseInfo, error) {
+	ret := new(ResponseInfo)
 
-	sp := strings.Split(r.Info, ";")
-	if len(sp) != 4 {
-		return nil, fmt.Errorf("Error in splited data, expected 4 got %d for data (%s)", len(sp), r.Info)
+	if r.hasStructuredInfo() {
+		return r.structuredInfo(), nil
 	}
-	for i := range sp {
-		sp2 := strings.Split(sp[i], ":")
-		if len(sp2) != 2 {
-			return nil, fmt.Errorf("Error in splited data, expected 2 got %d for data (%s)", len(sp2), sp[i])
+
+	fields := make(map[string]string)
+	var labels []string // in order of appearance
+	for _, segment := range strings.Split(r.Info, ";") {
+		key, value, ok := strings.Cut(segment, ":")
+		if !ok {
+			continue
 		}
-		key := strings.TrimSpace(sp2[0])
-		value := strings.TrimSpace(sp2[1])
-		var err error
-		switch key {
-		case "processed":
-			ret.Processed, err = strconv.Atoi(value)
-		case "failed":
-			ret.Failed, err = strconv.Atoi(value)
-		case "total":
-			ret.Total, err = strconv.Atoi(value)
-		case "seconds spent":
-			var f float64
-			if f, err = strconv.ParseFloat(value, 64); err != nil {
-				return nil, fmt.Errorf("Error in parsing seconds spent value [%s] error: %s", value, err)
-			}
-			ret.Spent = time.Duration(int64(f * 1000000000.0))
+		key = strings.TrimSpace(key)
+		fields[key] = strings.TrimSpace(value)
+		labels = append(labels, key)
+	}
+
+	var missing []string
+	for _, count := range []struct {
+		key string
+		dst *int
+	}{{"processed", &ret.Processed}, {"failed", &ret.Failed}, {"total", &ret.Total}} {
+		value, ok := fields[count.key]
+		if !ok {
+			missing = append(missing, count.key)
+			continue
+		}
+		n, err := opts.atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s value [%s] of info (%s): %w", count.key, value, r.Info, err)
 		}
+		*count.dst = n
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("info (%s) is missing %s", r.Info, strings.Join(missing, ", "))
+	}
 
+	for _, key := range labels {
+		unit, ok := opts.spentUnit(key)
+		if !ok {
+			continue
+		}
+		value := fields[key]
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error in parsing %s value [%s] error: %s", key, value, err)
+		}
+		ret.Spent = time.Duration(int64(f * float64(unit)))
 	}
 
 	return ret, nil
 }
+
+// hasStructuredInfo reports whether the response carries statistics as JSON fields.
+func (r *Response) hasStructuredInfo() bool {
+	return r.Processed != nil || r.Failed != nil || r.Total != nil
+}
+
+// structuredInfo builds statistics from the structured JSON fields.
+func (r *Response) structuredInfo() *ResponseInfo {
+	ret := new(ResponseInfo)
+	if r.Processed != nil {
+		ret.Processed = *r.Processed
+	}
+	if r.Failed != nil {
+		ret.Failed = *r.Failed
+	}
+	if r.Total != nil {
+		ret.Total = *r.Total
+	}
+	if r.SecondsSpent != nil {
+		ret.Spent = time.Duration(int64(*r.SecondsSpent * 1000000000.0))
+	}
+	return ret
+}
+
+// ResponseDecoder reads consecutive framed responses from a stream,
+// e.g. a connection reused for several packets.
+type ResponseDecoder struct {
+	r *bufio.Reader
+
+	// Separators are bytes skipped between frames, for frontends that delimit
+	// frames with a newline or similar. The protocol itself uses none.
+	Separators []byte
+}
+
+// NewResponseDecoder returns a decoder reading from r.
+func NewResponseDecoder(r io.Reader) *ResponseDecoder {
+	return &ResponseDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next response. It returns io.EOF when the stream ends between frames.
+func (d *ResponseDecoder) Decode() (res Response, err error) {
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return res, err
+		}
+		if bytes.IndexByte(d.Separators, b) < 0 {
+			d.r.UnreadByte()
+			break
+		}
+	}
+
+	frame, err := readFrame(d.r)
+	if err != nil {
+		return res, err
+	}
+	return decodeResponse(frame, "stream")
+}
+
+// DecodeResponses reads all framed responses from r until EOF.
+// separators are skipped between frames.
+func DecodeResponses(r io.Reader, separators ...byte) ([]Response, error) {
+	d := NewResponseDecoder(r)
+	d.Separators = separators
+
+	var responses []Response
+	for {
+		res, err := d.Decode()
+		if err == io.EOF {
+			return responses, nil
+		}
+		if err != nil {
+			return responses, fmt.Errorf("decoding response %d: %w", len(responses)+1, err)
+		}
+		responses = append(responses, res)
+	}
+}
@@ -0,0 +1,73 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures the retries of sends that failed on every host with a
+// transient network error (connection refused or reset, timeouts), e.g. while
+// a proxy restarts. A clean rejection by the server is never retried.
+type RetryPolicy struct {
+	MaxAttempts int           // passes over the hosts, the first included; < 2 disables retries
+	BaseDelay   time.Duration // delay before the first retry, default 100ms
+	MaxDelay    time.Duration // cap of the doubling delay, default 10s
+}
+
+// backoff returns the delay before retry n (1 for the first retry): the base
+// delay doubled for each retry, capped by MaxDelay, with the upper half jittered
+// so that senders restarted together do not retry in lockstep.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// sendWithRetryPolicy sends enc to the hosts, repeating the whole pass per
+// RetryPolicy while it fails with a transient network error.
+func (s *Sender) sendWithRetryPolicy(ctx context.Context, enc *EncodedPacket, tmo Timeouts) (res Response, host string, err error) {
+	res, host, err = s.sendToHosts(ctx, enc, tmo)
+	for attempt := 1; attempt < s.RetryPolicy.MaxAttempts && isTransientNetError(err); attempt++ {
+		select {
+		case <-time.After(s.RetryPolicy.backoff(attempt)):
+		case <-ctx.Done():
+			return res, host, err
+		}
+		res, host, err = s.sendToHosts(ctx, enc, tmo)
+	}
+	return res, host, err
+}
+
+// isTransientNetError reports whether err is a network failure worth retrying:
+// connection refused or reset, or a timeout. A failure of several hosts is
+// transient when any of them failed that way.
+func isTransientNetError(err error) bool {
+	var rejected *ServerRejectedError
+	if err == nil || errors.As(err, &rejected) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,114 @@
+package zabbix_sender
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveResettingMock resets the first n connections, then answers with jsonResp.
+func serveResettingMock(mock *mockZabbixServer, n int32, jsonResp string, attempts *int32, times chan<- time.Time) {
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		times <- time.Now()
+		if atomic.AddInt32(attempts, 1) <= n {
+			conn.(*net.TCPConn).SetLinger(0) // reset instead of a clean close
+			conn.Close()
+			continue
+		}
+		if _, err := mock.readZabbixRequest(conn); err == nil {
+			mock.writeZabbixResponse(conn, jsonResp)
+		}
+		conn.Close()
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var attempts int32
+	times := make(chan time.Time, 10)
+	go serveResettingMock(mock, 2, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`, &attempts, times)
+
+	base := 40 * time.Millisecond
+	s := NewSender(mock.address)
+	s.RetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: base, MaxDelay: time.Second}
+
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %s", res.Response)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	// Retry n waits between half and all of base * 2^(n-1)
+	first, second, third := <-times, <-times, <-times
+	for i, gap := range []struct {
+		got      time.Duration
+		min, max time.Duration
+	}{
+		{second.Sub(first), base / 2, base + 50*time.Millisecond},
+		{third.Sub(second), base, 2*base + 50*time.Millisecond},
+	} {
+		if gap.got < gap.min || gap.got > gap.max {
+			t.Errorf("retry %d: expected delay in [%v, %v], got %v", i+1, gap.min, gap.max, gap.got)
+		}
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var attempts int32
+	go serveResettingMock(mock, 10, "", &attempts, make(chan time.Time, 10))
+
+	s := NewSender(mock.address)
+	s.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil {
+		t.Fatal("expected error after exhausting the attempts")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestRetryPolicyNotOnRejection(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var attempts int32
+	go serveResettingMock(mock, 0, `{"response":"failed","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000030"}`, &attempts, make(chan time.Time, 10))
+
+	s := NewSender(mock.address)
+	s.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil {
+		t.Fatal("expected the rejection")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("expected a rejection not to be retried, got %d attempts", n)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for n, max := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 10: 300} {
+		max *= time.Millisecond
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(n); d < max/2 || d > max {
+				t.Fatalf("backoff(%d): expected in [%v, %v], got %v", n, max/2, max, d)
+			}
+		}
+	}
+}
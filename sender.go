@@ -1,48 +1,599 @@
 package zabbix_sender
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
 	"net"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// zabbixHeader is the protocol magic followed by the flags of an uncompressed frame.
+const zabbixHeader = "ZBXD\x01"
+
+// maxResponseBytes bounds the data length, and decompressed length, a response
+// header may declare: the 8 byte lengths of large packets reach 2^64.
+const maxResponseBytes = 1 << 30
+
+// utf8BOM is the byte order mark some frontends prepend to the JSON response.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
 // Sender struct.
+// A Sender is safe for concurrent use by multiple goroutines once configured:
+// the state it updates while sending (PrimaryHost, host health, statistics) is
+// synchronized. Fields must not be changed while sends are in flight.
 type Sender struct {
 	Hosts          []string // ordered list of proxies/servers; first successful cached in PrimaryHost
-	PrimaryHost    string   // cached working host (empty = round-robin first)
+	PrimaryHost    string   // cached working host (empty = round-robin first); ignored when not in (resolved) Hosts, unless cached by UpdateHost
 	MaxRedirects   int      // max redirect attempts bedore error; default is 3
-	UpdateHost     bool     // if true, update s.Host to final proxy after success
+	UpdateHost     bool     // if true, cache the final host of a redirect chain in PrimaryHost after success
 	ConnectTimeout time.Duration
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
+	ClientName     string // optional client name/version sent in each packet for server-side identification
+
+	// UseLocalHostname fills an empty Metric.Host with the local machine name (os.Hostname).
+	UseLocalHostname bool
+
+	// Compression enables zlib compressed frames for packets larger than CompressMinBytes.
+	Compression      bool
+	CompressMinBytes int // uncompressed JSON size a packet must exceed to be compressed
+
+	// CompressAuto detects per host whether the server accepts compressed frames:
+	// when the server rejects the first compressed send to a host, closing the
+	// connection without answering or answering with an invalid header, the packet
+	// is sent again uncompressed and the host is remembered as not capable. Other
+	// failures, timeouts included, are not retried. Compression takes precedence
+	// and compresses for every host.
+	CompressAuto bool
+
+	// HostCooldown skips a host that failed less than HostCooldown ago in the
+	// fallback over the hosts, instead of waiting for its timeout on every send.
+	// When all hosts are in cooldown all are tried. 0 disables skipping.
+	HostCooldown time.Duration
+
+	// RetryPolicy repeats the pass over all hosts with backoff when every host
+	// failed with a transient network error. The zero value does not retry.
+	RetryPolicy RetryPolicy
+
+	// RetryOnFailedInfo, when set, is called with the info of a rejected response,
+	// or of a success response with failed items. When it reports the failure as
+	// transient (e.g. value cache busy) the whole packet is sent again after
+	// FailedInfoRetryDelay (default 100ms), up to FailedInfoRetries times (default 1).
+	// Items already processed are then stored again.
+	RetryOnFailedInfo    func(info string) bool
+	FailedInfoRetries    int
+	FailedInfoRetryDelay time.Duration
+
+	// MaxPacketBytes splits the metrics of each category in SendMetrics into packets
+	// of at most MaxPacketBytes of JSON, sent one after the other, for receivers
+	// limiting the request size. The responses are aggregated. 0 sends one packet.
+	MaxPacketBytes int
+
+	// PacketSizeLimit fails any packet with more than PacketSizeLimit bytes of
+	// JSON with ErrPacketTooLarge before it is written, e.g. a single metric too
+	// large for MaxPacketBytes chunking, instead of the receiver resetting the
+	// connection. 0 disables the check.
+	PacketSizeLimit int
+
+	// SampleRate is the fraction of series (host and key) SendMetrics keeps, for high
+	// cardinality debug metrics. Series are kept or dropped consistently across sends.
+	// Values outside (0, 1) disable sampling; the default is 1.
+	SampleRate float64
+
+	// SuccessIfAnyProcessed accepts a response whose info reports processed > 0 as
+	// a success for best effort bulk sends, even with failed items: they are not
+	// retried by RetryOnFailedInfo, and a non-success response without redirect, e.g.
+	// aggregated from sub-batches, is not an error. Response and Info are kept as
+	// the server sent them.
+	SuccessIfAnyProcessed bool
+
+	// TLSConfig enables TLS with certificates for all hosts, to match TLSConnect=cert.
+	// It holds the CA pool, the client certificate and optionally the ServerName,
+	// which defaults to the host name of each address. Nil means plaintext.
+	TLSConfig *tls.Config
+
+	// TLSConfigForHost returns the TLS config for a host, or nil to connect in
+	// plaintext, e.g. while migrating an HA group to TLS. It takes precedence over TLSConfig.
+	TLSConfigForHost func(host string) *tls.Config
+
+	// TLSPSKIdentity and TLSPSKKey encrypt connections with a pre-shared key, to
+	// match TLSConnect=psk. They take precedence over TLSConfigForHost. crypto/tls
+	// has no PSK cipher suites, so PSKHandshake must provide the handshake, e.g.
+	// with an OpenSSL binding; without it sends fail with ErrPSKUnsupported.
+	TLSPSKIdentity string
+	TLSPSKKey      []byte
+
+	// PSKHandshake performs the TLS PSK handshake over conn and returns the
+	// encrypted connection. It must honor ctx and the deadline set on conn.
+	PSKHandshake func(ctx context.Context, conn net.Conn, identity string, key []byte) (net.Conn, error)
+
+	// RedirectsInGroupOnly only follows redirects to one of Hosts (as resolved by
+	// Resolver) or RedirectAllowlist. Other redirects fail with ErrRedirectNotAllowed,
+	// a redirect out of the proxy group is a misconfiguration or an attack.
+	RedirectsInGroupOnly bool
+	// RedirectAllowlist adds host[:port] addresses and CIDRs redirects may point to.
+	// CIDRs only match redirects to IP addresses, host names are not resolved.
+	RedirectAllowlist []string
+
+	// CaptureUnknownFields stores response fields the library does not know in
+	// Response.Extra, to discover new or vendor specific protocol fields.
+	CaptureUnknownFields bool
+
+	// Transforms are applied in order to a copy of each metric in SendMetrics, e.g.
+	// to rename keys or scale values. A transform returning nil drops the metric.
+	Transforms []func(*Metric) *Metric
+
+	// DuplicateActiveChecks is how GetActiveChecks handles an item key listed more
+	// than once. The default keeps all entries.
+	DuplicateActiveChecks DuplicateChecks
+
+	// StrictValidation validates metrics (see Metric.Validate) in SendMetrics before
+	// any network I/O, failing the batch with the offending indices. Without it
+	// invalid metrics are sent and counted as failed items by the server. Nil
+	// metrics are rejected either way.
+	StrictValidation bool
+
+	// MaxUniqueKeys fails SendMetrics with ErrTooManyKeys, before any network I/O,
+	// when a batch has more distinct item keys, a guardrail against runaway metric
+	// generation. 0 is unlimited.
+	MaxUniqueKeys int
+
+	// Logger, when set, receives diagnostics: each dial attempt, redirect hop and
+	// the outcome of each send. Nil logs nothing.
+	Logger Logger
+
+	// OnSend is called after each attempt to send a packet to a host, e.g. for logging.
+	OnSend func(SendEvent)
+
+	// OnRedirect is called for each redirect followed, e.g. to observe a proxy
+	// group rebalancing, with the revision of the redirect. It does not affect the send.
+	OnRedirect func(from, to string, revision int)
+
+	// OnWire, when set, is called with each frame written (WireWrite) and read
+	// (WireRead), header included, to diagnose protocol mismatches. Bytes beyond
+	// the declared length of a response are not included. data must not be modified.
+	OnWire func(direction string, data []byte)
+
+	// Dialer, when set, connects to the hosts, e.g. to bind a source address with
+	// LocalAddr or set socket options with Control. ConnectTimeout applies when
+	// its Timeout is 0.
+	Dialer *net.Dialer
+
+	// DialContext, when set, connects to the hosts instead of Dialer, e.g.
+	// through a SOCKS proxy. Its ctx carries the configured host being sent to,
+	// see HostFromContext, and expires after ConnectTimeout. The network is "tcp",
+	// or "unix" with the socket path for hosts prefixed unix://.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// KeepAlive keeps the connection to each host open after a send and reuses
+	// it for the next one, redialing when the receiver closed it. Close releases
+	// the idle connections. Zabbix server and proxy close the connection after
+	// each response; it pays off with receivers keeping connections open.
+	KeepAlive bool
+
+	// Resolver, when set, expands each of the Hosts into actual addresses at send
+	// time, e.g. from service discovery. The addresses of all hosts are tried in
+	// order for the fallback; it is called for every send, cache in it if needed.
+	Resolver func(logical string) ([]string, error)
+
+	// IncludeCorrelationID adds the context correlation ID (see WithCorrelationID) to packets.
+	IncludeCorrelationID bool
+
+	mu            sync.Mutex            // guards PrimaryHost, redirectHost, compressHosts, hostStates and idleConns during sends
+	idleConns     map[string][]net.Conn // see KeepAlive
+	redirectHost  string                // PrimaryHost cached from a redirect by UpdateHost
+	compressHosts map[string]bool       // CompressAuto results per host
+	hostStates    map[string]*hostState // see HostStatus
+
+	latency  latencyHistogram // successful send durations, see LatencyStats
+	counters sendCounters     // see Stats
+}
+
+// primaryHost returns the cached working host.
+func (s *Sender) primaryHost() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.PrimaryHost
+}
+
+// resolveHosts returns the addresses to send to: the Hosts, expanded by the
+// Resolver when set, and for each one the configured host it was resolved from.
+// Hosts the Resolver fails for are skipped and reported in err.
+func (s *Sender) resolveHosts() (hosts, logical []string, err error) {
+	if s.Resolver == nil {
+		return s.Hosts, s.Hosts, nil
+	}
+
+	var errs []error
+	for _, h := range s.Hosts {
+		addrs, err := s.Resolver(h)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolving %s: %w", h, err))
+			continue
+		}
+		hosts = append(hosts, addrs...)
+		for range addrs {
+			logical = append(logical, h)
+		}
+	}
+	return hosts, logical, errors.Join(errs...)
+}
+
+// logicalHost returns the configured host addr was resolved from, see resolveHosts,
+// or addr itself when it is not one of hosts.
+func logicalHost(hosts, logical []string, addr string) string {
+	norm := normalizeHost(addr)
+	for i, h := range hosts {
+		if normalizeHost(h) == norm {
+			return logical[i]
+		}
+	}
+	return addr
+}
+
+// containsHost reports whether host is one of hosts.
+func containsHost(hosts []string, host string) bool {
+	host = normalizeHost(host)
+	for _, h := range hosts {
+		if normalizeHost(h) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryFirst returns hosts and their logical names with the cached primary
+// host moved to the front, so that it is tried first and only once.
+func (s *Sender) primaryFirst(hosts, logical []string) ([]string, []string) {
+	primary := s.primaryHost()
+	if primary == "" {
+		return hosts, logical
+	}
+	norm := normalizeHost(primary)
+	for i, h := range hosts {
+		if normalizeHost(h) != norm {
+			continue
+		}
+		ordered := append([]string{primary}, hosts[:i]...)
+		orderedLogical := append([]string{logical[i]}, logical[:i]...)
+		return append(ordered, hosts[i+1:]...), append(orderedLogical, logical[i+1:]...)
+	}
+	return hosts, logical
+}
+
+// setPrimaryHost caches the working host.
+func (s *Sender) setPrimaryHost(host string) {
+	s.mu.Lock()
+	s.PrimaryHost = host
+	s.redirectHost = ""
+	s.mu.Unlock()
+}
+
+// cacheHost caches the host a send starting at host succeeded on. With UpdateHost
+// that is the last of redirects, which is kept even though it is not one of Hosts.
+func (s *Sender) cacheHost(host string, redirects []string) {
+	if !s.UpdateHost || len(redirects) == 0 {
+		s.setPrimaryHost(host)
+		return
+	}
+	s.mu.Lock()
+	s.PrimaryHost = redirects[len(redirects)-1]
+	s.redirectHost = s.PrimaryHost
+	s.mu.Unlock()
+}
+
+// cachedHost returns the cached working host and whether UpdateHost cached it from a redirect.
+func (s *Sender) cachedHost() (host string, redirected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.PrimaryHost, s.PrimaryHost != "" && s.PrimaryHost == s.redirectHost
+}
+
+// compressFor reports whether packets to host should be compressed, and whether
+// this is a CompressAuto probe of a host whose support is not known yet.
+func (s *Sender) compressFor(host string) (compress, probe bool) {
+	if s.Compression {
+		return true, false
+	}
+	if !s.CompressAuto {
+		return false, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	supported, known := s.compressHosts[host]
+	if known {
+		return supported, false
+	}
+	return true, true
+}
+
+// setCompressSupport caches whether host accepts compressed frames.
+func (s *Sender) setCompressSupport(host string, supported bool) {
+	s.mu.Lock()
+	if s.compressHosts == nil {
+		s.compressHosts = make(map[string]bool)
+	}
+	s.compressHosts[host] = supported
+	s.mu.Unlock()
+}
+
+// String returns a concise summary of the sender configuration for logs.
+// It never includes credentials or other sensitive settings: TLS reports only
+// whether TLSConfig or TLSConfigForHost is set, PSK whether a key is configured.
+func (s *Sender) String() string {
+	tlsMode := "off"
+	if s.TLSConfigForHost != nil {
+		tlsMode = "per host"
+	} else if s.TLSConfig != nil {
+		tlsMode = "on"
+	}
+	return fmt.Sprintf("Sender{hosts: %d %v, primary: %q, timeouts: connect=%v read=%v write=%v, max redirects: %d, compression: %t, tls: %s, psk: %t}",
+		len(s.Hosts), s.Hosts, s.primaryHost(), s.ConnectTimeout, s.ReadTimeout, s.WriteTimeout, s.MaxRedirects, s.Compression,
+		tlsMode, s.TLSPSKIdentity != "" || len(s.TLSPSKKey) > 0)
+}
+
+// GoString returns the same safe summary as String for %#v.
+func (s *Sender) GoString() string {
+	return "zabbix_sender." + s.String()
 }
 
 // getHeader return zabbix header.
 // https://www.zabbix.com/documentation/4.0/manual/appendix/protocols/header_datalen
 func (s *Sender) getHeader() []byte {
-	return []byte("ZBXD\x01")
+	return []byte(zabbixHeader)
+}
+
+// read reads one response frame from conn, using the declared data length, so
+// the server does not need to close the connection. extra is the number of bytes
+// already received beyond the frame, a framing bug of the server.
+func (s *Sender) read(conn net.Conn) (res []byte, extra int, err error) {
+	cr := &countingReader{r: conn}
+	r := bufio.NewReader(cr)
+	if res, err = readFrame(r); err != nil {
+		if cr.n == 0 && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)) {
+			err = &connClosedError{err} // closed without answering
+		}
+		return res, 0, err
+	}
+	return res, r.Buffered(), nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// readFrame reads exactly one protocol frame (header, data length and data) from r.
+func readFrame(r io.Reader) ([]byte, error) {
+	frame := make([]byte, 13, 21)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("receiving header: %w: %w", ErrResponseTooShort, err)
+		}
+		return nil, fmt.Errorf("receiving header: %w", err)
+	}
+	if n := headerLen(frame); n > len(frame) {
+		frame = frame[:n]
+		if _, err := io.ReadFull(r, frame[13:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("receiving header: %w: %w", ErrResponseTooShort, err)
+			}
+			return nil, fmt.Errorf("receiving header: %w", err)
+		}
+	}
+
+	dataLen, _ := frameLengths(frame)
+	if dataLen > maxResponseBytes {
+		return nil, fmt.Errorf("receiving header: %w: %d bytes, the limit is %d", ErrResponseTooLarge, dataLen, maxResponseBytes)
+	}
+	data := make([]byte, dataLen)
+	if n, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("receiving data: %w: got %d of %d bytes", ErrTruncatedResponse, n, len(data))
+		}
+		return nil, fmt.Errorf("receiving data: %w", err)
+	}
+
+	return append(frame, data...), nil
+}
+
+// EncodePacket marshals packet once for sending it with s to several hosts,
+// see SendEncoded. The ClientName and compression threshold of s are applied.
+func (s *Sender) EncodePacket(packet *Packet) (*EncodedPacket, error) {
+	return s.encodePacket(packet, "")
+}
+
+// encodePacket is EncodePacket, also setting correlationID when not empty. The
+// fields are set on a copy, the caller's packet is never modified.
+func (s *Sender) encodePacket(packet *Packet, correlationID string) (*EncodedPacket, error) {
+	p := *packet
+	packet = &p
+	if packet.Client == "" {
+		packet.Client = s.ClientName
+	}
+	if correlationID != "" {
+		packet.CorrelationID = correlationID
+	}
+
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return nil, fmt.Errorf("encoding packet: %w", err)
+	}
+	if s.PacketSizeLimit > 0 && len(data) > s.PacketSizeLimit {
+		return nil, fmt.Errorf("encoding %q packet: %w: %d bytes of JSON, the limit is %d", packet.Request, ErrPacketTooLarge, len(data), s.PacketSizeLimit)
+	}
+	return &EncodedPacket{packet: packet, data: data, compressMinBytes: s.CompressMinBytes}, nil
 }
 
-// read data from connection.
-func (s *Sender) read(conn net.Conn) ([]byte, error) {
-	res, err := io.ReadAll(conn)
+// frame builds the wire bytes (header, data length and JSON data) of a packet.
+// The data is compressed when compress is set and it exceeds CompressMinBytes;
+// compressed reports whether it was.
+func (s *Sender) frame(packet *Packet, compress bool) (buffer []byte, compressed bool, err error) {
+	enc, err := s.EncodePacket(packet)
 	if err != nil {
-		return res, fmt.Errorf("receiving data: %s", err.Error())
+		return nil, false, err
+	}
+	buffer, compressed = enc.frame(compress)
+	return buffer, compressed, nil
+}
+
+// writeFrame writes the whole frame to w before any response is read. A buffered
+// writer (anything with a Flush method) is flushed, so no part of the frame is
+// left waiting in a buffer while the caller waits for the response.
+// A frame interrupted partway is an error: the protocol can not resume it, so
+// callers send the whole frame again over a new connection.
+func writeFrame(w io.Writer, frame []byte) error {
+	n, err := w.Write(frame)
+	if err == nil && n < len(frame) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// headerLen returns the length of the header starting frame: 13 bytes, or 21
+// when the large packet flag widens the data and reserved lengths to 8 bytes.
+func headerLen(frame []byte) int {
+	if len(frame) > 4 && string(frame[:4]) == zabbixHeader[:4] && frame[4]&flagLarge != 0 {
+		return 21
 	}
+	return 13
+}
+
+// frameLengths returns the data length and the reserved length, the size of
+// compressed data once inflated, declared by the header of frame.
+func frameLengths(frame []byte) (dataLen, reserved uint64) {
+	if headerLen(frame) == 21 {
+		return binary.LittleEndian.Uint64(frame[5:13]), binary.LittleEndian.Uint64(frame[13:21])
+	}
+	return uint64(binary.LittleEndian.Uint32(frame[5:9])), uint64(binary.LittleEndian.Uint32(frame[9:13]))
+}
 
+// frameOverflow returns the number of bytes of a frame beyond its declared data length.
+func frameOverflow(frame []byte) int {
+	n := headerLen(frame)
+	if len(frame) < n {
+		return 0
+	}
+	dataLen, _ := frameLengths(frame)
+	if extra := uint64(len(frame) - n); extra > dataLen {
+		return int(extra - dataLen)
+	}
+	return 0
+}
+
+// decodeResponse validates a raw response frame from host and unmarshals its data.
+func decodeResponse(response []byte, host string) (res Response, err error) {
+	data, err := responseData(response, host)
+	if err != nil {
+		return res, err
+	}
+	res, err = parseResponse(data, host)
+	res.Flags = HeaderFlags(response[4])
+	return res, err
+}
+
+// decode is decodeResponse, capturing unknown fields when CaptureUnknownFields is set.
+func (s *Sender) decode(response []byte, host string) (res Response, err error) {
+	data, err := responseData(response, host)
+	if err != nil {
+		return res, err
+	}
+	res, err = parseResponse(data, host)
+	res.Flags = HeaderFlags(response[4])
+	if err != nil || !s.CaptureUnknownFields {
+		return res, err
+	}
+	res.Extra = unknownFields(data)
 	return res, nil
 }
 
-// SendMetrics sends mixed active+trapper metrics.
-// Automatically separates into "agent data" and "sender data" packets.
-// Returns 4 values: (activeRes, activeErr, trapperRes, trapperErr)
-func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
-	var trapperMetrics []*Metric
-	var activeMetrics []*Metric
+// responseData validates a raw response frame from host and returns its JSON data.
+func responseData(response []byte, host string) (data []byte, err error) {
+	if len(response) < 13 {
+		return nil, fmt.Errorf("%w from %s: %d bytes", ErrResponseTooShort, host, len(response))
+	}
+
+	// Any flags are accepted, only the compression and large packet ones change the frame
+	if magic := response[:4]; string(magic) != zabbixHeader[:4] {
+		return nil, fmt.Errorf("%w from %s: got [%+v], expected [%+v]", ErrInvalidHeader, host, magic, []byte(zabbixHeader[:4]))
+	}
+	n := headerLen(response)
+	if len(response) < n {
+		return nil, fmt.Errorf("%w from %s: %d bytes", ErrResponseTooShort, host, len(response))
+	}
+	data = response[n:]
+	if extra := frameOverflow(response); extra > 0 {
+		data = data[:len(data)-extra] // ignore bytes beyond the declared length
+	}
+
+	if HeaderFlags(response[4]).Compressed() {
+		_, size := frameLengths(response)
+		if data, err = inflate(data, size); err != nil {
+			return nil, fmt.Errorf("zabbix response from %s is not valid: %w", host, err)
+		}
+	}
+
+	// Some frontends prepend a UTF-8 BOM or pad the JSON with whitespace
+	data = bytes.TrimSpace(data)
+	return bytes.TrimSpace(bytes.TrimPrefix(data, utf8BOM)), nil
+}
+
+// parseResponse unmarshals the JSON data of a response from host.
+func parseResponse(data []byte, host string) (res Response, err error) {
+
+	// Some frontends answer with an array of per sub-batch responses
+	if len(data) > 0 && data[0] == '[' {
+		var parts []Response
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return res, fmt.Errorf("zabbix response from %s is not valid: %v", host, err)
+		}
+		if len(parts) == 0 {
+			return res, fmt.Errorf("zabbix response from %s is not valid: empty response array", host)
+		}
+		return aggregateResponses(parts), nil
+	}
 
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("zabbix response from %s is not valid: %v", host, err)
+	}
+	res.Items = itemResults(res.Data)
+
+	return res, nil
+}
+
+// rejectedError returns the error for a non-success response without redirect.
+func rejectedError(res Response, host string) error {
+	return &ServerRejectedError{Host: host, Response: res}
+}
+
+// splitMetrics separates active agent metrics from trapper metrics, preserving order.
+func splitMetrics(metrics []*Metric) (activeMetrics []*Metric, trapperMetrics []*Metric) {
 	for i := range metrics {
 		if metrics[i].Active {
 			activeMetrics = append(activeMetrics, metrics[i])
@@ -50,150 +601,865 @@ func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive e
 			trapperMetrics = append(trapperMetrics, metrics[i])
 		}
 	}
+	return activeMetrics, trapperMetrics
+}
 
-	if len(trapperMetrics) > 0 {
+// prepareMetrics applies sender level defaults, the Transforms and sampling to metrics.
+// Metrics that need changes are copied, the caller's metrics are never modified.
+func (s *Sender) prepareMetrics(metrics []*Metric) []*Metric {
+	hostname := ""
+	if s.UseLocalHostname {
+		hostname = localHostname()
+	}
+	sampling := s.SampleRate > 0 && s.SampleRate < 1
+	if hostname == "" && len(s.Transforms) == 0 && !sampling {
+		return metrics
+	}
+
+	prepared := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if m == nil {
+			prepared = append(prepared, m) // rejected by prepareValid
+			continue
+		}
+		if m.Host == "" && hostname != "" {
+			c := *m
+			c.Host = hostname
+			m = &c
+		}
+		if m = s.transform(m); m == nil {
+			continue
+		}
+		if sampling && !sampled(m, s.SampleRate) {
+			continue
+		}
+		prepared = append(prepared, m)
+	}
+	return prepared
+}
+
+// sampled reports whether the series of m is kept at the given rate. The decision
+// depends only on the host and key, a series is consistently kept or dropped.
+func sampled(m *Metric, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(m.Host))
+	h.Write([]byte{0})
+	h.Write([]byte(m.Key))
+	return float64(h.Sum64())/math.MaxUint64 < rate
+}
+
+// transform applies the Transforms in order to a copy of m. It returns nil when
+// a transform drops the metric.
+func (s *Sender) transform(m *Metric) *Metric {
+	if len(s.Transforms) == 0 {
+		return m
+	}
+
+	c := *m
+	m = &c
+	for _, t := range s.Transforms {
+		if m = t(m); m == nil {
+			return nil
+		}
+	}
+	return m
+}
+
+// SendMetrics sends mixed active+trapper metrics.
+// Automatically separates into "agent data" and "sender data" packets.
+// Returns 4 values: (activeRes, activeErr, trapperRes, trapperErr)
+func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	return s.SendMetricsContext(context.Background(), metrics)
+}
+
+// SendMetricsResult holds the outcome of sending mixed metrics. The response of
+// a category is nil when there were no metrics of it.
+type SendMetricsResult struct {
+	Active     *Response
+	ActiveErr  error
+	Trapper    *Response
+	TrapperErr error
+}
+
+// Err returns the errors of both categories joined, nil when both succeeded.
+func (r SendMetricsResult) Err() error {
+	return errors.Join(r.ActiveErr, r.TrapperErr)
+}
+
+// newSendMetricsResult builds the result of sending metrics from the four values of SendMetrics.
+func newSendMetricsResult(metrics []*Metric, resActive Response, errActive error, resTrapper Response, errTrapper error) SendMetricsResult {
+	r := SendMetricsResult{ActiveErr: errActive, TrapperErr: errTrapper}
+	for _, m := range metrics {
+		if m == nil {
+			continue
+		}
+		if m.Active && r.Active == nil {
+			r.Active = &resActive
+		}
+		if !m.Active && r.Trapper == nil {
+			r.Trapper = &resTrapper
+		}
+	}
+	return r
+}
+
+// SendMetricsR is like SendMetrics but returns the outcome as a SendMetricsResult:
+//
+//	if err := sender.SendMetricsR(metrics).Err(); err != nil { ... }
+func (s *Sender) SendMetricsR(metrics []*Metric) SendMetricsResult {
+	resActive, errActive, resTrapper, errTrapper := s.SendMetrics(metrics)
+	return newSendMetricsResult(metrics, resActive, errActive, resTrapper, errTrapper)
+}
+
+// SendMetricsContext is like SendMetrics but bounds the sends by ctx.
+func (s *Sender) SendMetricsContext(ctx context.Context, metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	return s.SendMetricsWithOptions(ctx, metrics, SendMetricsOptions{})
+}
+
+// Timeouts overrides the Sender timeouts for a send. Zero values fall back to the Sender fields.
+type Timeouts struct {
+	Connect time.Duration
+	Read    time.Duration
+	Write   time.Duration
+}
 
-		packetTrapper := NewPacket(trapperMetrics, false)
-		resTrapper, errTrapper = s.Send(packetTrapper)
+// SendMetricsOptions configures SendMetricsWithOptions.
+type SendMetricsOptions struct {
+	Active  Timeouts // timeouts of the "agent data" packet
+	Trapper Timeouts // timeouts of the "sender data" packet
+
+	// FirstSuccess sends both packets concurrently and returns as soon as one of them
+	// succeeds, for best-effort telemetry in degraded setups. The category still
+	// in flight is reported with ErrSendPending: its outcome is never returned,
+	// it keeps running in the background unless AbandonSlower cancels it.
+	// When the first finished category fails, the call waits for the other one.
+	FirstSuccess  bool
+	AbandonSlower bool
+}
+
+// timeouts returns the Sender timeouts with the non-zero values of override applied.
+func (s *Sender) timeouts(override Timeouts) Timeouts {
+	t := Timeouts{Connect: s.ConnectTimeout, Read: s.ReadTimeout, Write: s.WriteTimeout}
+	if override.Connect > 0 {
+		t.Connect = override.Connect
+	}
+	if override.Read > 0 {
+		t.Read = override.Read
+	}
+	if override.Write > 0 {
+		t.Write = override.Write
+	}
+	return t
+}
+
+// prepareBatch prepares the metrics of a SendMetrics batch and checks them
+// before any network I/O: StrictValidation and MaxUniqueKeys.
+func (s *Sender) prepareBatch(metrics []*Metric) ([]*Metric, error) {
+	metrics, err := s.prepareValid(metrics, nil)
+	if err == nil && s.MaxUniqueKeys > 0 {
+		err = checkUniqueKeys(metrics, s.MaxUniqueKeys)
+	}
+	return metrics, err
+}
+
+// batchErrors returns err, the failure of a batch, for the categories of its metrics.
+func batchErrors(metrics []*Metric, err error) (errActive, errTrapper error) {
+	// a nil metric has no category, it fails both
+	for _, m := range metrics {
+		if m == nil || m.Active {
+			errActive = err
+		}
+		if m == nil || !m.Active {
+			errTrapper = err
+		}
+	}
+	return errActive, errTrapper
+}
+
+// SendMetricsWithOptions is like SendMetricsContext with per category settings,
+// e.g. a longer read timeout for active metrics going to a busier endpoint.
+func (s *Sender) SendMetricsWithOptions(ctx context.Context, metrics []*Metric, opts SendMetricsOptions) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	metrics, err := s.prepareBatch(metrics)
+	if err != nil {
+		errActive, errTrapper = batchErrors(metrics, err)
+		return resActive, errActive, resTrapper, errTrapper
+	}
+	activeMetrics, trapperMetrics := splitMetrics(metrics)
+
+	if opts.FirstSuccess && len(activeMetrics) > 0 && len(trapperMetrics) > 0 {
+		return s.sendFirstSuccess(ctx, activeMetrics, trapperMetrics, opts)
+	}
+
+	if len(trapperMetrics) > 0 {
+		resTrapper, errTrapper = s.sendMetricsPacket(ctx, trapperMetrics, false, s.timeouts(opts.Trapper))
 	}
 
 	if len(activeMetrics) > 0 {
-		packetActive := NewPacket(activeMetrics, true)
-		resActive, errActive = s.Send(packetActive)
+		resActive, errActive = s.sendMetricsPacket(ctx, activeMetrics, true, s.timeouts(opts.Active))
 	}
 
 	return resActive, errActive, resTrapper, errTrapper
 }
 
+// sendFirstSuccess sends both categories concurrently and returns on the first success.
+func (s *Sender) sendFirstSuccess(ctx context.Context, activeMetrics, trapperMetrics []*Metric, opts SendMetricsOptions) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	type result struct {
+		active bool
+		res    Response
+		err    error
+	}
+
+	sendCtx := ctx
+	if opts.AbandonSlower {
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+
+	results := make(chan result, 2)
+	go func() {
+		res, err := s.sendMetricsPacket(sendCtx, activeMetrics, true, s.timeouts(opts.Active))
+		results <- result{active: true, res: res, err: err}
+	}()
+	go func() {
+		res, err := s.sendMetricsPacket(sendCtx, trapperMetrics, false, s.timeouts(opts.Trapper))
+		results <- result{active: false, res: res, err: err}
+	}()
+
+	errActive, errTrapper = ErrSendPending, ErrSendPending
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.active {
+			resActive, errActive = r.res, r.err
+		} else {
+			resTrapper, errTrapper = r.res, r.err
+		}
+		if r.err == nil {
+			break
+		}
+	}
+
+	return resActive, errActive, resTrapper, errTrapper
+}
+
+// SendMetricsDeadline is like SendMetrics but gives up at the absolute deadline.
+func (s *Sender) SendMetricsDeadline(deadline time.Time, metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	return s.SendMetricsContext(ctx, metrics)
+}
+
 // Send sends single packet with redirect/HA handling.
 // Caches working PrimaryHost for future calls.
+//
+// When a server answers with a clean non-success response, no other host is tried:
+// Send returns the populated Response together with a *ServerRejectedError.
+//
+// A packet is only sent once a host answered it. When the connection drops while
+// the frame is written, the host got an invalid partial frame it discards; the
+// next host, redirect target or retry receives the whole frame from the start.
 func (s *Sender) Send(packet *Packet) (res Response, err error) {
-	if s.PrimaryHost != "" {
-		res, err = s.sendWithRedirects(packet, s.PrimaryHost)
-		if err == nil {
-			return res, nil
+	return s.SendContext(context.Background(), packet)
+}
+
+// SendContext is like Send but bounds the dials, writes and reads by ctx.
+func (s *Sender) SendContext(ctx context.Context, packet *Packet) (res Response, err error) {
+	return s.send(ctx, packet, s.timeouts(Timeouts{}))
+}
+
+// send sends a packet with redirect/HA handling using the timeouts tmo.
+func (s *Sender) send(ctx context.Context, packet *Packet, tmo Timeouts) (res Response, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("sending packet: %w", err)
+	}
+	if packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+
+	enc, err := s.encodePacket(packet, s.packetCorrelationID(ctx))
+	if err != nil {
+		return res, err
+	}
+	return s.sendEncoded(ctx, enc, tmo)
+}
+
+// SendEncoded is like SendContext for a packet encoded with EncodePacket, without
+// marshalling it again.
+func (s *Sender) SendEncoded(ctx context.Context, enc *EncodedPacket) (res Response, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("sending packet: %w", err)
+	}
+	if enc.packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", enc.packet.Request, ErrEmptyPacket)
+	}
+	return s.sendEncoded(ctx, enc, s.timeouts(Timeouts{}))
+}
+
+// sendEncoded sends enc, retrying failures RetryOnFailedInfo reports as transient.
+func (s *Sender) sendEncoded(ctx context.Context, enc *EncodedPacket, tmo Timeouts) (Response, error) {
+	res, _, err := s.sendEncodedHost(ctx, enc, tmo)
+	return res, err
+}
+
+// sendEncodedHost is sendEncoded, also returning the host that answered, see sendToHosts.
+func (s *Sender) sendEncodedHost(ctx context.Context, enc *EncodedPacket, tmo Timeouts) (res Response, host string, err error) {
+	ctx = withPacketCorrelationID(ctx, enc.packet)
+	defer func() { s.logOutcome(ctx, enc.packet, host, res, err) }()
+
+	res, host, err = s.retryFailedInfo(ctx, func() (Response, string, error) {
+		return s.sendWithRetryPolicy(ctx, enc, tmo)
+	})
+	if err == nil && !s.anyProcessedAccepted(res) {
+		if items := mismatchedItems(res); len(items) > 0 {
+			err = &ItemTypeMismatchError{Host: host, Response: res, Items: items}
+		}
+	}
+	return res, host, err
+}
+
+// retryFailedInfo calls send, and again while RetryOnFailedInfo reports its
+// failure as transient, up to FailedInfoRetries times.
+func (s *Sender) retryFailedInfo(ctx context.Context, send func() (Response, string, error)) (res Response, host string, err error) {
+	res, host, err = send()
+
+	retries, delay := s.FailedInfoRetries, s.FailedInfoRetryDelay
+	if retries <= 0 {
+		retries = defaultFailedInfoRetries
+	}
+	if delay <= 0 {
+		delay = defaultFailedInfoRetryDelay
+	}
+	for i := 0; i < retries && s.isTransientFailure(res, err); i++ {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return res, host, err
+		}
+		res, host, err = send()
+	}
+	return res, host, err
+}
+
+// isTransientFailure reports whether RetryOnFailedInfo accepts the info of a
+// rejected response, or of a success response with failed items, for a retry.
+func (s *Sender) isTransientFailure(res Response, err error) bool {
+	if s.RetryOnFailedInfo == nil {
+		return false
+	}
+
+	var rejected *ServerRejectedError
+	if errors.As(err, &rejected) {
+		return s.RetryOnFailedInfo(rejected.Response.Info)
+	}
+	if err != nil {
+		return false
+	}
+	if info, err := res.GetInfo(); err == nil && info.Failed > 0 && !s.anyProcessedAccepted(res) {
+		return s.RetryOnFailedInfo(res.Info)
+	}
+	return false
+}
+
+// anyProcessedAccepted reports whether SuccessIfAnyProcessed accepts res, with
+// failed items, as a success: its info reports processed > 0.
+func (s *Sender) anyProcessedAccepted(res Response) bool {
+	if !s.SuccessIfAnyProcessed {
+		return false
+	}
+	info, err := res.parseStats(InfoOptions{})
+	return err == nil && info.Processed > 0
+}
+
+// sendToHosts sends enc to the cached primary host or else the first working host.
+// host is the host that answered, the last one redirected to, when one did.
+func (s *Sender) sendToHosts(ctx context.Context, enc *EncodedPacket, tmo Timeouts) (res Response, host string, err error) {
+	var rejected *ServerRejectedError
+	var redirects []string
+	var attempts []HostAttempt
+
+	hosts, logical, resolveErr := s.resolveHosts()
+	if primary, redirected := s.cachedHost(); primary != "" && !redirected && !containsHost(hosts, primary) {
+		s.setPrimaryHost("") // hosts were reconfigured, the cached host is stale
+	} else if primary != "" {
+		hostCtx := withHost(ctx, logicalHost(hosts, logical, primary))
+		res, redirects, err = s.sendWithRedirects(hostCtx, enc, primary, tmo)
+		s.recordHost(ctx, primary, err)
+		if err == nil && s.UpdateHost && len(redirects) > 0 {
+			s.cacheHost(primary, redirects)
 		}
-		s.PrimaryHost = "" // clear cache
+		if err == nil || errors.As(err, &rejected) {
+			return res, finalHost(primary, redirects), err
+		}
+		attempts = append(attempts, HostAttempt{Host: primary, Redirects: redirects, Err: err})
+		s.sendLogger(ctx).Warnf("sending to primary host %s failed: %v", primary, err)
+		s.setPrimaryHost("") // clear cache
+	}
+
+	if len(hosts) == 0 {
+		if resolveErr != nil {
+			return res, "", fmt.Errorf("sending packet: %w", resolveErr)
+		}
+		return res, "", fmt.Errorf("sending packet: no hosts configured")
 	}
 
 	// Fallback: try each host in order
-	for _, host := range s.Hosts {
-		res, err = s.sendWithRedirects(packet, host)
+	available, availableLogical := s.availableHosts(hosts, logical)
+	for i, host := range available {
+		if ctx.Err() != nil {
+			break
+		}
+		res, redirects, err = s.sendWithRedirects(withHost(ctx, availableLogical[i]), enc, host, tmo)
+		s.recordHost(ctx, host, err)
 		if err == nil {
-			s.PrimaryHost = host // cache working host
-			return res, nil
+			s.cacheHost(host, redirects) // cache working host
+			return res, finalHost(host, redirects), nil
 		}
+		if errors.As(err, &rejected) {
+			return res, finalHost(host, redirects), err
+		}
+		attempts = append(attempts, HostAttempt{Host: host, Redirects: redirects, Err: err})
+		s.sendLogger(ctx).Warnf("sending to host %s failed: %v", host, err)
+	}
+	if ctxErr := contextErr(ctx); ctxErr != nil {
+		return res, "", fmt.Errorf("sending packet: %w", ctxErr)
+	}
+	return res, "", &SendError{Hosts: len(hosts), Attempts: attempts}
+}
+
+// finalHost returns the host that answered a send to host: the last of the
+// redirects followed, or host itself.
+func finalHost(host string, redirects []string) string {
+	if len(redirects) > 0 {
+		return redirects[len(redirects)-1]
+	}
+	return host
+}
+
+// contextErr returns ctx.Err(), or context.DeadlineExceeded once the ctx deadline
+// passed: a connection deadline capped by it can expire before ctx reports it.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// deadline returns the time timeout from now, capped by the ctx deadline.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
+
+// earliest returns the earlier of a and b.
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
 	}
-	return res, fmt.Errorf("all %d hosts failed", len(s.Hosts))
+	return a
 }
 
-func (s *Sender) sendWithRedirects(packet *Packet, startHost string) (res Response, err error) {
+// sendWithRedirects sends packet to startHost, following redirects. It returns
+// the hosts redirected to, in order.
+func (s *Sender) sendWithRedirects(ctx context.Context, enc *EncodedPacket, startHost string, tmo Timeouts) (res Response, redirects []string, err error) {
 
 	currentHost := startHost
 
 	for redirectCount := 0; redirectCount <= s.MaxRedirects; redirectCount++ {
-		res, err = s.sendOnce(packet, currentHost)
+		start := time.Now()
+		res, err = s.sendOnce(ctx, enc, currentHost, tmo)
+		s.onSend(ctx, enc.packet, currentHost, start, res, err)
 		if err != nil {
-			return res, fmt.Errorf("sendOnce to %s failed: %w", currentHost, err)
+			s.counters.packetsFailed.Add(1)
+			return res, redirects, fmt.Errorf("sendOnce to %s failed: %w", currentHost, err)
 		}
+		s.counters.packetsSent.Add(1)
+		s.latency.record(time.Since(start))
 
 		// success - done
 		if res.Response == "success" {
-			return res, nil
+			return res, redirects, nil
 		}
 
 		// check for redirect
 		if res.Redirect == nil || res.Redirect.Address == "" {
-			return res, fmt.Errorf("failed without redirect from %s: %s", currentHost, res.Response)
+			if s.anyProcessedAccepted(res) {
+				return res, redirects, nil
+			}
+			return res, redirects, rejectedError(res, currentHost)
 		}
 
 		// got redirect - update target and retry
 		newHost, err := parseHostPort(res.Redirect.Address)
 		if err != nil {
-			return res, err
+			return res, redirects, err
+		}
+		if s.RedirectsInGroupOnly && !s.redirectAllowed(newHost) {
+			return res, redirects, fmt.Errorf("redirect from %s to %s: %w", currentHost, newHost, ErrRedirectNotAllowed)
+		}
+		if cycle := redirectCycle(startHost, redirects, newHost); cycle != nil {
+			return res, redirects, fmt.Errorf("%w: %s", ErrRedirectLoop, strings.Join(cycle, " -> "))
+		}
+		s.sendLogger(ctx).Debugf("redirect from %s to %s", currentHost, newHost)
+		if s.OnRedirect != nil {
+			s.OnRedirect(currentHost, newHost, res.Redirect.Revision)
 		}
 		currentHost = newHost
+		redirects = append(redirects, newHost)
+		s.counters.redirects.Add(1)
+	}
+
+	return res, redirects, fmt.Errorf("max redirects exceeded from %s", startHost)
+}
+
+// redirectCycle returns the hosts from the visit of next through next again when
+// next was already visited, startHost then redirects, or nil otherwise.
+func redirectCycle(startHost string, redirects []string, next string) []string {
+	visited := append([]string{startHost}, redirects...)
+	for i, host := range visited {
+		if normalizeHost(host) == normalizeHost(next) {
+			return append(visited[i:], next)
+		}
+	}
+	return nil
+}
+
+// redirectAllowed reports whether host is in the group of Hosts or RedirectAllowlist.
+func (s *Sender) redirectAllowed(host string) bool {
+	if containsHost(s.Hosts, host) {
+		return true
+	}
+	if s.Resolver != nil {
+		if hosts, _, _ := s.resolveHosts(); containsHost(hosts, host) {
+			return true
+		}
 	}
 
-	return res, fmt.Errorf("max redirects exceeded from %s", startHost)
+	var ip net.IP
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		ip = net.ParseIP(name)
+	}
+	for _, allowed := range s.RedirectAllowlist {
+		if _, cidr, err := net.ParseCIDR(allowed); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if normalizeHost(allowed) == normalizeHost(host) {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Sender) sendOnce(packet *Packet, host string) (res Response, err error) {
+func (s *Sender) sendOnce(ctx context.Context, enc *EncodedPacket, host string, tmo Timeouts) (res Response, err error) {
+	compress, probe := s.compressFor(host)
+
+	res, compressed, err := s.exchange(ctx, enc, host, tmo, compress)
+	if !probe || !compressed {
+		return res, err
+	}
+	if err == nil {
+		s.setCompressSupport(host, true) // the server decoded the compressed frame
+		return res, nil
+	}
+	if ctx.Err() != nil || !compressionRejected(err) {
+		// says nothing about compression support, probe again next time; after a
+		// timeout the packet may have been stored, it is not sent again
+		return res, err
+	}
+
+	// The server does not understand compressed frames, fall back to plain ones
+	s.setCompressSupport(host, false)
+	s.sendLogger(ctx).Warnf("compressed send to %s failed, retrying uncompressed: %v", host, err)
+	res, _, err = s.exchange(ctx, enc, host, tmo, false)
+	return res, err
+}
+
+// dialError is a failure to connect to a host, including the TLS handshake.
+type dialError struct {
+	host    string
+	timeout time.Duration
+	err     error
+}
+
+func (e *dialError) Error() string {
+	return fmt.Sprintf("connecting to %s (timeout=%v): %v", e.host, e.timeout, e.err)
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+// compressionRejected reports whether err shows a server rejecting a compressed
+// frame: it closed the connection without answering, or answered with an
+// invalid header. Timeouts are not rejections.
+func compressionRejected(err error) bool {
+	var closed *connClosedError
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return errors.As(err, &closed) || errors.Is(err, ErrInvalidHeader)
+}
+
+// connClosedError marks a round trip failure showing the receiver did not process
+// the packet: the write failed, or the connection ended (EOF, reset) before any
+// byte of the response. A complete write followed by a read timeout is not one.
+type connClosedError struct {
+	err error
+}
+
+func (e *connClosedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connClosedError) Unwrap() error {
+	return e.err
+}
+
+// dial connects to host, with TLS PSK when TLSPSKIdentity is set, or with TLS
+// when tlsConfig returns a config for it.
+func (s *Sender) dial(ctx context.Context, host string, timeout time.Duration) (net.Conn, error) {
+	usePSK := s.TLSPSKIdentity != "" || len(s.TLSPSKKey) > 0
+	if usePSK && s.PSKHandshake == nil {
+		return nil, &dialError{host, timeout, ErrPSKUnsupported}
+	}
+	if _, ok := HostFromContext(ctx); !ok {
+		ctx = withHost(ctx, host)
+	}
+	s.sendLogger(ctx).Debugf("dialing %s (timeout=%v)", host, timeout)
+
 	// Timeout to resolve and connect to the server
-	conn, err := net.DialTimeout("tcp", host, s.ConnectTimeout)
+	network, address := hostNetwork(host)
+	var conn net.Conn
+	var err error
+	if s.DialContext != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err = s.DialContext(dialCtx, network, address)
+		cancel()
+	} else {
+		dialer := net.Dialer{Timeout: timeout}
+		if s.Dialer != nil {
+			dialer = *s.Dialer
+			if dialer.Timeout == 0 {
+				dialer.Timeout = timeout
+			}
+		}
+		conn, err = dialer.DialContext(ctx, network, address)
+	}
 	if err != nil {
-		return res, fmt.Errorf("connecting to %s (timeout=%v): %v", host, s.ConnectTimeout, err)
+		return nil, &dialError{host, timeout, err}
 	}
-	defer conn.Close()
 
-	dataPacket, _ := json.Marshal(packet)
+	var handshake func() (net.Conn, error)
+	if usePSK {
+		handshake = func() (net.Conn, error) {
+			return s.PSKHandshake(ctx, conn, s.TLSPSKIdentity, s.TLSPSKKey)
+		}
+	} else if cfg := s.tlsConfig(host); cfg != nil {
+		handshake = func() (net.Conn, error) {
+			tlsConn := tls.Client(conn, cfg)
+			return tlsConn, tlsConn.HandshakeContext(ctx)
+		}
+	} else {
+		return conn, nil
+	}
 
-	// Fill buffer
-	buffer := append(s.getHeader(), packet.DataLen()...)
-	buffer = append(buffer, dataPacket...)
+	conn.SetDeadline(deadline(ctx, timeout))
+	tlsConn, err := handshake()
+	if err != nil {
+		conn.Close()
+		return nil, &dialError{host, timeout, fmt.Errorf("TLS handshake: %w", err)}
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
 
-	// Write timeout
-	conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+// tlsConfig returns the TLS config for host, nil for plaintext. The ServerName
+// defaults to the host name of the address.
+func (s *Sender) tlsConfig(host string) *tls.Config {
+	cfg := s.TLSConfig
+	if s.TLSConfigForHost != nil {
+		cfg = s.TLSConfigForHost(host)
+	}
+	if cfg == nil || cfg.ServerName != "" {
+		return cfg
+	}
 
-	// Send packet to zabbix
-	if _, err = conn.Write(buffer); err != nil {
-		return res, fmt.Errorf("sending the data to %s (timeout=%v): %s", host, s.WriteTimeout, err.Error())
+	cfg = cfg.Clone()
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		cfg.ServerName = name
 	}
+	return cfg
+}
 
-	// Read timeout
-	conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+// exchange sends enc to host over a new connection, or an idle one with
+// KeepAlive, and reads the response.
+func (s *Sender) exchange(ctx context.Context, enc *EncodedPacket, host string, tmo Timeouts, compress bool) (res Response, compressed bool, err error) {
+	conn, reused, err := s.getConn(ctx, host, tmo.Connect)
+	if err != nil {
+		s.counters.connectErrors.Add(1)
+		return res, false, err
+	}
 
-	// Read response from server
-	response, err := s.read(conn)
+	response, compressed, extra, err := s.roundTrip(ctx, conn, enc, host, tmo, compress)
+	var closed *connClosedError
+	if err != nil && reused && ctx.Err() == nil && errors.As(err, &closed) {
+		// The receiver closed the idle connection without processing the packet,
+		// retry on a new one. After a timeout the packet may have been stored.
+		conn.Close()
+		if conn, err = s.dial(ctx, host, tmo.Connect); err != nil {
+			s.counters.connectErrors.Add(1)
+			return res, false, err
+		}
+		response, compressed, extra, err = s.roundTrip(ctx, conn, enc, host, tmo, compress)
+	}
+	if err != nil || extra > 0 || !s.KeepAlive {
+		conn.Close()
+	} else {
+		s.putConn(host, conn)
+	}
 	if err != nil {
-		return res, fmt.Errorf("reading the response from %s (timeout=%v): %s", host, s.ReadTimeout, err)
+		return res, compressed, err
 	}
 
-	if len(response) < 13 {
-		return res, fmt.Errorf("response too short from %s: %d bytes", host, len(response))
+	if extra > 0 {
+		s.sendLogger(ctx).Warnf("response from %s has %d bytes beyond its declared length, ignored", host, extra)
 	}
+	s.recordOverflow(extra)
 
-	header := response[:5]
-	data := response[13:]
+	res, err = s.decode(response, host)
+	return res, compressed, err
+}
 
-	if !bytes.Equal(header, s.getHeader()) {
-		return res, fmt.Errorf("got no valid header [%+v] , expected [%+v]", header, s.getHeader())
+// roundTrip writes the frame of enc to conn and reads the response frame.
+func (s *Sender) roundTrip(ctx context.Context, conn net.Conn, enc *EncodedPacket, host string, tmo Timeouts, compress bool) (response []byte, compressed bool, extra int, err error) {
+	// Abort a blocked write or read when ctx is canceled, reporting ctx.Err()
+	defer func() {
+		if ctxErr := contextErr(ctx); err != nil && ctxErr != nil && !errors.Is(err, ctxErr) {
+			err = fmt.Errorf("%w (%v)", ctxErr, err)
+		}
+	}()
+	if ctx.Done() != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-finished:
+			}
+		}()
 	}
 
-	if err := json.Unmarshal(data, &res); err != nil {
-		return res, fmt.Errorf("zabbix response from %s is not valid: %v", host, err)
+	// Fill buffer
+	buffer, compressed := enc.frame(compress)
+
+	// Overall deadline for the whole interaction, the phase deadlines never extend it
+	overall := deadline(ctx, tmo.Write+tmo.Read)
+	conn.SetDeadline(overall)
+
+	// Write timeout
+	conn.SetWriteDeadline(earliest(deadline(ctx, tmo.Write), overall))
+
+	// Send packet to zabbix
+	if err = writeFrame(conn, buffer); err != nil {
+		return nil, compressed, 0, fmt.Errorf("sending the data to %s (timeout=%v): %w", host, tmo.Write, &connClosedError{err})
 	}
+	s.counters.bytesWritten.Add(int64(len(buffer)))
+	s.onWire(WireWrite, buffer)
 
-	return res, nil
+	// Read timeout
+	conn.SetReadDeadline(earliest(deadline(ctx, tmo.Read), overall))
+
+	// Read response from server
+	response, extra, err = s.read(conn)
+	if err != nil {
+		return nil, compressed, 0, fmt.Errorf("reading the response from %s (timeout=%v): %w", host, tmo.Read, err)
+	}
+	s.counters.bytesRead.Add(int64(len(response) + extra))
+	s.onWire(WireRead, response)
+	return response, compressed, extra, nil
 }
 
+// RegisterHostOptions configures RegisterHostWithOptions.
+type RegisterHostOptions struct {
+	// Attempts is the number of "active checks" requests sent until the server
+	// reports success, default 2 as Zabbix requires 2 calls for confirmation.
+	Attempts int
+
+	// Delay is the wait between attempts, default none.
+	Delay time.Duration
+}
+
+const defaultRegisterHostAttempts = 2
+
 // RegisterHost sends host autoregistration request ("active checks").
 // Retries once as Zabbix requires 2 calls for confirmation.
+// A host the server rejects is reported as ErrRegistrationFailed.
 func (s *Sender) RegisterHost(host, hostmetadata string) error {
+	_, err := s.RegisterHostWithOptions(host, hostmetadata, RegisterHostOptions{})
+	return err
+}
 
-	p := &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
+// RegisterHostWithOptions is like RegisterHost, polling the server up to
+// opts.Attempts times while it rejects the host, e.g. while the registration is
+// still pending on a busy server. It returns the last response of the server,
+// also on error, for its message. Connection errors are not retried.
+func (s *Sender) RegisterHostWithOptions(host, hostmetadata string, opts RegisterHostOptions) (Response, error) {
+	return s.RegisterHostContext(context.Background(), host, hostmetadata, opts)
+}
 
-	res, err := s.Send(p)
-	if err != nil {
-		return fmt.Errorf("sending packet: %v", err)
+// RegisterHostContext is like RegisterHostWithOptions but bounds the requests
+// and the delays between them by ctx.
+func (s *Sender) RegisterHostContext(ctx context.Context, host, hostmetadata string, opts RegisterHostOptions) (res Response, err error) {
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = defaultRegisterHostAttempts
 	}
 
-	if res.Response == "success" {
-		return nil
-	}
+	for attempt := 1; ; attempt++ {
+		p := &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
 
-	// The autoregister process always return fail the first time
-	// We retry the process to get success response to verify the host registration properly
-	p = &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
+		res, err = s.SendContext(ctx, p)
+		if err == nil && res.Response == "success" {
+			return res, nil
+		}
+		if err != nil && !errors.Is(err, ErrServerReported) {
+			return res, registrationError(err)
+		}
 
-	res, err = s.Send(p)
-	if err != nil {
-		return fmt.Errorf("sending packet: %v", err)
+		// The autoregister process may return fail until the host is registered
+		if attempt >= attempts {
+			break
+		}
+		timer := time.NewTimer(opts.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return res, fmt.Errorf("sending packet: %w", ctx.Err())
+		}
 	}
 
-	if res.Response == "failed" {
-		return fmt.Errorf("autoregistration failed, verify hostmetadata")
+	if err != nil {
+		return res, registrationError(err)
 	}
+	return res, fmt.Errorf("%w, verify hostmetadata", ErrRegistrationFailed)
+}
 
-	return nil
+// registrationError wraps a RegisterHost send error, adding ErrRegistrationFailed
+// when the server rejected the host.
+func registrationError(err error) error {
+	if errors.Is(err, ErrServerReported) {
+		return fmt.Errorf("%w: %w", ErrRegistrationFailed, err)
+	}
+	return fmt.Errorf("sending packet: %w", err)
 }
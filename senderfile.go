@@ -0,0 +1,91 @@
+package zabbix_sender
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteSenderFile writes metrics in the input file format of zabbix_sender -i,
+// one "host key value" line per metric. When the metrics carry timestamps the
+// lines are "host key timestamp value", the format of zabbix_sender -T -i; all
+// metrics must then have one. Fields with whitespace, quotes or backslashes are
+// double quoted with \" and \\ escapes. The Active flag is not part of the format.
+func WriteSenderFile(w io.Writer, metrics []*Metric) error {
+	withClock := len(metrics) > 0 && metrics[0].Clock != 0
+
+	bw := bufio.NewWriter(w)
+	for i, m := range metrics {
+		if (m.Clock != 0) != withClock {
+			return fmt.Errorf("metric %d: can not mix metrics with and without timestamp", i)
+		}
+		if m.streamed != nil {
+			return fmt.Errorf("metric %d: streamed values are not supported", i)
+		}
+
+		fields := []string{m.Host, m.Key, m.Value}
+		if withClock {
+			fields = []string{m.Host, m.Key, strconv.FormatInt(m.Clock, 10), m.Value}
+		}
+		for j, f := range fields {
+			if strings.ContainsAny(f, "\r\n") {
+				return fmt.Errorf("metric %d: line breaks can not be represented", i)
+			}
+			if j > 0 {
+				bw.WriteByte(' ')
+			}
+			bw.WriteString(quoteField(f))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// quoteField quotes f for a sender file when needed.
+func quoteField(f string) string {
+	if f != "" && !strings.ContainsAny(f, " \t\"\\") {
+		return f
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(f) + `"`
+}
+
+// ReadSenderFile reads trapper metrics from the input file format of zabbix_sender -i
+// (see WriteSenderFile): "host key value" or "host key timestamp value" lines.
+// Blank lines are skipped.
+func ReadSenderFile(r io.Reader) ([]*Metric, error) {
+	var metrics []*Metric
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return metrics, fmt.Errorf("line %d: %w", n, err)
+		}
+		switch len(fields) {
+		case 3:
+			metrics = append(metrics, NewMetric(fields[0], fields[1], fields[2], false))
+		case 4:
+			clock, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return metrics, fmt.Errorf("line %d: invalid timestamp %q", n, fields[2])
+			}
+			metrics = append(metrics, NewMetric(fields[0], fields[1], fields[3], false, time.Unix(clock, 0)))
+		default:
+			return metrics, fmt.Errorf("line %d: expected 3 or 4 fields, got %d", n, len(fields))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return metrics, fmt.Errorf("reading sender file: %w", err)
+	}
+	return metrics, nil
+}
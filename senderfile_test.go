@@ -0,0 +1,89 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSenderFileRoundTrip(t *testing.T) {
+	metrics := []*Metric{
+		NewMetric("web01", "nginx.requests", "1520", false),
+		NewMetric("web01", "nginx.status", "up and running", false),
+		NewMetric("my host", "app.msg[a b]", `say "hi" \ bye`, false),
+		NewMetric("web02", "empty", "", false),
+		NewMetric("web02", "path", `C:\temp`, false),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSenderFile(&buf, metrics); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+
+	expected := "web01 nginx.requests 1520\n" +
+		"web01 nginx.status \"up and running\"\n" +
+		"\"my host\" \"app.msg[a b]\" \"say \\\"hi\\\" \\\\ bye\"\n" +
+		"web02 empty \"\"\n" +
+		"web02 path \"C:\\\\temp\"\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected file:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	read, err := ReadSenderFile(&buf)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if len(read) != len(metrics) {
+		t.Fatalf("expected %d metrics, got %d", len(metrics), len(read))
+	}
+	for i := range metrics {
+		if read[i].Host != metrics[i].Host || read[i].Key != metrics[i].Key || read[i].Value != metrics[i].Value {
+			t.Errorf("metric %d: expected %+v, got %+v", i, metrics[i], read[i])
+		}
+	}
+}
+
+func TestSenderFileTimestamps(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	metrics := []*Metric{
+		NewMetric("web01", "cpu", "0.5", false, clock),
+		NewMetric("web01", "msg", "two words", false, clock.Add(time.Second)),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSenderFile(&buf, metrics); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if buf.String() != "web01 cpu 1700000000 0.5\nweb01 msg 1700000001 \"two words\"\n" {
+		t.Errorf("unexpected file:\n%s", buf.String())
+	}
+
+	read, err := ReadSenderFile(&buf)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if len(read) != 2 || read[1].Clock != 1700000001 || read[1].Value != "two words" {
+		t.Errorf("unexpected metrics %+v", read)
+	}
+
+	mixed := []*Metric{metrics[0], NewMetric("web01", "now", "1", false)}
+	if err := WriteSenderFile(&buf, mixed); err == nil {
+		t.Error("expected error mixing metrics with and without timestamp")
+	}
+	if err := WriteSenderFile(&buf, []*Metric{NewMetric("web01", "log", "line1\nline2", false)}); err == nil {
+		t.Error("expected error for a value with a line break")
+	}
+}
+
+func TestReadSenderFileInvalid(t *testing.T) {
+	for _, input := range []string{
+		"web01 key\n",
+		"web01 key soon value\n",
+		"web01 key \"unterminated\n",
+	} {
+		if _, err := ReadSenderFile(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("%q: expected line 1 error, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package zabbix_sender
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Session holds a single connection to one host and reuses it across sends.
+// Redirects are not followed: a session is bound to the host it was opened on.
+// On a connection error the caller is expected to Close and open a new session,
+// unless MaxReconnects lets the session reconnect by itself.
+type Session struct {
+	// MaxReconnects is the number of times a send reconnects to the session host
+	// and resends the packet after a network error, 0 disables reconnecting.
+	// A packet the server received before the connection broke may be stored twice.
+	MaxReconnects int
+
+	sender *Sender
+	host   string
+	conn   net.Conn
+}
+
+// OpenSession connects to the first reachable host, trying the cached PrimaryHost first.
+func (s *Sender) OpenSession() (*Session, error) {
+	hosts, logical, resolveErr := s.resolveHosts()
+	if len(hosts) == 0 && resolveErr != nil {
+		return nil, fmt.Errorf("opening session: %w", resolveErr)
+	}
+	hosts, logical = s.primaryFirst(hosts, logical)
+
+	var err error
+	for i, host := range hosts {
+		var conn net.Conn
+		conn, err = s.dial(withHost(context.Background(), logical[i]), host, s.ConnectTimeout)
+		if err == nil {
+			return &Session{sender: s, host: host, conn: conn}, nil
+		}
+	}
+	if err == nil {
+		return nil, fmt.Errorf("opening session: no hosts configured")
+	}
+	return nil, fmt.Errorf("opening session: %w (%d hosts): %w", ErrAllHostsFailed, len(hosts), err)
+}
+
+// Host returns the address the session is connected to.
+func (ss *Session) Host() string {
+	return ss.host
+}
+
+// SendMetrics sends mixed active+trapper metrics over the session connection.
+// Returns 4 values: (activeRes, activeErr, trapperRes, trapperErr)
+// Batches are checked (StrictValidation, MaxUniqueKeys) and split (MaxPacketBytes)
+// as by Sender.SendMetrics.
+func (ss *Session) SendMetrics(metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	s := ss.sender
+	metrics, err := s.prepareBatch(metrics)
+	if err != nil {
+		errActive, errTrapper = batchErrors(metrics, err)
+		return resActive, errActive, resTrapper, errTrapper
+	}
+	activeMetrics, trapperMetrics := splitMetrics(metrics)
+
+	if len(trapperMetrics) > 0 {
+		resTrapper, errTrapper = s.sendChunked(context.Background(), trapperMetrics, false, ss.Send)
+	}
+
+	if len(activeMetrics) > 0 {
+		resActive, errActive = s.sendChunked(context.Background(), activeMetrics, true, ss.Send)
+	}
+
+	return resActive, errActive, resTrapper, errTrapper
+}
+
+// Send sends a single packet over the session connection and waits for the response.
+func (ss *Session) Send(packet *Packet) (res Response, err error) {
+	s := ss.sender
+	if packet.isEmptyData() {
+		return res, fmt.Errorf("sending %q packet: %w", packet.Request, ErrEmptyPacket)
+	}
+
+	buffer, _, err := s.frame(packet, s.Compression)
+	if err != nil {
+		return res, err
+	}
+
+	response, err := ss.exchange(buffer)
+	for i := 0; err != nil && i < ss.MaxReconnects; i++ {
+		if err = ss.reconnect(); err == nil {
+			response, err = ss.exchange(buffer)
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	res, err = s.decode(response, ss.host)
+	if err != nil {
+		return res, err
+	}
+
+	if res.Response != "success" {
+		if res.Redirect != nil && res.Redirect.Address != "" {
+			return res, fmt.Errorf("session host %s redirected to %s, reopen the session", ss.host, res.Redirect.Address)
+		}
+		return res, rejectedError(res, ss.host)
+	}
+
+	return res, nil
+}
+
+// exchange writes one frame over the session connection and reads the response frame.
+func (ss *Session) exchange(buffer []byte) ([]byte, error) {
+	s := ss.sender
+
+	// Write timeout
+	ss.conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+
+	if err := writeFrame(ss.conn, buffer); err != nil {
+		return nil, fmt.Errorf("sending the data to %s (timeout=%v): %w", ss.host, s.WriteTimeout, err)
+	}
+	s.onWire(WireWrite, buffer)
+
+	// Read timeout
+	ss.conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+
+	response, err := readFrame(ss.conn)
+	if err != nil {
+		return nil, fmt.Errorf("reading the response from %s (timeout=%v): %w", ss.host, s.ReadTimeout, err)
+	}
+	s.onWire(WireRead, response)
+	return response, nil
+}
+
+// reconnect replaces the session connection by a new one to the same host.
+func (ss *Session) reconnect() error {
+	ss.conn.Close()
+	conn, err := ss.sender.dial(context.Background(), ss.host, ss.sender.ConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("reconnecting session: %w", err)
+	}
+	ss.conn = conn
+	return nil
+}
+
+// Close closes the session connection.
+func (ss *Session) Close() error {
+	return ss.conn.Close()
+}
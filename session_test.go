@@ -0,0 +1,239 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionReusesConnection(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var accepted int32
+	done := make(chan error, 1)
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+
+			go func() {
+				defer conn.Close()
+				for {
+					if _, err := mock.readZabbixRequest(conn); err != nil {
+						return
+					}
+					jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+					if err := mock.writeZabbixResponse(conn, jsonResp); err != nil {
+						done <- err
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	defer session.Close()
+
+	if session.Host() != mock.address {
+		t.Errorf("session host: expected %s, got %s", mock.address, session.Host())
+	}
+
+	for i := 0; i < 5; i++ {
+		_, _, res, err := session.SendMetrics([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)})
+		if err != nil {
+			t.Fatalf("send %d over session failed: %v", i, err)
+		}
+		if res.Response != "success" {
+			t.Fatalf("send %d: expected success, got %s", i, res.Response)
+		}
+	}
+
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("expected 1 accepted connection, got %d", n)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("Mock server error: %v", err)
+	default:
+	}
+}
+
+func TestSessionConnectionError(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		// Drop the connection without answering
+		conn.Close()
+	}()
+
+	s := NewSender(mock.address)
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	defer session.Close()
+
+	if _, err := session.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil {
+		t.Fatal("expected error after the server dropped the session connection")
+	}
+}
+
+func TestSessionReconnects(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			n := atomic.AddInt32(&accepted, 1)
+
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			if n == 1 {
+				// Drop the first connection after one send, like a restarting proxy
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					if _, err := mock.readZabbixRequest(conn); err != nil {
+						return
+					}
+					mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+				}
+			}()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	defer session.Close()
+	session.MaxReconnects = 1
+
+	for i := 0; i < 3; i++ {
+		res, err := session.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+		if err != nil {
+			t.Fatalf("send %d over session failed: %v", i, err)
+		}
+		if res.Response != "success" {
+			t.Fatalf("send %d: expected success, got %s", i, res.Response)
+		}
+	}
+
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected 2 accepted connections, got %d", n)
+	}
+}
+
+func TestSessionReconnectsBounded(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	defer session.Close()
+	session.MaxReconnects = 2
+
+	if _, err := session.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil {
+		t.Fatal("expected error when every connection is dropped")
+	}
+	if n := atomic.LoadInt32(&accepted); n != 3 {
+		t.Errorf("expected 1 connection and 2 reconnects, got %d", n)
+	}
+}
+
+func TestSessionSendMetricsChecksAndSplits(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&requests, 1)
+			n := len(request.Data)
+			mock.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, n, n))
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.MaxUniqueKeys = 2
+	session, err := s.OpenSession()
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	defer session.Close()
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ping", "1", false),
+		NewMetric("zabbixTrapper1", "pong", "2", false),
+		NewMetric("zabbixTrapper1", "pang", "3", false),
+	}
+	if _, _, _, err := session.SendMetrics(metrics); !errors.Is(err, ErrTooManyKeys) {
+		t.Fatalf("expected ErrTooManyKeys, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no request for a rejected batch, got %d", n)
+	}
+
+	// Each metric in its own packet
+	s.MaxUniqueKeys = 0
+	s.MaxPacketBytes = 1
+	_, _, res, err := session.SendMetrics(metrics)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 || len(res.Parts) != 3 {
+		t.Errorf("expected 3 packets, got %d requests and %d parts", n, len(res.Parts))
+	}
+}
@@ -0,0 +1,273 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpoolEnvelope is a failed packet persisted by a Spool, with the metadata of the failure.
+type SpoolEnvelope struct {
+	Time  time.Time `json:"time"`  // when the send failed
+	Hosts []string  `json:"hosts"` // hosts attempted, redirects included
+	Error string    `json:"error"`
+	Frame []byte    `json:"frame"` // uncompressed wire bytes of the packet
+
+	path string
+}
+
+// rejectedDir is the subdirectory of Spool.Dir holding the envelopes of packets
+// rejected permanently, kept for analysis and never replayed.
+const rejectedDir = "rejected"
+
+// Spool persists packets that could not be sent as gzipped JSON envelopes in Dir,
+// one file each, and replays them later. It also stores the metrics of a Queue,
+// see Persist and DeadLetter.
+type Spool struct {
+	Dir string
+
+	// MaxAge skips and removes envelopes older than it on Replay, so stale metrics
+	// are not stored after a long outage. 0 replays every envelope.
+	MaxAge time.Duration
+}
+
+// SendOrSpool sends packet with s and spools it when the send fails transiently,
+// e.g. no host is reachable. A packet failing permanently, e.g. rejected by the
+// server, is not spooled: replaying it would fail again. It returns the send
+// error, joined with the spool error if the packet could not be persisted.
+func (sp *Spool) SendOrSpool(ctx context.Context, s *Sender, packet *Packet) (Response, error) {
+	enc, err := s.EncodePacket(packet)
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := s.SendEncoded(ctx, enc)
+	if err == nil || permanentError(err) {
+		return res, err
+	}
+	if spoolErr := sp.Add(enc, err); spoolErr != nil {
+		return res, errors.Join(err, spoolErr)
+	}
+	return res, err
+}
+
+// Add persists enc with the metadata of sendErr.
+func (sp *Spool) Add(enc *EncodedPacket, sendErr error) error {
+	return sp.write(newEnvelope(enc, sendErr))
+}
+
+// Persist returns a QueueOptions.Persist function spooling the undelivered
+// metrics of a Queue as packets encoded by s, one per category, for Replay.
+func (sp *Spool) Persist(s *Sender) func([]*Metric) error {
+	return func(metrics []*Metric) error {
+		return sp.addMetrics(s, metrics, nil, sp.Dir)
+	}
+}
+
+// DeadLetter returns a QueueOptions.DeadLetter function storing the metrics a
+// Queue can not deliver with the reason, as packets encoded by s, aside like
+// the envelopes Replay finds rejected. See Rejected.
+func (sp *Spool) DeadLetter(s *Sender) func([]*Metric, error) {
+	return func(metrics []*Metric, reason error) {
+		if err := sp.addMetrics(s, metrics, reason, filepath.Join(sp.Dir, rejectedDir)); err != nil {
+			s.logger().Warnf("spooling %d undeliverable metrics: %v", len(metrics), err)
+		}
+	}
+}
+
+// addMetrics stores metrics in dir as one envelope per category with reason.
+func (sp *Spool) addMetrics(s *Sender, metrics []*Metric, reason error, dir string) error {
+	var errs []error
+	for _, active := range []bool{false, true} {
+		category := categoryMetrics(metrics, active)
+		if len(category) == 0 {
+			continue
+		}
+		enc, err := s.EncodePacket(NewPacket(category, active))
+		if err == nil {
+			err = sp.writeIn(dir, newEnvelope(enc, reason))
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// newEnvelope returns the envelope of enc with the metadata of sendErr.
+func newEnvelope(enc *EncodedPacket, sendErr error) SpoolEnvelope {
+	frame, _ := enc.frame(false)
+	env := SpoolEnvelope{Time: time.Now(), Frame: frame}
+	if sendErr != nil {
+		env.Error = sendErr.Error()
+	}
+
+	var sendError *SendError
+	if errors.As(sendErr, &sendError) {
+		for _, a := range sendError.Attempts {
+			env.Hosts = append(env.Hosts, a.Host)
+			env.Hosts = append(env.Hosts, a.Redirects...)
+		}
+	}
+	return env
+}
+
+// write stores env in a new file of Dir.
+func (sp *Spool) write(env SpoolEnvelope) error {
+	return sp.writeIn(sp.Dir, env)
+}
+
+// writeIn stores env in a new file of dir, created when missing. It is written
+// to a temporary name first, so Replay never reads a partial envelope.
+func (sp *Spool) writeIn(dir string, env SpoolEnvelope) error {
+	if dir != sp.Dir {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("spooling packet: %w", err)
+		}
+	}
+	f, err := os.CreateTemp(dir, "packet-*.json.gz.tmp")
+	if err != nil {
+		return fmt.Errorf("spooling packet: %w", err)
+	}
+
+	zw := gzip.NewWriter(f)
+	err = json.NewEncoder(zw).Encode(env)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), strings.TrimSuffix(f.Name(), ".tmp"))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("spooling packet: %w", err)
+	}
+	return nil
+}
+
+// Envelopes returns the spooled envelopes, oldest first.
+func (sp *Spool) Envelopes() ([]SpoolEnvelope, error) {
+	return readEnvelopes(sp.Dir)
+}
+
+// Rejected returns the envelopes of the packets rejected permanently, oldest
+// first: moved aside by Replay, or stored by DeadLetter.
+func (sp *Spool) Rejected() ([]SpoolEnvelope, error) {
+	return readEnvelopes(filepath.Join(sp.Dir, rejectedDir))
+}
+
+// readEnvelopes returns the envelopes of dir, oldest first.
+func readEnvelopes(dir string) ([]SpoolEnvelope, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "packet-*.json.gz"))
+	if err != nil {
+		return nil, fmt.Errorf("reading spool: %w", err)
+	}
+
+	envs := make([]SpoolEnvelope, 0, len(paths))
+	for _, path := range paths {
+		env, err := readEnvelope(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading spool: %w", err)
+		}
+		envs = append(envs, env)
+	}
+	sort.SliceStable(envs, func(i, j int) bool { return envs[i].Time.Before(envs[j].Time) })
+	return envs, nil
+}
+
+// readEnvelope decodes the envelope file at path.
+func readEnvelope(path string) (env SpoolEnvelope, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return env, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return env, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := json.NewDecoder(zr).Decode(&env); err != nil {
+		return env, fmt.Errorf("%s: %w", path, err)
+	}
+	env.path = path
+	return env, nil
+}
+
+// Replay sends the spooled packets with s, oldest first, and removes each one
+// once sent. Envelopes older than MaxAge are removed without sending. A packet
+// failing permanently, e.g. rejected by the server, is moved aside with its new
+// error (see Rejected); both count as skipped. It stops at the first transient
+// failure, keeping that envelope and the newer ones for the next Replay.
+func (sp *Spool) Replay(ctx context.Context, s *Sender) (replayed, skipped int, err error) {
+	envs, err := sp.Envelopes()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, env := range envs {
+		if sp.MaxAge > 0 && time.Since(env.Time) > sp.MaxAge {
+			if err := os.Remove(env.path); err != nil {
+				return replayed, skipped, fmt.Errorf("removing stale spooled packet: %w", err)
+			}
+			skipped++
+			continue
+		}
+
+		enc, err := env.packet(s)
+		if err != nil {
+			return replayed, skipped, fmt.Errorf("replaying %s: %w", env.path, err)
+		}
+		if _, err := s.SendEncoded(ctx, enc); permanentError(err) {
+			if err := sp.reject(env, enc, err); err != nil {
+				return replayed, skipped, err
+			}
+			skipped++
+			continue
+		} else if err != nil {
+			return replayed, skipped, fmt.Errorf("replaying packet spooled at %v: %w", env.Time, err)
+		}
+		if err := os.Remove(env.path); err != nil {
+			return replayed, skipped, fmt.Errorf("removing replayed packet: %w", err)
+		}
+		replayed++
+	}
+	return replayed, skipped, nil
+}
+
+// reject moves env, whose packet enc failed with sendErr, to the rejected envelopes.
+func (sp *Spool) reject(env SpoolEnvelope, enc *EncodedPacket, sendErr error) error {
+	if err := sp.writeIn(filepath.Join(sp.Dir, rejectedDir), newEnvelope(enc, sendErr)); err != nil {
+		return fmt.Errorf("moving rejected packet aside: %w", err)
+	}
+	if err := os.Remove(env.path); err != nil {
+		return fmt.Errorf("removing rejected packet: %w", err)
+	}
+	return nil
+}
+
+// packet decodes the frame of env, keeping its JSON data as is.
+func (env SpoolEnvelope) packet(s *Sender) (*EncodedPacket, error) {
+	frame := env.Frame
+	if len(frame) < 13 || string(frame[:5]) != zabbixHeader {
+		return nil, fmt.Errorf("spooled frame: %w", ErrInvalidHeader)
+	}
+	data := frame[13:]
+	if binary.LittleEndian.Uint64(frame[5:13]) != uint64(len(data)) {
+		return nil, fmt.Errorf("invalid frame length")
+	}
+
+	var packet Packet
+	if err := json.Unmarshal(data, &packet); err != nil {
+		return nil, fmt.Errorf("decoding packet: %w", err)
+	}
+	return &EncodedPacket{packet: &packet, data: data, compressMinBytes: s.CompressMinBytes}, nil
+}
@@ -0,0 +1,231 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolEnvelopeRoundTrip(t *testing.T) {
+	// Nothing listens on the address: the send fails and is spooled
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddress := l.Addr().String()
+	l.Close()
+
+	sp := &Spool{Dir: t.TempDir()}
+	s := NewSender(deadAddress)
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	_, sendErr := sp.SendOrSpool(context.Background(), s, packet)
+	var sendError *SendError
+	if !errors.As(sendErr, &sendError) {
+		t.Fatalf("expected the send error, got %v", sendErr)
+	}
+
+	envs, err := sp.Envelopes()
+	if err != nil {
+		t.Fatalf("error reading spool: %v", err)
+	}
+	if len(envs) != 1 {
+		t.Fatalf("expected 1 envelope, got %d", len(envs))
+	}
+	env := envs[0]
+
+	if len(env.Hosts) != 1 || env.Hosts[0] != deadAddress {
+		t.Errorf("expected hosts [%s], got %v", deadAddress, env.Hosts)
+	}
+	if env.Error != sendErr.Error() {
+		t.Errorf("expected error %q, got %q", sendErr, env.Error)
+	}
+	if time.Since(env.Time) > time.Minute {
+		t.Errorf("unexpected envelope time %v", env.Time)
+	}
+	enc, _ := s.EncodePacket(packet)
+	if frame, _ := enc.frame(false); !bytes.Equal(env.Frame, frame) {
+		t.Errorf("frame not preserved:\n%q\n%q", env.Frame, frame)
+	}
+
+	// No temporary files left behind
+	if tmp, _ := filepath.Glob(filepath.Join(sp.Dir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("unexpected temporary files %v", tmp)
+	}
+}
+
+func TestSpoolReplayMaxAge(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan string, 3)
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if request, err := mock.readZabbixRequest(conn); err == nil {
+				received <- request.Data[0].Key
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	sp := &Spool{Dir: t.TempDir(), MaxAge: time.Hour}
+
+	spool := func(key string, age time.Duration) {
+		enc, err := s.EncodePacket(NewPacket([]*Metric{NewMetric("zabbixTrapper1", key, "1", false)}, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, _ := enc.frame(false)
+		if err := sp.write(SpoolEnvelope{Time: time.Now().Add(-age), Frame: frame}); err != nil {
+			t.Fatalf("error spooling: %v", err)
+		}
+	}
+	spool("newer", time.Minute)
+	spool("stale", 2*time.Hour)
+	spool("older", 10*time.Minute)
+
+	replayed, skipped, err := sp.Replay(context.Background(), s)
+	if err != nil {
+		t.Fatalf("error replaying: %v", err)
+	}
+	if replayed != 2 || skipped != 1 {
+		t.Errorf("expected 2 replayed and 1 skipped, got %d and %d", replayed, skipped)
+	}
+
+	close(received)
+	var keys []string
+	for key := range received {
+		keys = append(keys, key)
+	}
+	if len(keys) != 2 || keys[0] != "older" || keys[1] != "newer" {
+		t.Errorf("expected [older newer] in order, got %v", keys)
+	}
+
+	if entries, _ := os.ReadDir(sp.Dir); len(entries) != 0 {
+		t.Errorf("expected an empty spool, got %d files", len(entries))
+	}
+}
+
+func TestSpoolRejectedPackets(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// "bad" packets are rejected, the others accepted
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if request, err := mock.readZabbixRequest(conn); err == nil {
+				if request.Data[0].Key == "bad" {
+					mock.writeZabbixResponse(conn, `{"response":"failed","info":"cannot parse request"}`)
+				} else {
+					mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	sp := &Spool{Dir: t.TempDir()}
+	packet := func(key string) *Packet {
+		return NewPacket([]*Metric{NewMetric("zabbixTrapper1", key, "1", false)}, false)
+	}
+
+	// A rejected packet would be rejected again, it is not spooled
+	var rejected *ServerRejectedError
+	if _, err := sp.SendOrSpool(context.Background(), s, packet("bad")); !errors.As(err, &rejected) {
+		t.Fatalf("expected ServerRejectedError, got %v", err)
+	}
+	if envs, _ := sp.Envelopes(); len(envs) != 0 {
+		t.Fatalf("expected nothing spooled, got %d envelopes", len(envs))
+	}
+
+	// Replay moves a rejected envelope aside and goes on
+	for _, key := range []string{"bad", "good"} {
+		enc, err := s.EncodePacket(packet(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sp.Add(enc, errors.New("connection refused")); err != nil {
+			t.Fatalf("error spooling: %v", err)
+		}
+	}
+	replayed, skipped, err := sp.Replay(context.Background(), s)
+	if err != nil {
+		t.Fatalf("error replaying: %v", err)
+	}
+	if replayed != 1 || skipped != 1 {
+		t.Errorf("expected 1 replayed and 1 skipped, got %d and %d", replayed, skipped)
+	}
+	if envs, _ := sp.Envelopes(); len(envs) != 0 {
+		t.Errorf("expected an empty spool, got %d envelopes", len(envs))
+	}
+	envs, err := sp.Rejected()
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("expected the rejected envelope aside, got %d: %v", len(envs), err)
+	}
+	if !strings.Contains(envs[0].Error, "cannot parse request") {
+		t.Errorf("expected the rejection error, got %q", envs[0].Error)
+	}
+}
+
+func TestSpoolQueuePersistence(t *testing.T) {
+	// Nothing listens here, every delivery fails
+	mock := newMockZabbixServer(t)
+	address := mock.address
+	mock.Close()
+
+	s := NewSender(address)
+	s.StrictValidation = true
+	sp := &Spool{Dir: t.TempDir()}
+	q := NewQueue(s, QueueOptions{
+		BaseDelay:  10 * time.Millisecond,
+		Persist:    sp.Persist(s),
+		DeadLetter: sp.DeadLetter(s),
+	})
+	q.Enqueue(
+		NewMetric("zabbixTrapper1", "ping", "1", false),
+		NewMetric("zabbixTrapper1", "pong", "2", true),
+		NewMetric("zabbixTrapper1", "net.if.in[eth0", "3", false),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown with Persist should not fail: %v", err)
+	}
+
+	// One envelope per category for Replay, the invalid metric aside
+	envs, err := sp.Envelopes()
+	if err != nil || len(envs) != 2 {
+		t.Fatalf("expected 2 spooled envelopes, got %d: %v", len(envs), err)
+	}
+	for _, env := range envs {
+		enc, err := env.packet(s)
+		if err != nil {
+			t.Fatalf("error decoding envelope: %v", err)
+		}
+		if len(enc.packet.Data) != 1 {
+			t.Errorf("expected 1 metric per envelope, got %d", len(enc.packet.Data))
+		}
+	}
+	rejected, err := sp.Rejected()
+	if err != nil || len(rejected) != 1 || !strings.Contains(rejected[0].Error, "invalid item key") {
+		t.Errorf("expected the invalid metric aside, got %v: %v", rejected, err)
+	}
+}
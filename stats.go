@@ -0,0 +1,67 @@
+package zabbix_sender
+
+import "sync/atomic"
+
+// SenderStats are counters of a Sender since it was created or ResetStats,
+// see Sender.Stats. They cover the sends of Send, SendMetrics and their variants.
+type SenderStats struct {
+	// PacketsSent counts packets a host answered, PacketsFailed the sends to a
+	// host that failed (connection, timeout, invalid response). Each redirect hop
+	// is one send; Redirects counts the redirects followed.
+	PacketsSent   int64
+	PacketsFailed int64
+	Redirects     int64
+
+	// BytesWritten and BytesRead count the frames written and read, headers
+	// included. ConnectErrors counts failed connections, TLS handshakes included.
+	BytesWritten  int64
+	BytesRead     int64
+	ConnectErrors int64
+
+	// OverflowResponses counts responses with more bytes than their header declared,
+	// a framing bug of the server or frontend. The extra bytes are ignored and
+	// summed in OverflowBytes.
+	OverflowResponses int64
+	OverflowBytes     int64
+}
+
+// sendCounters holds the lock free counters behind SenderStats.
+type sendCounters struct {
+	packetsSent, packetsFailed, redirects  atomic.Int64
+	bytesWritten, bytesRead, connectErrors atomic.Int64
+	overflowResponses, overflowBytes       atomic.Int64
+}
+
+// Stats returns the counters of s.
+func (s *Sender) Stats() SenderStats {
+	return SenderStats{
+		PacketsSent:       s.counters.packetsSent.Load(),
+		PacketsFailed:     s.counters.packetsFailed.Load(),
+		Redirects:         s.counters.redirects.Load(),
+		BytesWritten:      s.counters.bytesWritten.Load(),
+		BytesRead:         s.counters.bytesRead.Load(),
+		ConnectErrors:     s.counters.connectErrors.Load(),
+		OverflowResponses: s.counters.overflowResponses.Load(),
+		OverflowBytes:     s.counters.overflowBytes.Load(),
+	}
+}
+
+// ResetStats sets the counters of Stats back to zero, e.g. after each scrape
+// of a monitoring system expecting deltas. The latency histogram is kept.
+func (s *Sender) ResetStats() {
+	for _, c := range []*atomic.Int64{
+		&s.counters.packetsSent, &s.counters.packetsFailed, &s.counters.redirects,
+		&s.counters.bytesWritten, &s.counters.bytesRead, &s.counters.connectErrors,
+		&s.counters.overflowResponses, &s.counters.overflowBytes,
+	} {
+		c.Store(0)
+	}
+}
+
+// recordOverflow counts a response with extra bytes beyond its declared length.
+func (s *Sender) recordOverflow(extra int) {
+	if extra > 0 {
+		s.counters.overflowResponses.Add(1)
+		s.counters.overflowBytes.Add(int64(extra))
+	}
+}
@@ -0,0 +1,112 @@
+package zabbix_sender
+
+import "testing"
+
+func TestStatsResponseOverflow(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		// Framing bug: trailing bytes beyond the declared length, in the same write
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		frame := "ZBXD\x01" + string(encodeDataLength(len(jsonResp))) + jsonResp + "garbage\n"
+		_, err = conn.Write([]byte(frame))
+		done <- err
+	}()
+
+	s := NewSender(mock.address)
+	if st := s.Stats(); st != (SenderStats{}) {
+		t.Errorf("expected zero stats before sending, got %+v", st)
+	}
+
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("response with trailing bytes should still decode: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("Response: expected success, got %s", res.Response)
+	}
+
+	st := s.Stats()
+	if st.OverflowResponses != 1 || st.OverflowBytes != int64(len("garbage\n")) {
+		t.Errorf("expected 1 overflow of %d bytes, got %+v", len("garbage\n"), st)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	working := newMockZabbixServer(t)
+	defer working.Close()
+	var received int32
+	go serveBroadcastMock(working, &received)
+
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	go func() {
+		for {
+			conn, err := redirecting.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := redirecting.readZabbixRequest(conn); err == nil {
+				redirecting.writeZabbixResponse(conn, `{"response":"failed","redirect":{"revision":1,"address":"`+working.address+`"}}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	var written, read int64
+	s := NewSenderHosts([]string{dead.address, redirecting.address})
+	s.OnWire = func(direction string, data []byte) {
+		if direction == WireWrite {
+			written += int64(len(data))
+		} else {
+			read += int64(len(data))
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+
+	// The dead host only fails the first send, the second starts at the cached host
+	expected := SenderStats{
+		PacketsSent:   4,
+		PacketsFailed: 1,
+		Redirects:     2,
+		BytesWritten:  written,
+		BytesRead:     read,
+		ConnectErrors: 1,
+	}
+	if st := s.Stats(); st != expected {
+		t.Errorf("expected %+v, got %+v", expected, st)
+	}
+	if written == 0 || read == 0 {
+		t.Errorf("expected bytes in both directions, got %d written and %d read", written, read)
+	}
+
+	s.ResetStats()
+	if st := s.Stats(); st != (SenderStats{}) {
+		t.Errorf("expected zero stats after ResetStats, got %+v", st)
+	}
+}
@@ -0,0 +1,88 @@
+package zabbix_sender
+
+import (
+	"context"
+	"time"
+)
+
+// StreamMetrics sends the metrics received from in in batches, as SendMetrics
+// does, and emits the result of each batch on the returned channel. A batch is
+// flushed once maxBatch metrics are pending and every flushInterval; maxBatch <= 0
+// and flushInterval <= 0 disable the respective trigger.
+//
+// When in is closed or ctx is done, the pending metrics, including those
+// already buffered in in, are flushed and the returned channel is closed.
+// Flushes are bounded by ctx, except this last one: it keeps the values of ctx,
+// e.g. a correlation ID, but only the Sender timeouts bound it, so a shutdown
+// does not abort it. The results must be received: the next batch waits for them.
+func (s *Sender) StreamMetrics(ctx context.Context, in <-chan *Metric, flushInterval time.Duration, maxBatch int) <-chan SendMetricsResult {
+	out := make(chan SendMetricsResult)
+	go s.stream(ctx, in, flushInterval, maxBatch, out)
+	return out
+}
+
+// stream runs StreamMetrics until in is closed or ctx is done.
+func (s *Sender) stream(ctx context.Context, in <-chan *Metric, flushInterval time.Duration, maxBatch int, out chan<- SendMetricsResult) {
+	defer close(out)
+
+	var tick <-chan time.Time
+	if flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var batch []*Metric
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		resActive, errActive, resTrapper, errTrapper := s.SendMetricsContext(ctx, batch)
+		out <- newSendMetricsResult(batch, resActive, errActive, resTrapper, errTrapper)
+		batch = nil
+	}
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				flush(ctx)
+				return
+			}
+			batch = append(batch, m)
+			if maxBatch > 0 && len(batch) >= maxBatch {
+				flush(ctx)
+			}
+		case <-tick:
+			flush(ctx)
+		case <-ctx.Done():
+			last := withoutCancel{ctx}
+			for drained := false; !drained; {
+				select {
+				case m, ok := <-in:
+					if !ok {
+						drained = true
+						break
+					}
+					batch = append(batch, m)
+					if maxBatch > 0 && len(batch) >= maxBatch {
+						flush(last)
+					}
+				default:
+					drained = true
+				}
+			}
+			flush(last)
+			return
+		}
+	}
+}
+
+// withoutCancel is a context with the values of ctx that is never done, like
+// context.WithoutCancel of Go 1.21.
+type withoutCancel struct{ ctx context.Context }
+
+func (withoutCancel) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (withoutCancel) Done() <-chan struct{}               { return nil }
+func (withoutCancel) Err() error                          { return nil }
+func (c withoutCancel) Value(key interface{}) interface{} { return c.ctx.Value(key) }
@@ -0,0 +1,132 @@
+package zabbix_sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// streamProcessed returns the number of trapper metrics the server processed in r.
+func streamProcessed(t *testing.T, r SendMetricsResult) int {
+	t.Helper()
+	if err := r.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := r.Trapper.GetInfo()
+	if err != nil {
+		t.Fatalf("unexpected info %q: %v", r.Trapper.Info, err)
+	}
+	return info.Processed
+}
+
+func TestStreamMetricsBatchSize(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	in := make(chan *Metric)
+	results := NewSender(mock.address).StreamMetrics(context.Background(), in, time.Hour, 3)
+	go func() {
+		for i := 0; i < 7; i++ {
+			in <- NewMetric("zabbixTrapper1", fmt.Sprintf("key%d", i), "1", false)
+		}
+		close(in)
+	}()
+
+	var sizes []int
+	for r := range results {
+		sizes = append(sizes, streamProcessed(t, r))
+	}
+	if fmt.Sprint(sizes) != "[3 3 1]" {
+		t.Errorf("expected batches [3 3 1], the remainder flushed on close, got %v", sizes)
+	}
+}
+
+func TestStreamMetricsInterval(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	in := make(chan *Metric)
+	defer close(in)
+	results := NewSender(mock.address).StreamMetrics(context.Background(), in, 20*time.Millisecond, 100)
+	in <- NewMetric("zabbixTrapper1", "ping", "1", false)
+	in <- NewMetric("zabbixTrapper1", "pong", "1", false)
+
+	select {
+	case r := <-results:
+		if n := streamProcessed(t, r); n != 2 {
+			t.Errorf("expected both metrics in the interval flush, got %d", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no flush on the interval")
+	}
+}
+
+func TestStreamMetricsContextDone(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	in := make(chan *Metric, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := NewSender(mock.address).StreamMetrics(ctx, in, 0, 0)
+	for i := 0; i < 4; i++ {
+		in <- NewMetric("zabbixTrapper1", fmt.Sprintf("key%d", i), "1", i%2 == 0)
+	}
+	cancel()
+
+	var total int
+	for r := range results {
+		if err := r.Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, res := range []*Response{r.Active, r.Trapper} {
+			if res == nil {
+				continue
+			}
+			if info, err := res.GetInfo(); err == nil {
+				total += info.Processed
+			}
+		}
+	}
+	if total != 4 {
+		t.Errorf("expected the 4 pending metrics flushed on shutdown, got %d", total)
+	}
+}
+
+func TestStreamMetricsFlushBoundedByContext(t *testing.T) {
+	// The host accepts but never answers
+	hung := newMockZabbixServer(t)
+	defer hung.Close()
+	go func() {
+		for {
+			conn, err := hung.listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := NewSender(hung.address)
+	s.ReadTimeout = 5 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	in := make(chan *Metric, 1)
+	in <- NewMetric("zabbixTrapper1", "ping", "1", false)
+	start := time.Now()
+	r := <-s.StreamMetrics(ctx, in, 0, 1)
+	if err := r.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the flush to end with ctx, took %v", elapsed)
+	}
+}
@@ -0,0 +1,241 @@
+package zabbix_sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed server and client certificate for
+// 127.0.0.1 and a pool trusting it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zabbix test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// newMockTLSZabbixServer creates a mock server accepting TLS connections with cert.
+func newMockTLSZabbixServer(t *testing.T, cert tls.Certificate) *mockZabbixServer {
+	mock := newMockZabbixServer(t)
+	mock.listener = tls.NewListener(mock.listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	return mock
+}
+
+// serveOnce answers one request on mock with success.
+func serveOnce(mock *mockZabbixServer, done chan<- error) {
+	conn, err := mock.listener.Accept()
+	if err != nil {
+		done <- err
+		return
+	}
+	defer conn.Close()
+
+	if _, err := mock.readZabbixRequest(conn); err != nil {
+		done <- err
+		return
+	}
+	done <- mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+}
+
+func TestSenderTLSConfig(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	mock := newMockTLSZabbixServer(t, cert)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+	go serveOnce(mock, done)
+
+	s := NewSender(mock.address)
+	s.TLSConfig = &tls.Config{RootCAs: pool}
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error sending over TLS: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %s", res.Response)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	if !s.Config().TLS {
+		t.Error("expected TLS in config")
+	}
+}
+
+func TestSenderTLSConfigClientCertificate(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	mock.listener = tls.NewListener(mock.listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	done := make(chan error, 1)
+	go serveOnce(mock, done)
+
+	s := NewSender(mock.address)
+	s.TLSConfig = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending with a client certificate: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestSenderTLSConfigVerificationFailure(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	mock := newMockTLSZabbixServer(t, cert)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+	go serveOnce(mock, done)
+
+	// The system CAs do not trust the self-signed certificate
+	s := NewSender(mock.address)
+	s.TLSConfig = &tls.Config{}
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	<-done
+
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "TLS handshake") || !strings.Contains(err.Error(), mock.address) {
+		t.Errorf("expected a TLS handshake error naming the host, got %v", err)
+	}
+}
+
+func TestSenderTLSConfigForHost(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	tlsMock := newMockTLSZabbixServer(t, cert)
+	defer tlsMock.Close()
+	plainMock := newMockZabbixServer(t)
+	defer plainMock.Close()
+
+	s := NewSenderHosts([]string{tlsMock.address, plainMock.address})
+	s.TLSConfigForHost = func(host string) *tls.Config {
+		if host == tlsMock.address {
+			return &tls.Config{RootCAs: pool}
+		}
+		return nil
+	}
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	for _, mock := range []*mockZabbixServer{tlsMock, plainMock} {
+		done := make(chan error, 1)
+		go serveOnce(mock, done)
+
+		s.PrimaryHost = mock.address
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("error sending to %s: %v", mock.address, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Mock server %s error: %v", mock.address, err)
+		}
+	}
+
+	// A TLS host rejects a plaintext connection
+	s.TLSConfigForHost = nil
+	done := make(chan error, 1)
+	go serveOnce(tlsMock, done)
+	s.Hosts = []string{tlsMock.address}
+	s.ReadTimeout = time.Second
+	if _, err := s.Send(packet); err == nil {
+		t.Error("expected plaintext send to the TLS host to fail")
+	}
+	<-done
+}
+
+func TestSenderTLSPSK(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	mock := newMockTLSZabbixServer(t, cert)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+	go serveOnce(mock, done)
+
+	// crypto/tls has no PSK suites: the hook stands in for a PSK implementation
+	// with a certificate handshake, layered over the dialed connection
+	var gotIdentity string
+	var gotKey []byte
+	s := NewSender(mock.address)
+	s.TLSPSKIdentity = "PSK 001"
+	s.TLSPSKKey = []byte{0x1f, 0x87, 0xb5}
+	s.PSKHandshake = func(ctx context.Context, conn net.Conn, identity string, key []byte) (net.Conn, error) {
+		gotIdentity, gotKey = identity, key
+		tlsConn := tls.Client(conn, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+		return tlsConn, tlsConn.HandshakeContext(ctx)
+	}
+
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error sending over PSK: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %s", res.Response)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	if gotIdentity != "PSK 001" || !bytes.Equal(gotKey, s.TLSPSKKey) {
+		t.Errorf("handshake got identity %q key %x", gotIdentity, gotKey)
+	}
+	if c := s.Config(); c.TLSPSKIdentity != "PSK 001" {
+		t.Errorf("expected PSK identity in config, got %q", c.TLSPSKIdentity)
+	}
+}
+
+func TestSenderTLSPSKUnsupported(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	s := NewSender(mock.address)
+	s.TLSPSKIdentity = "PSK 001"
+	s.TLSPSKKey = []byte{0x1f, 0x87, 0xb5}
+
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.Is(err, ErrPSKUnsupported) {
+		t.Fatalf("expected ErrPSKUnsupported, got %v", err)
+	}
+}
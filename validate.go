@@ -0,0 +1,167 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidKey is returned when an item key does not follow the Zabbix item key syntax.
+var ErrInvalidKey = errors.New("invalid item key")
+
+// ErrInvalidMetric is returned for a nil metric or a metric without host.
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// Validate checks that m is not nil, has a host and a valid key (see ValidateKey),
+// the metrics the server would silently count as failed items otherwise.
+// SendMetrics validates after Sender.UseLocalHostname filled in empty hosts.
+func (m *Metric) Validate() error {
+	if m == nil {
+		return fmt.Errorf("%w: nil metric", ErrInvalidMetric)
+	}
+	if m.Host == "" {
+		return fmt.Errorf("%w: empty host for key %q", ErrInvalidMetric, m.Key)
+	}
+	return ValidateKey(m.Key)
+}
+
+// ValidateKey checks the basic Zabbix item key syntax: a key name of
+// [0-9a-zA-Z_-.] characters, optionally followed by a bracketed, comma separated
+// parameter list. Parameters may be quoted, and one level of array nesting is allowed.
+//
+// It is not a full parser, it catches the common mistakes (illegal characters,
+// unbalanced brackets, unterminated quotes) before the server silently rejects the item.
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", ErrInvalidKey)
+	}
+
+	i := 0
+	for i < len(key) && isKeyNameChar(key[i]) {
+		i++
+	}
+	if i == 0 {
+		return fmt.Errorf("%w %q: illegal character %q at position %d", ErrInvalidKey, key, key[0], 0)
+	}
+	if i == len(key) {
+		return nil
+	}
+	if key[i] != '[' {
+		return fmt.Errorf("%w %q: illegal character %q at position %d", ErrInvalidKey, key, key[i], i)
+	}
+
+	end, err := validateKeyParams(key, i, 0)
+	if err != nil {
+		return fmt.Errorf("%w %q: %s", ErrInvalidKey, key, err)
+	}
+	if end != len(key) {
+		return fmt.Errorf("%w %q: unexpected %q after closing bracket at position %d", ErrInvalidKey, key, key[end:], end)
+	}
+	return nil
+}
+
+// isKeyNameChar reports whether c is allowed in an item key name.
+func isKeyNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '_' || c == '-' || c == '.'
+}
+
+// validateKeyParams validates the parameter list opening at key[pos] == '['
+// and returns the position after its closing bracket.
+func validateKeyParams(key string, pos, depth int) (int, error) {
+	i := pos + 1
+	for {
+		for i < len(key) && key[i] == ' ' {
+			i++
+		}
+		if i == len(key) {
+			return i, fmt.Errorf("unbalanced brackets: missing ']' for '[' at position %d", pos)
+		}
+
+		switch key[i] {
+		case '"':
+			start := i
+			for i++; i < len(key) && key[i] != '"'; i++ {
+				if key[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(key) {
+				return i, fmt.Errorf("unterminated quoted parameter at position %d", start)
+			}
+			i++
+			for i < len(key) && key[i] == ' ' {
+				i++
+			}
+		case '[':
+			if depth > 0 {
+				return i, fmt.Errorf("nested array parameter at position %d", i)
+			}
+			var err error
+			if i, err = validateKeyParams(key, i, depth+1); err != nil {
+				return i, err
+			}
+			for i < len(key) && key[i] == ' ' {
+				i++
+			}
+		default:
+			// unquoted parameters may contain anything but ',' and ']'
+			for i < len(key) && key[i] != ',' && key[i] != ']' {
+				i++
+			}
+		}
+
+		if i == len(key) {
+			return i, fmt.Errorf("unbalanced brackets: missing ']' for '[' at position %d", pos)
+		}
+		switch key[i] {
+		case ',':
+			i++
+		case ']':
+			return i + 1, nil
+		default:
+			return i, fmt.Errorf("expected ',' or ']' at position %d, got %q", i, key[i])
+		}
+	}
+}
+
+// prepareValid prepares metrics (see prepareMetrics) and validates the prepared
+// ones, returning the offending indices in metrics, the caller's batch, joined in
+// one error. Without StrictValidation only nil metrics, which can not be sent,
+// are errors. When origin is not nil, it maps each prepared metric to the
+// caller's metric it was made from.
+func (s *Sender) prepareValid(metrics []*Metric, origin map[*Metric]*Metric) ([]*Metric, error) {
+	prepared := make([]*Metric, 0, len(metrics))
+	var errs []error
+	for i, m := range metrics {
+		for _, p := range s.prepareMetrics([]*Metric{m}) {
+			if err := s.validateMetric(p); err != nil {
+				errs = append(errs, fmt.Errorf("metric %d: %w", i, err))
+			}
+			if origin != nil {
+				origin[p] = m
+			}
+			prepared = append(prepared, p)
+		}
+	}
+	return prepared, errors.Join(errs...)
+}
+
+// validateMetric validates a prepared metric as StrictValidation asks.
+func (s *Sender) validateMetric(m *Metric) error {
+	if !s.StrictValidation && m != nil {
+		return nil
+	}
+	return m.Validate()
+}
+
+// checkUniqueKeys returns ErrTooManyKeys when metrics have more than max distinct keys.
+func checkUniqueKeys(metrics []*Metric, max int) error {
+	keys := make(map[string]struct{})
+	for _, m := range metrics {
+		keys[m.Key] = struct{}{}
+	}
+	if len(keys) > max {
+		return fmt.Errorf("%w: %d distinct keys, limit %d", ErrTooManyKeys, len(keys), max)
+	}
+	return nil
+}
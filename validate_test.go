@@ -0,0 +1,183 @@
+package zabbix_sender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"simple", "agent.ping", true},
+		{"name chars", "custom_metric-1.total", true},
+		{"params", "net.if.in[eth0,bytes]", true},
+		{"empty params", "system.cpu.util[,idle]", true},
+		{"quoted params", `vfs.file.regexp["/var/log/app, main.log","error \"code\"",,]`, true},
+		{"spaces around quoted", `web.page.get[ "localhost" , 80 ]`, true},
+		{"array param", "vfs.fs.discovery[[ext4,xfs],/]", true},
+		{"empty", "", false},
+		{"illegal char in name", "cpu load", false},
+		{"illegal first char", "[eth0]", false},
+		{"illegal char after name", "cpu/load", false},
+		{"unbalanced open", "net.if.in[eth0", false},
+		{"unbalanced close", "net.if.in[eth0]]", false},
+		{"text after params", "net.if.in[eth0]x", false},
+		{"unterminated quote", `vfs.file.size["/tmp/file]`, false},
+		{"garbage after quote", `vfs.file.size["/tmp/file"x]`, false},
+		{"nested arrays", "key[[a,[b]]]", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKey(tt.key)
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", tt.key, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("expected ErrInvalidKey for %q, got %v", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestSendMetricsStrictValidation(t *testing.T) {
+	// No server: validation must fail before any network I/O
+	s := NewSender("127.0.0.1:1")
+	s.StrictValidation = true
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ping", "1", false),
+		NewMetric("zabbixTrapper1", "net.if.in[eth0", "2", false),
+	}
+
+	resActive, errActive, _, errTrapper := s.SendMetrics(metrics)
+	if !errors.Is(errTrapper, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey, got %v", errTrapper)
+	}
+	if errActive != nil || resActive.Response != "" {
+		t.Errorf("no active metrics: expected empty active result, got %v / %v", resActive, errActive)
+	}
+}
+
+func TestSendMetricsMaxUniqueKeys(t *testing.T) {
+	// No server: the batch must be rejected before any network I/O
+	s := NewSender("127.0.0.1:1")
+	s.MaxUniqueKeys = 3
+
+	var metrics []*Metric
+	for i := 0; i < 4; i++ {
+		metrics = append(metrics, NewMetric("zabbixTrapper1", fmt.Sprintf("runaway[%d]", i), "1", false))
+		metrics = append(metrics, NewMetric("zabbixTrapper2", fmt.Sprintf("runaway[%d]", i), "1", true))
+	}
+
+	_, errActive, _, errTrapper := s.SendMetrics(metrics)
+	if !errors.Is(errTrapper, ErrTooManyKeys) || !errors.Is(errActive, ErrTooManyKeys) {
+		t.Fatalf("expected ErrTooManyKeys for both categories, got %v / %v", errActive, errTrapper)
+	}
+
+	// Repeated keys count once
+	if err := checkUniqueKeys(metrics[:6], 3); err != nil {
+		t.Errorf("3 distinct keys within the limit: %v", err)
+	}
+}
+
+func TestMetricValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric *Metric
+		err    error
+	}{
+		{"valid", NewMetric("zabbixTrapper1", "ping", "1", false), nil},
+		{"empty host", NewMetric("", "ping", "1", false), ErrInvalidMetric},
+		{"empty key", NewMetric("zabbixTrapper1", "", "1", false), ErrInvalidKey},
+		{"nil", nil, ErrInvalidMetric},
+	}
+	for _, tt := range tests {
+		if err := tt.metric.Validate(); !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestSendMetricsStrictValidationMixed(t *testing.T) {
+	// No server: validation must fail before any network I/O
+	s := NewSender("127.0.0.1:1")
+	s.StrictValidation = true
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ping", "1", false),
+		NewMetric("", "ping", "2", true),
+		NewMetric("zabbixTrapper1", "pong", "3", true),
+		NewMetric("zabbixTrapper1", "", "4", false),
+	}
+
+	_, errActive, _, errTrapper := s.SendMetrics(metrics)
+	for _, err := range []error{errActive, errTrapper} {
+		if !errors.Is(err, ErrInvalidMetric) || !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("expected both failures, got %v", err)
+		}
+		msg := err.Error()
+		if !strings.Contains(msg, "metric 1:") || !strings.Contains(msg, "metric 3:") || strings.Contains(msg, "metric 0:") || strings.Contains(msg, "metric 2:") {
+			t.Errorf("expected offending indices 1 and 3, got %q", msg)
+		}
+	}
+
+	// Indices are the caller's, also when Transforms drop metrics
+	s.Transforms = []func(*Metric) *Metric{func(m *Metric) *Metric {
+		if m.Key == "ping" && m.Host != "" {
+			return nil
+		}
+		return m
+	}}
+	_, _, _, errTrapper = s.SendMetrics(metrics)
+	if msg := errTrapper.Error(); !strings.Contains(msg, "metric 3:") {
+		t.Errorf("expected offending index 3, got %q", msg)
+	}
+	s.Transforms = nil
+
+	// UseLocalHostname fills the empty host before validation
+	s.UseLocalHostname = true
+	if localHostname() != "" {
+		_, errActive, _, _ = s.SendMetrics(metrics[1:3])
+		if errors.Is(errActive, ErrInvalidMetric) {
+			t.Errorf("expected the local hostname to be used, got %v", errActive)
+		}
+	}
+}
+
+func TestSendMetricsDefaultValidation(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err == nil {
+			mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000030"}`)
+		}
+	}()
+
+	s := NewSender(mock.address)
+
+	// Invalid metrics are left to the server
+	if _, _, _, err := s.SendMetrics([]*Metric{NewMetric("", "ping", "1", false)}); err != nil {
+		t.Fatalf("expected the invalid metric to be sent, got %v", err)
+	}
+
+	// A nil metric can not be sent, it is still rejected before any network I/O
+	_, errActive, _, errTrapper := s.SendMetrics([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false), nil})
+	if !errors.Is(errActive, ErrInvalidMetric) || !errors.Is(errTrapper, ErrInvalidMetric) {
+		t.Errorf("expected ErrInvalidMetric for the nil metric, got %v / %v", errActive, errTrapper)
+	}
+	s.Transforms = []func(*Metric) *Metric{func(m *Metric) *Metric { return m }}
+	if _, _, _, err := s.SendMetrics([]*Metric{nil}); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("expected ErrInvalidMetric for the nil metric with Transforms, got %v", err)
+	}
+}
@@ -2,7 +2,15 @@
 package zabbix_sender
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -11,6 +19,13 @@ const (
 	defaultReadTimeout    = 15 * time.Second
 	defaultMaxRedirects   = 3
 	defaultUpdateHost     = false
+	defaultSampleRate     = 1.0
+
+	defaultFailedInfoRetries    = 1
+	defaultFailedInfoRetryDelay = 100 * time.Millisecond
+
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 10 * time.Second
 )
 
 // Metric represents a Zabbix metric.
@@ -20,7 +35,31 @@ type Metric struct {
 	Value  string `json:"value"`
 	Clock  int64  `json:"clock,omitempty"`
 	NS     int    `json:"ns,omitempty"`
-	Active bool   `json:"-"`
+	Active bool   `json:"-"` // active agent item ("agent data") instead of trapper, see SetActive
+
+	streamed *streamedValue // value source of NewMetricReader, shared by copies of the metric
+}
+
+// streamedValue is the value of a NewMetricReader metric. Its reader is read
+// once, on the first encoding of the metric or of any copy of it.
+type streamedValue struct {
+	r       io.Reader
+	once    sync.Once
+	encoded []byte // JSON string literal
+	err     error
+}
+
+// json returns the value as a JSON string literal, reading it on the first call.
+func (v *streamedValue) json(key string) ([]byte, error) {
+	v.once.Do(func() {
+		var buf bytes.Buffer
+		if err := encodeJSONString(&buf, v.r); err != nil {
+			v.err = fmt.Errorf("reading value of %s: %w", key, err)
+			return
+		}
+		v.encoded, v.r = buf.Bytes(), nil
+	})
+	return v.encoded, v.err
 }
 
 // NewMetric creates a Zabbix metric.
@@ -37,12 +76,152 @@ func NewMetric(host, key, value string, agentActive bool, t ...time.Time) *Metri
 	return m
 }
 
+// NewMetricFloat is like NewMetric for a numeric (float) value, formatted as the
+// shortest decimal that round trips, without exponent, e.g. "0.1" or "1500000".
+// Zabbix does not accept NaN and infinite values.
+func NewMetricFloat(host, key string, value float64, agentActive bool, t ...time.Time) *Metric {
+	return NewMetric(host, key, strconv.FormatFloat(value, 'f', -1, 64), agentActive, t...)
+}
+
+// NewMetricInt is like NewMetric for an integer value, formatted in decimal.
+// Items of type numeric (unsigned) reject negative values.
+func NewMetricInt(host, key string, value int64, agentActive bool, t ...time.Time) *Metric {
+	return NewMetric(host, key, strconv.FormatInt(value, 10), agentActive, t...)
+}
+
+// NewMetricBool is like NewMetric for a boolean value, sent as "1" or "0".
+func NewMetricBool(host, key string, value bool, agentActive bool, t ...time.Time) *Metric {
+	v := "0"
+	if value {
+		v = "1"
+	}
+	return NewMetric(host, key, v, agentActive, t...)
+}
+
+// SetActive sets whether the metric is sent as active agent data (true) or as
+// trapper data (false). It may be changed any time before the metric is sent;
+// SendMetrics reads it when splitting the metrics into packets.
+func (m *Metric) SetActive(active bool) {
+	m.Active = active
+}
+
+// NewTrapperMetrics creates a trapper metric ("sender data") for each key/value
+// of values, all with host and timestamp t. The order of the metrics is not defined.
+func NewTrapperMetrics(host string, values map[string]string, t time.Time) []*Metric {
+	return newMetrics(host, values, false, t)
+}
+
+// NewActiveMetrics creates an active agent metric ("agent data") for each key/value
+// of values, all with host and timestamp t. The order of the metrics is not defined.
+func NewActiveMetrics(host string, values map[string]string, t time.Time) []*Metric {
+	return newMetrics(host, values, true, t)
+}
+
+func newMetrics(host string, values map[string]string, agentActive bool, t time.Time) []*Metric {
+	metrics := make([]*Metric, 0, len(values))
+	for key, value := range values {
+		metrics = append(metrics, NewMetric(host, key, value, agentActive, t))
+	}
+	return metrics
+}
+
+var (
+	localHostnameOnce sync.Once
+	localHostnameName string
+)
+
+// localHostname returns the cached local machine name, or "" when it can not be determined.
+func localHostname() string {
+	localHostnameOnce.Do(func() {
+		localHostnameName, _ = os.Hostname()
+	})
+	return localHostnameName
+}
+
+// NewMetricReader creates a Zabbix metric whose value is read from r when the packet is encoded.
+//
+// It avoids building the whole value as a string for large log/text items: r is
+// read once, in chunks, straight into its JSON encoding, which is kept for
+// retries and redirects and shared by copies of the metric, e.g. the ones
+// Transforms work on. The frame header carries the data length, so the encoded
+// value is held in memory until the metric is dropped. A read error fails every
+// send of the metric. The Value field of the returned metric stays empty.
+func NewMetricReader(host, key string, r io.Reader, agentActive bool, t ...time.Time) *Metric {
+	m := NewMetric(host, key, "", agentActive, t...)
+	m.streamed = &streamedValue{r: r}
+	return m
+}
+
+// MarshalJSON encodes the metric, reading the value of reader backed metrics
+// on the first call. It does not modify m and is safe for concurrent use.
+func (m *Metric) MarshalJSON() ([]byte, error) {
+	type metric Metric
+	if m.streamed == nil {
+		return json.Marshal((*metric)(m))
+	}
+
+	value, err := m.streamed.json(m.Key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Host  string          `json:"host"`
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+		Clock int64           `json:"clock,omitempty"`
+		NS    int             `json:"ns,omitempty"`
+	}{m.Host, m.Key, value, m.Clock, m.NS})
+}
+
+// encodeJSONString writes the content of r to buf as a JSON string literal.
+// Chunks are encoded with encoding/json so escaping matches a plain string value.
+func encodeJSONString(buf *bytes.Buffer, r io.Reader) error {
+	chunk := make([]byte, 32*1024)
+	carry := 0
+
+	buf.WriteByte('"')
+	for {
+		n, err := r.Read(chunk[carry:])
+		n += carry
+
+		// keep an incomplete trailing rune for the next chunk
+		end := n
+		if err == nil {
+			for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+				if utf8.RuneStart(chunk[i]) {
+					if !utf8.FullRune(chunk[i:n]) {
+						end = i
+					}
+					break
+				}
+			}
+		}
+
+		if end > 0 {
+			encoded, _ := json.Marshal(string(chunk[:end]))
+			buf.Write(encoded[1 : len(encoded)-1])
+		}
+		carry = copy(chunk, chunk[end:n])
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('"')
+
+	return nil
+}
+
 // NewSender creates sender for single host.
 func NewSender(host string) *Sender {
 	return &Sender{
 		Hosts:          []string{normalizeHost(host)},
 		MaxRedirects:   defaultMaxRedirects,
 		UpdateHost:     defaultUpdateHost,
+		SampleRate:     defaultSampleRate,
 		ConnectTimeout: defaultConnectTimeout,
 		ReadTimeout:    defaultReadTimeout,
 		WriteTimeout:   defaultWriteTimeout,
@@ -59,6 +238,7 @@ func NewSenderHosts(hosts []string) *Sender {
 		Hosts:          norm,
 		MaxRedirects:   defaultMaxRedirects,
 		UpdateHost:     defaultUpdateHost,
+		SampleRate:     defaultSampleRate,
 		ConnectTimeout: defaultConnectTimeout,
 		ReadTimeout:    defaultReadTimeout,
 		WriteTimeout:   defaultWriteTimeout,
@@ -76,6 +256,7 @@ func NewSenderTimeout(
 		Hosts:          []string{normalizeHost(host)},
 		MaxRedirects:   defaultMaxRedirects,
 		UpdateHost:     defaultUpdateHost,
+		SampleRate:     defaultSampleRate,
 		ConnectTimeout: connectTimeout,
 		ReadTimeout:    readTimeout,
 		WriteTimeout:   writeTimeout,
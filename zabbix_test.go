@@ -1,12 +1,27 @@
 package zabbix_sender
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -19,23 +34,26 @@ type ZabbixRequestData struct {
 }
 
 type ZabbixRequest struct {
-	Request      string              `json:"request"`
-	Data         []ZabbixRequestData `json:"data"`
-	Clock        int                 `json:"clock"`
-	NS           int                 `json:"ns"`
-	Host         string              `json:"host"`
-	HostMetadata string              `json:"host_metadata"`
+	Request       string              `json:"request"`
+	Data          []ZabbixRequestData `json:"data"`
+	Clock         int                 `json:"clock"`
+	NS            int                 `json:"ns"`
+	Host          string              `json:"host"`
+	HostMetadata  string              `json:"host_metadata"`
+	Client        string              `json:"client"`
+	CorrelationID string              `json:"correlation_id"`
+	Compressed    bool                `json:"-"`
 }
 
 // mockZabbixServer is a helper struct to encapsulate mock server logic
 type mockZabbixServer struct {
 	listener net.Listener
 	address  string
-	t        *testing.T
+	t        testing.TB
 }
 
 // newMockZabbixServer creates a new mock server on a random available port
-func newMockZabbixServer(t *testing.T) *mockZabbixServer {
+func newMockZabbixServer(t testing.TB) *mockZabbixServer {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("Failed to create listener: %v", err)
@@ -66,7 +84,9 @@ func (m *mockZabbixServer) readZabbixRequest(conn net.Conn) (*ZabbixRequest, err
 		return nil, fmt.Errorf("failed to read data length: %w", err)
 	}
 
-	dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
+	// Low 4 bytes are the data length, high 4 bytes the uncompressed length (compressed frames only)
+	dataLength := binary.LittleEndian.Uint32(dataLengthRaw[:4])
+	compressed := header[4]&0x02 != 0
 
 	// Read data content
 	content := make([]byte, dataLength)
@@ -74,11 +94,22 @@ func (m *mockZabbixServer) readZabbixRequest(conn net.Conn) (*ZabbixRequest, err
 		return nil, fmt.Errorf("failed to read content: %w", err)
 	}
 
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open compressed content: %w", err)
+		}
+		if content, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to inflate content: %w", err)
+		}
+	}
+
 	// Parse JSON request
 	var request ZabbixRequest
 	if err := json.Unmarshal(content, &request); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal request: %w", err)
 	}
+	request.Compressed = compressed
 
 	return &request, nil
 }
@@ -380,6 +411,73 @@ func TestRegisterHostSuccess(t *testing.T) {
 	}
 }
 
+// TestRegisterHostWithOptions polls until the registration is confirmed
+func TestRegisterHostWithOptions(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				jsonResp := `{"response":"failed","info":"host [prueba] registration pending"}`
+				if atomic.AddInt32(&requests, 1) == 3 {
+					jsonResp = `{"response":"success","data":[]}`
+				}
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+
+	// The default 2 attempts are not enough
+	res, err := s.RegisterHostWithOptions("prueba", "prueba", RegisterHostOptions{})
+	if !errors.Is(err, ErrRegistrationFailed) {
+		t.Fatalf("expected ErrRegistrationFailed, got %v", err)
+	}
+	if res.Info != "host [prueba] registration pending" {
+		t.Errorf("expected the last server response, got %+v", res)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 attempts by default, got %d", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	start := time.Now()
+	res, err = s.RegisterHostWithOptions("prueba", "prueba", RegisterHostOptions{Attempts: 5, Delay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected success on the third attempt: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected the success response, got %+v", res)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected a delay between attempts, took %v", elapsed)
+	}
+
+	// The delay ends with ctx
+	atomic.StoreInt32(&requests, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = s.RegisterHostContext(ctx, "prueba", "prueba", RegisterHostOptions{Attempts: 5, Delay: time.Hour})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the delay to end with ctx, took %v", elapsed)
+	}
+}
+
 // TestRegisterHostNotFound tests error when host doesn't exist
 func TestRegisterHostNotFound(t *testing.T) {
 	mock := newMockZabbixServer(t)
@@ -388,28 +486,34 @@ func TestRegisterHostNotFound(t *testing.T) {
 	serverDone := make(chan error, 1)
 
 	go func() {
-		conn, err := mock.listener.Accept()
-		if err != nil {
-			return
-		}
-		defer conn.Close()
+		// Both attempts of RegisterHost are answered
+		for i := 0; i < 2; i++ {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
 
-		request, err := mock.readZabbixRequest(conn)
-		if err != nil {
-			serverDone <- err
-			return
-		}
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				conn.Close()
+				serverDone <- err
+				return
+			}
 
-		if request.Request != "active checks" {
-			serverDone <- fmt.Errorf("expected 'active checks', got '%s'", request.Request)
-			return
-		}
+			if request.Request != "active checks" {
+				conn.Close()
+				serverDone <- fmt.Errorf("expected 'active checks', got '%s'", request.Request)
+				return
+			}
 
-		// Host not found - return failure
-		jsonResp := `{"response":"failed","info":"host [prueba] not found"}`
-		if err := mock.writeZabbixResponse(conn, jsonResp); err != nil {
-			serverDone <- err
-			return
+			// Host not found - return failure
+			jsonResp := `{"response":"failed","info":"host [prueba] not found"}`
+			err = mock.writeZabbixResponse(conn, jsonResp)
+			conn.Close()
+			if err != nil {
+				serverDone <- err
+				return
+			}
 		}
 
 		serverDone <- nil
@@ -481,78 +585,2665 @@ func TestInvalidResponseHeader(t *testing.T) {
 	}
 }
 
-func TestNewMetricsWithTime(t *testing.T) {
-	now := time.Now()
-	m := NewMetric("zabbixAgent1", "ping", "13", false, now)
+// responseFrame builds a response frame with flags, compressing jsonResp and
+// using 8 byte lengths as the flags require.
+func responseFrame(t *testing.T, flags byte, jsonResp string) []byte {
+	data, size := []byte(jsonResp), len(jsonResp)
+	if flags&flagCompressed != 0 {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
 
-	if m.Clock != now.Unix() {
-		t.Errorf("Clock: expected %d, got %d", now.Unix(), m.Clock)
+	frame := append([]byte("ZBXD"), flags)
+	if flags&flagLarge != 0 {
+		frame = binary.LittleEndian.AppendUint64(frame, uint64(len(data)))
+		frame = binary.LittleEndian.AppendUint64(frame, uint64(size))
+	} else {
+		frame = binary.LittleEndian.AppendUint32(frame, uint32(len(data)))
+		frame = binary.LittleEndian.AppendUint32(frame, uint32(size))
 	}
-	if m.NS != now.Nanosecond() {
-		t.Errorf("NS: expected %d, got %d", now.Nanosecond(), m.NS)
+	return append(frame, data...)
+}
+
+func TestResponseHeaderFlags(t *testing.T) {
+	const jsonResp = `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+	tests := []struct {
+		flags      byte
+		compressed bool
+		large      bool
+	}{
+		{0x01, false, false},
+		{0x03, true, false},
+		{0x05, false, true},
+		{0x07, true, true},
+		{0x81, false, false}, // unknown flags are ignored
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%02x", tt.flags), func(t *testing.T) {
+			mock := newMockZabbixServer(t)
+			defer mock.Close()
+			go func() {
+				conn, err := mock.listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if _, err := mock.readZabbixRequest(conn); err == nil {
+					conn.Write(responseFrame(t, tt.flags, jsonResp))
+				}
+			}()
+
+			res, err := NewSender(mock.address).Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Response != "success" {
+				t.Errorf("expected success, got %+v", res)
+			}
+			if byte(res.Flags) != tt.flags || res.Flags.Compressed() != tt.compressed || res.Flags.Large() != tt.large {
+				t.Errorf("unexpected flags 0x%02x: compressed=%t large=%t", byte(res.Flags), res.Flags.Compressed(), res.Flags.Large())
+			}
+		})
+	}
+
+	frame := responseFrame(t, 0x01, jsonResp)
+	copy(frame, "ZBXE")
+	if _, err := decodeResponse(frame, "host"); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("bad magic: expected ErrInvalidHeader, got %v", err)
+	}
+
+	// Declared lengths are bounded before anything is allocated
+	frame = responseFrame(t, 0x05, jsonResp)
+	binary.LittleEndian.PutUint64(frame[5:13], math.MaxUint64)
+	if _, err := readFrame(bytes.NewReader(frame)); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("huge data length: expected ErrResponseTooLarge, got %v", err)
+	}
+	frame = responseFrame(t, 0x07, jsonResp)
+	binary.LittleEndian.PutUint64(frame[13:21], math.MaxUint64)
+	if _, err := decodeResponse(frame, "host"); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("huge decompressed length: expected ErrResponseTooLarge, got %v", err)
 	}
 }
 
-func TestNewPacketWithTime(t *testing.T) {
-	now := time.Now()
+func TestResponseStructuredInfo(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
 
-	m1 := NewMetric("zabbixAgent1", "ping", "13", false, time.Now())
-	m2 := NewMetric("zabbixAgent2", "pong", "42", true, time.Now())
+	done := make(chan error, 1)
 
-	p := NewPacket([]*Metric{m1, m2}, false, now)
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
 
-	if p.Clock != now.Unix() {
-		t.Errorf("Clock: expected %d, got %d", now.Unix(), p.Clock)
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		// Stats as JSON numbers, info left free-form
+		jsonResp := `{"response":"success","info":"accepted","processed":2,"failed":1,"total":3,"seconds_spent":0.5}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	m1 := NewMetric("zabbixTrapper1", "ping", "13", false)
+	m2 := NewMetric("zabbixTrapper1", "pong", "13", false)
+	m3 := NewMetric("zabbixTrapper1", "pang", "13", false)
+	s := NewSender(mock.address)
+	_, _, resTrapper, errTrapper := s.SendMetrics([]*Metric{m1, m2, m3})
+	if errTrapper != nil {
+		t.Fatalf("error sending trapper metrics: %v", errTrapper)
 	}
-	if p.NS != now.Nanosecond() {
-		t.Errorf("NS: expected %d, got %d", now.Nanosecond(), p.NS)
+
+	info, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("error getting trapper response info: %v", err)
+	}
+
+	if info.Processed != 2 {
+		t.Errorf("Processed: expected 2, got %d", info.Processed)
+	}
+	if info.Failed != 1 {
+		t.Errorf("Failed: expected 1, got %d", info.Failed)
+	}
+	if info.Total != 3 {
+		t.Errorf("Total: expected 3, got %d", info.Total)
+	}
+	if info.Spent != 500*time.Millisecond {
+		t.Errorf("Spent: expected %v, got %v", 500*time.Millisecond, info.Spent)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
 	}
 }
 
-func TestNormalizeHost_DefaultPort(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    interface{} // can be string or []string
-		expected []string
-	}{
-		{
-			name:     "single host without port",
-			input:    "zabbix-proxy",
-			expected: []string{"zabbix-proxy:10051"},
-		},
-		{
-			name:     "single host with port",
-			input:    "zabbix-proxy:10051",
-			expected: []string{"zabbix-proxy:10051"},
-		},
-		{
-			name:     "multiple hosts mixed",
-			input:    []string{"zabbix-proxy1:10051", "zabbix-proxy2"},
-			expected: []string{"zabbix-proxy1:10051", "zabbix-proxy2:10051"},
-		},
+func TestSendClientName(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		if request.Client != "billing-service/1.4.2" {
+			done <- fmt.Errorf("expected client 'billing-service/1.4.2', got '%s'", request.Client)
+			return
+		}
+
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(mock.address)
+	s.ClientName = "billing-service/1.4.2"
+	_, _, _, errTrapper := s.SendMetrics([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)})
+	if errTrapper != nil {
+		t.Fatalf("error sending trapper metric: %v", errTrapper)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var s *Sender
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
 
-			switch v := tt.input.(type) {
-			case string:
-				s = NewSender(v)
-			case []string:
-				s = NewSenderHosts(v)
-			}
+	// The client name is set on a copy, a packet shared by senders is not modified
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	enc, err := s.EncodePacket(packet)
+	if err != nil {
+		t.Fatalf("error encoding packet: %v", err)
+	}
+	if packet.Client != "" || !bytes.Contains(enc.data, []byte(`"client":"billing-service/1.4.2"`)) {
+		t.Errorf("expected the client name in the encoding only, got %q in the packet", packet.Client)
+	}
+}
 
-			if len(s.Hosts) != len(tt.expected) {
-				t.Fatalf("expected %d hosts, got %d", len(tt.expected), len(s.Hosts))
-			}
+func TestPacketClientOmittedWhenEmpty(t *testing.T) {
+	p := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
 
-			for i, expected := range tt.expected {
-				if s.Hosts[i] != expected {
-					t.Errorf("host[%d]: expected %s, got %s", i, expected, s.Hosts[i])
-				}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("error marshaling packet: %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("error unmarshaling packet: %v", err)
+	}
+	if _, ok := raw["client"]; ok {
+		t.Errorf("expected no client field, got %s", data)
+	}
+}
+
+func TestSendItemTypeMismatch(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	responses := make(chan string, 1)
+	go func() {
+		for jsonResp := range responses {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
 			}
-		})
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+		}
+	}()
+	defer close(responses)
+
+	s := NewSender(mock.address)
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "trap", "1", false),
+		NewMetric("zabbixTrapper1", "agent.ping", "1", false),
+	}
+
+	// Result of history.push (Zabbix 7.0) for a value stored and a value sent
+	// to a "Zabbix agent (active)" item
+	responses <- `{"response":"success","data":[{"itemid":"45503"},{"error":"Unsupported item type."}]}`
+	_, _, res, errTrapper := s.SendMetrics(metrics)
+	var mismatch *ItemTypeMismatchError
+	if !errors.Is(errTrapper, ErrItemTypeMismatch) || !errors.As(errTrapper, &mismatch) {
+		t.Fatalf("expected ErrItemTypeMismatch, got %v", errTrapper)
+	}
+	if len(mismatch.Items) != 1 || mismatch.Items[0].Index != 1 || res.Response != "success" {
+		t.Errorf("expected the second item reported, got %+v", mismatch)
+	}
+
+	// The sender protocol only counts the item as failed: no mismatch to detect
+	responses <- `{"response":"success","info":"processed: 1; failed: 1; total: 2; seconds spent: 0.000045"}`
+	if _, _, _, err := s.SendMetrics(metrics); err != nil {
+		t.Errorf("expected no error for failed items without reason, got %v", err)
+	}
+}
+
+func TestSendUseLocalHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("local hostname not available: %v", err)
+	}
+
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan *ZabbixRequest, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		received <- request
+
+		jsonResp := `{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	hostless := NewMetric("", "ping", "13", false)
+	explicit := NewMetric("explicitHost", "pong", "13", false)
+
+	s := NewSender(mock.address)
+	s.UseLocalHostname = true
+	if _, _, _, errTrapper := s.SendMetrics([]*Metric{hostless, explicit}); errTrapper != nil {
+		t.Fatalf("error sending trapper metrics: %v", errTrapper)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	request := <-received
+	if request.Data[0].Host != hostname {
+		t.Errorf("hostless metric: expected host %s, got %s", hostname, request.Data[0].Host)
+	}
+	if request.Data[1].Host != "explicitHost" {
+		t.Errorf("explicit metric: expected host explicitHost, got %s", request.Data[1].Host)
+	}
+	if hostless.Host != "" {
+		t.Errorf("caller metric should not be modified, got host %s", hostless.Host)
+	}
+}
+
+func TestSendCompressMinBytes(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	compressed := make(chan bool, 2)
+	done := make(chan error, 1)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				conn.Close()
+				done <- err
+				return
+			}
+			compressed <- request.Compressed
+
+			jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+			if err := mock.writeZabbixResponse(conn, jsonResp); err != nil {
+				conn.Close()
+				done <- err
+				return
+			}
+			conn.Close()
+		}
+		done <- nil
+	}()
+
+	s := NewSender(mock.address)
+	s.Compression = true
+	s.CompressMinBytes = 512
+
+	small := NewMetric("zabbixTrapper1", "ping", "13", false)
+	if _, _, _, err := s.SendMetrics([]*Metric{small}); err != nil {
+		t.Fatalf("error sending small packet: %v", err)
+	}
+
+	large := NewMetric("zabbixTrapper1", "log", strings.Repeat("x", 2048), false)
+	if _, _, _, err := s.SendMetrics([]*Metric{large}); err != nil {
+		t.Fatalf("error sending large packet: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	if <-compressed {
+		t.Error("packet below CompressMinBytes should be sent uncompressed")
+	}
+	if !<-compressed {
+		t.Error("packet above CompressMinBytes should be sent compressed")
+	}
+}
+
+func TestSendCompressedRoundTrip(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	value := strings.Repeat("compressible ", 1000)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		frame, err := readFrame(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		if string(frame[:5]) != "ZBXD\x03" {
+			done <- fmt.Errorf("expected compressed header, got % x", frame[:5])
+			return
+		}
+		data, err := inflate(frame[13:], uint64(binary.LittleEndian.Uint32(frame[9:13])))
+		if err != nil {
+			done <- err
+			return
+		}
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			done <- fmt.Errorf("reserved field does not match the uncompressed length: %w", err)
+			return
+		}
+		if len(request.Data) != 1 || request.Data[0].Value != value {
+			done <- fmt.Errorf("unexpected request data %+v", request.Data)
+			return
+		}
+
+		// Reply compressed as well
+		_, err = conn.Write(compressedFrame([]byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)))
+		done <- err
+	}()
+
+	s := NewSender(mock.address)
+	s.Compression = true
+
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "log", value, false)}, false))
+	if err != nil {
+		t.Fatalf("error sending compressed packet: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success from compressed response, got %s", res.Response)
+	}
+}
+
+func TestResponseWithBOM(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		jsonResp := "\xef\xbb\xbf \n" + `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}` + "\r\n"
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(mock.address)
+	_, _, resTrapper, errTrapper := s.SendMetrics([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)})
+	if errTrapper != nil {
+		t.Fatalf("response with BOM should decode: %v", errTrapper)
+	}
+	if resTrapper.Response != "success" {
+		t.Errorf("Response: expected success, got %s", resTrapper.Response)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestResponseArray(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		jsonResp := `[{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"},` +
+			`{"response":"success","info":"processed: 1; failed: 1; total: 2; seconds spent: 0.000020"}]`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "a", "1", false),
+		NewMetric("zabbixTrapper1", "b", "2", false),
+		NewMetric("zabbixTrapper1", "c", "3", false),
+		NewMetric("zabbixTrapper1", "d", "4", false),
+	}
+	s := NewSender(mock.address)
+	_, _, resTrapper, errTrapper := s.SendMetrics(metrics)
+	if errTrapper != nil {
+		t.Fatalf("response array should decode: %v", errTrapper)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	if resTrapper.Response != "success" {
+		t.Errorf("Response: expected success, got %s", resTrapper.Response)
+	}
+	if len(resTrapper.Parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(resTrapper.Parts))
+	}
+	info, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("error parsing aggregate info: %v", err)
+	}
+	if info.Processed != 3 || info.Failed != 1 || info.Total != 4 || info.Spent != 50*time.Microsecond {
+		t.Errorf("unexpected aggregate %+v", info)
+	}
+
+	// A failed part fails the aggregate
+	res := aggregateResponses([]Response{
+		{Response: "success", Info: "processed: 1; failed: 0; total: 1; seconds spent: 0.000030"},
+		{Response: "failed", Info: "host [prueba] not found"},
+	})
+	if res.Response != "failed" || res.Info != "host [prueba] not found" || len(res.Parts) != 2 {
+		t.Errorf("expected the failed part as aggregate, got %+v", res)
+	}
+}
+
+func TestResponseCaptureUnknownFields(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030","warning":"value cache 90% full","error_code":0}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	res, err := s.Send(packet)
+	if err != nil {
+		t.Fatalf("unknown fields should be ignored by default: %v", err)
+	}
+	if res.Extra != nil {
+		t.Errorf("expected no captured fields by default, got %v", res.Extra)
+	}
+
+	s.CaptureUnknownFields = true
+	if res, err = s.Send(packet); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	if len(res.Extra) != 2 || string(res.Extra["warning"]) != `"value cache 90% full"` || string(res.Extra["error_code"]) != "0" {
+		t.Errorf("expected the unknown fields captured, got %v", res.Extra)
+	}
+	if res.Response != "success" {
+		t.Errorf("known fields should still decode, got %s", res.Response)
+	}
+}
+
+func TestDecodeResponsesWithSeparator(t *testing.T) {
+	frame := func(jsonData string) string {
+		return "ZBXD\x01" + string(encodeDataLength(len(jsonData))) + jsonData
+	}
+
+	stream := frame(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`) +
+		"\n" +
+		frame(`{"response":"failed","info":"host [prueba] not found"}`) +
+		"\r\n"
+
+	responses, err := DecodeResponses(strings.NewReader(stream), '\r', '\n')
+	if err != nil {
+		t.Fatalf("error decoding responses: %v", err)
+	}
+
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(responses))
+	}
+	if responses[0].Response != "success" {
+		t.Errorf("response[0]: expected success, got %s", responses[0].Response)
+	}
+	if responses[1].Response != "failed" || responses[1].Info != "host [prueba] not found" {
+		t.Errorf("response[1]: unexpected %+v", responses[1])
+	}
+
+	// Without the separator configured the stream is misaligned
+	if _, err := DecodeResponses(strings.NewReader(stream)); err == nil {
+		t.Error("expected error decoding separated frames without separators")
+	}
+}
+
+func TestSendMetricsDeadline(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(mock.address)
+	metrics := []*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}
+
+	// Past deadline: nothing is sent
+	start := time.Now()
+	_, _, _, errTrapper := s.SendMetricsDeadline(time.Now().Add(-time.Second), metrics)
+	if !errors.Is(errTrapper, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded for past deadline, got %v", errTrapper)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("past deadline should return immediately, took %v", elapsed)
+	}
+
+	// Future deadline: the mock accepts only this send
+	_, _, resTrapper, errTrapper := s.SendMetricsDeadline(time.Now().Add(2*time.Second), metrics)
+	if errTrapper != nil {
+		t.Fatalf("error sending with future deadline: %v", errTrapper)
+	}
+	if resTrapper.Response != "success" {
+		t.Errorf("Response: expected success, got %s", resTrapper.Response)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestSendServerRejected(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// Second HA host must not be tried once the first one answered
+	other := newMockZabbixServer(t)
+	defer other.Close()
+	otherAccepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := other.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		otherAccepted <- struct{}{}
+	}()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+
+		jsonResp := `{"response":"failed","info":"host [zabbixTrapper1] not monitored"}`
+		done <- mock.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSenderHosts([]string{mock.address, other.address})
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+
+	var rejected *ServerRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("expected *ServerRejectedError, got %v", err)
+	}
+	if rejected.Host != mock.address {
+		t.Errorf("rejected host: expected %s, got %s", mock.address, rejected.Host)
+	}
+	if res.Response != "failed" {
+		t.Errorf("Response: expected failed, got %s", res.Response)
+	}
+	if res.Info != "host [zabbixTrapper1] not monitored" {
+		t.Errorf("Info: expected server message, got %s", res.Info)
+	}
+	if rejected.Response.Info != res.Info {
+		t.Errorf("error response info: expected %s, got %s", res.Info, rejected.Response.Info)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	select {
+	case <-otherAccepted:
+		t.Error("second host should not be tried after a clean rejection")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendMetricsWithOptionsTimeouts(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := mock.readZabbixRequest(conn); err != nil {
+					return
+				}
+				// Slow endpoint: answer after 150ms
+				time.Sleep(150 * time.Millisecond)
+				jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.150000"}`
+				mock.writeZabbixResponse(conn, jsonResp)
+			}()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.ReadTimeout = 50 * time.Millisecond
+
+	metrics := []*Metric{
+		NewMetric("zabbixAgent1", "ping", "13", true),
+		NewMetric("zabbixTrapper1", "pong", "13", false),
+	}
+	opts := SendMetricsOptions{
+		Active: Timeouts{Read: time.Second}, // overrides the sender read timeout
+	}
+
+	resActive, errActive, _, errTrapper := s.SendMetricsWithOptions(context.Background(), metrics, opts)
+
+	if errActive != nil {
+		t.Fatalf("active send with longer read timeout should succeed: %v", errActive)
+	}
+	if resActive.Response != "success" {
+		t.Errorf("active Response: expected success, got %s", resActive.Response)
+	}
+	if errTrapper == nil {
+		t.Fatal("trapper send with sender read timeout should time out")
+	}
+	var netErr net.Error
+	if !errors.As(errTrapper, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected trapper timeout error, got %v", errTrapper)
+	}
+}
+
+func TestSendStalledServerCombinedDeadline(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			return
+		}
+		// Stall after reading the request, never answer
+		<-release
+	}()
+
+	s := NewSender(mock.address)
+	s.WriteTimeout = 100 * time.Millisecond
+	s.ReadTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	elapsed := time.Since(start)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if budget := s.WriteTimeout + s.ReadTimeout; elapsed > budget+500*time.Millisecond {
+		t.Errorf("send took %v, expected to abort within the combined budget %v", elapsed, budget)
+	}
+}
+
+func TestSendContextCanceledMidFlight(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			return
+		}
+		// Never answer
+		<-release
+	}()
+
+	s := NewSender(mock.address)
+	s.ReadTimeout = 10 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := s.SendContext(ctx, NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancellation took %v, expected the read to be aborted", elapsed)
+	}
+}
+
+func TestSendMetricsFirstSuccess(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, err := mock.readZabbixRequest(conn)
+				if err != nil {
+					return
+				}
+				if request.Request == "sender data" {
+					// Trapper endpoint fails slowly
+					time.Sleep(500 * time.Millisecond)
+					return
+				}
+				jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+				mock.writeZabbixResponse(conn, jsonResp)
+			}()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	metrics := []*Metric{
+		NewMetric("zabbixAgent1", "ping", "13", true),
+		NewMetric("zabbixTrapper1", "pong", "13", false),
+	}
+
+	start := time.Now()
+	resActive, errActive, _, errTrapper := s.SendMetricsWithOptions(context.Background(), metrics, SendMetricsOptions{FirstSuccess: true, AbandonSlower: true})
+	elapsed := time.Since(start)
+
+	if errActive != nil {
+		t.Fatalf("active send should succeed: %v", errActive)
+	}
+	if resActive.Response != "success" {
+		t.Errorf("active Response: expected success, got %s", resActive.Response)
+	}
+	if !errors.Is(errTrapper, ErrSendPending) {
+		t.Errorf("expected ErrSendPending for the slow trapper send, got %v", errTrapper)
+	}
+	if elapsed > 300*time.Millisecond {
+		t.Errorf("expected return on active success, took %v", elapsed)
+	}
+}
+
+func TestGetInfoConsistency(t *testing.T) {
+	inconsistent := Response{Response: "success", Info: "processed: 3; failed: 1; total: 3; seconds spent: 0.000030"}
+
+	// Lenient by default
+	if _, err := inconsistent.GetInfo(); err != nil {
+		t.Fatalf("GetInfo should be lenient by default: %v", err)
+	}
+
+	info, err := inconsistent.GetInfoOptions(InfoOptions{CheckConsistency: true})
+	if !errors.Is(err, ErrInconsistentInfo) {
+		t.Fatalf("expected ErrInconsistentInfo, got %v", err)
+	}
+	if info == nil || info.Processed != 3 || info.Failed != 1 || info.Total != 3 {
+		t.Errorf("expected parsed statistics alongside the error, got %+v", info)
+	}
+
+	consistent := Response{Response: "success", Info: "processed: 2; failed: 1; total: 3; seconds spent: 0.000030"}
+	if _, err := consistent.GetInfoOptions(InfoOptions{CheckConsistency: true}); err != nil {
+		t.Errorf("consistent info should pass the check: %v", err)
+	}
+}
+
+func TestGetInfoSpentUnits(t *testing.T) {
+	tests := []struct {
+		info string
+		opts InfoOptions
+	}{
+		{"processed: 1; failed: 0; total: 1; seconds spent: 0.030", InfoOptions{}},
+		{"processed: 1; failed: 0; total: 1; ms spent: 30", InfoOptions{}},
+		{"processed: 1; failed: 0; total: 1; milliseconds spent: 30.0", InfoOptions{}},
+		{"processed: 1; failed: 0; total: 1; us spent: 30000", InfoOptions{}},
+		{"processed: 1; failed: 0; total: 1; microseconds spent: 30000", InfoOptions{}},
+		{"processed: 1; failed: 0; total: 1; ns taken: 30000000", InfoOptions{SpentUnits: map[string]time.Duration{"ns taken": time.Nanosecond}}},
+	}
+
+	for _, tt := range tests {
+		r := Response{Response: "success", Info: tt.info}
+		info, err := r.GetInfoOptions(tt.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.info, err)
+			continue
+		}
+		if info.Spent != 30*time.Millisecond {
+			t.Errorf("%s: expected 30ms spent, got %v", tt.info, info.Spent)
+		}
+	}
+
+	// Unknown units are ignored
+	r := Response{Response: "success", Info: "processed: 1; failed: 0; total: 1; ns taken: 30000000"}
+	if info, err := r.GetInfo(); err != nil || info.Spent != 0 {
+		t.Errorf("expected unknown unit to be ignored, got %v, %v", info, err)
+	}
+}
+
+func TestGetInfoFieldOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+	}{
+		{"reordered", "total: 3; seconds spent: 0.000030; failed: 1; processed: 2"},
+		{"whitespace padded", "  processed :2 ;failed:   1;\ttotal:3\t; seconds spent :0.000030  "},
+		{"extra fields", "processed: 2; failed: 1; skipped: 7; total: 3; seconds spent: 0.000030; queued: 4"},
+		{"trailing separator", "processed: 2; failed: 1; total: 3; seconds spent: 0.000030;"},
+		{"no time spent", "processed: 2; failed: 1; total: 3"},
+	}
+
+	for _, tt := range tests {
+		r := Response{Response: "success", Info: tt.info}
+		info, err := r.GetInfo()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if info.Processed != 2 || info.Failed != 1 || info.Total != 3 {
+			t.Errorf("%s: unexpected info %+v", tt.name, info)
+		}
+	}
+
+	r := Response{Response: "success", Info: "processed: 2; seconds spent: 0.000030"}
+	_, err := r.GetInfo()
+	if err == nil || !strings.Contains(err.Error(), "missing failed, total") {
+		t.Errorf("expected the missing fields named, got %v", err)
+	}
+
+	r.Info = "processed: two; failed: 0; total: 2"
+	if _, err := r.GetInfo(); err == nil || !strings.Contains(err.Error(), "processed") {
+		t.Errorf("expected an error for the invalid count, got %v", err)
+	}
+}
+
+func TestGetInfoGroupSeparators(t *testing.T) {
+	r := Response{Response: "success", Info: "processed: 1,234; failed: 5; total: 1,239; seconds spent: 0.000030"}
+
+	info, err := r.GetInfoOptions(InfoOptions{GroupSeparators: ","})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Processed != 1234 || info.Failed != 5 || info.Total != 1239 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	r.Info = "processed: 1.234.567; failed: 0; total: 1 234 567; seconds spent: 0.000030"
+	info, err = r.GetInfoOptions(InfoOptions{GroupSeparators: ". "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Processed != 1234567 || info.Total != 1234567 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestEncodeDataLen64Bit(t *testing.T) {
+	for _, n := range []uint64{0, 123, 1<<32 - 1} {
+		header := frameHeader(n)
+		if len(header) != 13 || header[4] != flagZabbix {
+			t.Fatalf("%d: expected a 13 byte header without flagLarge, got % x", n, header)
+		}
+		if got := binary.LittleEndian.Uint32(header[5:9]); uint64(got) != n {
+			t.Errorf("%d: round-tripped as %d (% x)", n, got, header)
+		}
+		if reserved := binary.LittleEndian.Uint32(header[9:13]); reserved != 0 {
+			t.Errorf("%d: expected reserved 0, got %d", n, reserved)
+		}
+	}
+
+	// Above 4 GiB the large packet header carries 8 byte lengths
+	for _, n := range []uint64{5 << 30, 1<<63 + 7} {
+		header := frameHeader(n)
+		if len(header) != 21 || header[4] != flagZabbix|flagLarge || headerLen(header) != 21 {
+			t.Fatalf("%d: expected a 21 byte header with flagLarge, got % x", n, header)
+		}
+		if dataLen, reserved := frameLengths(header); dataLen != n || reserved != 0 {
+			t.Errorf("%d: round-tripped as %d, reserved %d", n, dataLen, reserved)
+		}
+	}
+
+	p := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	data, _ := json.Marshal(p)
+	if got := binary.LittleEndian.Uint64(p.DataLen()); got != uint64(len(data)) {
+		t.Errorf("DataLen: expected %d, got %d", len(data), got)
+	}
+}
+
+func TestPacketEncode(t *testing.T) {
+	s := NewSender("localhost")
+	p := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	frame, err := p.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	enc, _ := s.EncodePacket(p)
+	if sent, _ := enc.frame(false); !bytes.Equal(frame, sent) {
+		t.Errorf("Encode differs from the sent frame:\n%q\n%q", frame, sent)
+	}
+	if !bytes.Equal(frame[5:13], p.DataLen()) {
+		t.Errorf("Encode length % x differs from DataLen % x", frame[5:13], p.DataLen())
+	}
+}
+
+func TestSendRequestPacket(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		received <- data[13:] // after the header and data length
+		mock.writeZabbixResponse(conn, `{"response":"success","data":"command output"}`)
+	}()
+
+	s := NewSender(mock.address)
+	p := NewRequestPacket("command", map[string]interface{}{"scriptid": 5, "hostid": "10084"})
+	res, err := s.Send(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res.Data) != `"command output"` {
+		t.Errorf("expected the command output, got %s", res.Data)
+	}
+
+	expected := `{"request":"command","hostid":"10084","scriptid":5}`
+	if data := <-received; string(data) != expected {
+		t.Errorf("expected request %s, got %s", expected, data)
+	}
+
+	p.Fields["host"] = "override"
+	if _, err := s.Send(p); err == nil || !strings.Contains(err.Error(), `field "host"`) {
+		t.Errorf("expected an error for a field repeating a packet field, got %v", err)
+	}
+}
+
+// BenchmarkFrame10k compares building the frame of a 10k metric packet with
+// DataLen and a second marshal against the single marshal of the send path.
+func BenchmarkFrame10k(b *testing.B) {
+	metrics := make([]*Metric, 10000)
+	for i := range metrics {
+		metrics[i] = NewMetric("zabbixTrapper1", fmt.Sprintf("item[%d]", i), fmt.Sprintf("%d.5", i), false)
+	}
+	packet := NewPacket(metrics, false)
+
+	b.Run("DataLen", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, _ := json.Marshal(packet)
+			frame := append([]byte(zabbixHeader), packet.DataLen()...)
+			_ = append(frame, data...)
+		}
+	})
+
+	b.Run("Encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := packet.Encode(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestNewMetricTyped(t *testing.T) {
+	ts := time.Unix(1700000000, 123)
+	tests := []struct {
+		metric   *Metric
+		expected string
+	}{
+		{NewMetricFloat("h", "k", 0.1, false), "0.1"},
+		{NewMetricFloat("h", "k", 1.5e6, false), "1500000"},
+		{NewMetricFloat("h", "k", -2.25, false), "-2.25"},
+		{NewMetricFloat("h", "k", 3, false), "3"},
+		{NewMetricFloat("h", "k", 1e-7, false), "0.0000001"},
+		{NewMetricInt("h", "k", 42, false), "42"},
+		{NewMetricInt("h", "k", -9223372036854775808, false), "-9223372036854775808"},
+		{NewMetricBool("h", "k", true, false), "1"},
+		{NewMetricBool("h", "k", false, false), "0"},
+	}
+	for _, tt := range tests {
+		if tt.metric.Value != tt.expected {
+			t.Errorf("expected value %q, got %q", tt.expected, tt.metric.Value)
+		}
+	}
+
+	m := NewMetricInt("zabbixAgent1", "count", 7, true, ts)
+	expected := NewMetric("zabbixAgent1", "count", "7", true, ts)
+	if m.Host != expected.Host || m.Key != expected.Key || m.Value != expected.Value || m.Active != expected.Active || m.Clock != expected.Clock || m.NS != expected.NS {
+		t.Errorf("expected %+v, got %+v", expected, m)
+	}
+}
+
+func TestSendMetricsR(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	s := NewSender(mock.address)
+	r := s.SendMetricsR([]*Metric{
+		NewMetric("zabbixTrapper1", "ping", "13", false),
+		NewMetric("zabbixTrapper1", "pong", "14", false),
+	})
+	if err := r.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Active != nil {
+		t.Errorf("no active metrics: expected nil Active, got %+v", r.Active)
+	}
+	if r.Trapper == nil {
+		t.Fatal("expected a trapper response")
+	}
+	if info, _ := r.Trapper.GetInfo(); info.Processed != 2 {
+		t.Errorf("expected 2 processed, got %q", r.Trapper.Info)
+	}
+
+	// Both categories fail against a closed port, Err joins them
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := NewSender(l.Addr().String())
+	l.Close()
+	r = dead.SendMetricsR([]*Metric{
+		NewMetric("zabbixTrapper1", "ping", "13", false),
+		NewMetric("zabbixTrapper1", "ping", "13", true),
+	})
+	var sendErr *SendError
+	if r.ActiveErr == nil || r.TrapperErr == nil || !errors.As(r.Err(), &sendErr) {
+		t.Fatalf("expected both categories to fail, got %v / %v", r.ActiveErr, r.TrapperErr)
+	}
+	if r.Active == nil || r.Trapper == nil {
+		t.Error("expected both responses set for attempted categories")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	// serve answers each request with the next of responses; an empty one closes the connection
+	serve := func(responses ...string) string {
+		mock := newMockZabbixServer(t)
+		t.Cleanup(mock.Close)
+		go func() {
+			for _, jsonResp := range responses {
+				conn, err := mock.listener.Accept()
+				if err != nil {
+					return
+				}
+				if _, err := mock.readZabbixRequest(conn); err == nil && jsonResp != "" {
+					mock.writeZabbixResponse(conn, jsonResp)
+				}
+				conn.Close()
+			}
+		}()
+		return mock.address
+	}
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+	if _, err := NewSender(dead).Send(packet); !errors.Is(err, ErrAllHostsFailed) {
+		t.Errorf("expected ErrAllHostsFailed, got %v", err)
+	}
+	partial := &SendError{Hosts: 2, Attempts: []HostAttempt{{Host: dead, Err: syscall.ECONNREFUSED}}}
+	if errors.Is(partial, ErrAllHostsFailed) {
+		t.Error("a failure of some of the hosts is not a failure of all hosts")
+	}
+	_, err = NewSenderHosts(nil).Send(packet)
+	if err == nil || !strings.Contains(err.Error(), "no hosts configured") || errors.Is(err, ErrAllHostsFailed) {
+		t.Errorf("expected a no hosts error, got %v", err)
+	}
+
+	if _, err := NewSender(serve("")).Send(packet); !errors.Is(err, ErrResponseTooShort) {
+		t.Errorf("closed without response: expected ErrResponseTooShort, got %v", err)
+	}
+	if _, err := responseData([]byte("ZBXD\x01"), "host"); !errors.Is(err, ErrResponseTooShort) {
+		t.Errorf("expected ErrResponseTooShort, got %v", err)
+	}
+
+	if _, err := responseData([]byte("HTTP/1.1 400 Bad Request"), "host"); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("expected ErrInvalidHeader, got %v", err)
+	}
+
+	_, err = NewSender(serve(`{"response":"failed","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000030"}`)).Send(packet)
+	var rejected *ServerRejectedError
+	if !errors.Is(err, ErrServerReported) || !errors.As(err, &rejected) {
+		t.Fatalf("expected ErrServerReported, got %v", err)
+	}
+	if rejected.Response.Info != "processed: 0; failed: 1; total: 1; seconds spent: 0.000030" {
+		t.Errorf("expected the server info, got %q", rejected.Response.Info)
+	}
+	if errors.Is(err, ErrAllHostsFailed) {
+		t.Error("a rejection is not a failure of all hosts")
+	}
+
+	notFound := `{"response":"failed","info":"host [prueba] not found"}`
+	err = NewSender(serve(notFound, notFound)).RegisterHost("prueba", "prueba")
+	if !errors.Is(err, ErrRegistrationFailed) || !errors.Is(err, ErrServerReported) {
+		t.Errorf("expected ErrRegistrationFailed, got %v", err)
+	}
+}
+
+// TestSendConcurrentPrimaryHost shares one Sender across goroutines, run with -race.
+func TestSendConcurrentPrimaryHost(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+	var received int32
+	go serveBroadcastMock(mock, &received)
+
+	dead := newMockZabbixServer(t)
+	dead.Close()
+
+	s := NewSenderHosts([]string{dead.address, mock.address})
+	const sends = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, sends)
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", strconv.Itoa(i), false)}, false))
+			errs <- err
+			s.Config()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&received); n != sends {
+		t.Errorf("expected %d metrics received, got %d", sends, n)
+	}
+	if primary := s.primaryHost(); primary != mock.address {
+		t.Errorf("expected %s cached, got %q", mock.address, primary)
+	}
+}
+
+func TestNewMetricsWithTime(t *testing.T) {
+	now := time.Now()
+	m := NewMetric("zabbixAgent1", "ping", "13", false, now)
+
+	if m.Clock != now.Unix() {
+		t.Errorf("Clock: expected %d, got %d", now.Unix(), m.Clock)
+	}
+	if m.NS != now.Nanosecond() {
+		t.Errorf("NS: expected %d, got %d", now.Nanosecond(), m.NS)
+	}
+}
+
+func TestNewTrapperActiveMetrics(t *testing.T) {
+	now := time.Now()
+	values := map[string]string{"cpu.load": "0.42", "mem.free": "1024", "status": "ok"}
+
+	for _, active := range []bool{false, true} {
+		metrics := NewTrapperMetrics("zabbixTrapper1", values, now)
+		if active {
+			metrics = NewActiveMetrics("zabbixTrapper1", values, now)
+		}
+
+		if len(metrics) != len(values) {
+			t.Fatalf("active=%v: expected %d metrics, got %d", active, len(values), len(metrics))
+		}
+		seen := make(map[string]bool)
+		for _, m := range metrics {
+			if m.Host != "zabbixTrapper1" || m.Active != active {
+				t.Errorf("active=%v: unexpected host/type in %+v", active, m)
+			}
+			if m.Clock != now.Unix() || m.NS != now.Nanosecond() {
+				t.Errorf("active=%v: unexpected clock %d.%d for %s", active, m.Clock, m.NS, m.Key)
+			}
+			if value, ok := values[m.Key]; !ok || value != m.Value {
+				t.Errorf("active=%v: unexpected metric %s=%s", active, m.Key, m.Value)
+			}
+			seen[m.Key] = true
+		}
+		if len(seen) != len(values) {
+			t.Errorf("active=%v: expected %d distinct keys, got %d", active, len(values), len(seen))
+		}
+	}
+}
+
+func TestNewMetricReader(t *testing.T) {
+	value := strings.Repeat("line <1> & \"quoted\"\tκόσμε 🚀\n", 4000) + "\xff\x00end"
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		reader io.Reader
+	}{
+		{"single read", strings.NewReader(value)},
+		{"one byte reads", iotest.OneByteReader(strings.NewReader(value))},
+		{"half reads", iotest.HalfReader(strings.NewReader(value))},
+	}
+
+	expected, err := json.Marshal(NewPacket([]*Metric{NewMetric("zabbixAgent1", "log[app]", value, true, now)}, true))
+	if err != nil {
+		t.Fatalf("error marshaling string metric: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPacket([]*Metric{NewMetricReader("zabbixAgent1", "log[app]", tt.reader, true, now)}, true)
+
+			got, err := json.Marshal(p)
+			if err != nil {
+				t.Fatalf("error marshaling reader metric: %v", err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("reader metric serialized differently than string metric")
+			}
+
+			// The reader is consumed once, later encodings reuse the cached value
+			again, err := json.Marshal(p)
+			if err != nil {
+				t.Fatalf("error re-marshaling reader metric: %v", err)
+			}
+			if !bytes.Equal(again, expected) {
+				t.Fatalf("second encoding of reader metric differs")
+			}
+		})
+	}
+}
+
+func TestNewMetricReaderCopies(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	values := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if request, err := mock.readZabbixRequest(conn); err == nil {
+				values <- request.Data[0].Value
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	// Transforms work on a copy, a resend of the caller's metric must still carry the value
+	s := NewSender(mock.address)
+	s.Transforms = []func(*Metric) *Metric{func(m *Metric) *Metric { return m }}
+	m := NewMetricReader("zabbixTrapper1", "log", strings.NewReader("started"), false)
+	for i := 0; i < 2; i++ {
+		if _, errActive, _, errTrapper := s.SendMetrics([]*Metric{m}); errActive != nil || errTrapper != nil {
+			t.Fatalf("send %d: unexpected error: %v / %v", i, errActive, errTrapper)
+		}
+		if v := <-values; v != "started" {
+			t.Errorf("send %d: expected value started, got %q", i, v)
+		}
+	}
+
+	// Copies are encoded concurrently, run with -race
+	m = NewMetricReader("zabbixTrapper1", "log", strings.NewReader("started"), false)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := *m
+			if data, err := json.Marshal(&c); err != nil || !strings.Contains(string(data), `"value":"started"`) {
+				t.Errorf("unexpected encoding %s: %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewPacketWithTime(t *testing.T) {
+	now := time.Now()
+
+	m1 := NewMetric("zabbixAgent1", "ping", "13", false, time.Now())
+	m2 := NewMetric("zabbixAgent2", "pong", "42", true, time.Now())
+
+	p := NewPacket([]*Metric{m1, m2}, false, now)
+
+	if p.Clock != now.Unix() {
+		t.Errorf("Clock: expected %d, got %d", now.Unix(), p.Clock)
+	}
+	if p.NS != now.Nanosecond() {
+		t.Errorf("NS: expected %d, got %d", now.Nanosecond(), p.NS)
+	}
+}
+
+func TestNewDataPacket(t *testing.T) {
+	now := time.Now()
+	m := NewMetric("zabbixProxy1", "ping", "13", false)
+
+	p := NewDataPacket(RequestProxyData, []*Metric{m}, now)
+
+	if p.Request != "proxy data" {
+		t.Errorf("Request: expected 'proxy data', got '%s'", p.Request)
+	}
+	if len(p.Data) != 1 || p.Data[0] != m {
+		t.Errorf("Data: expected the given metric, got %v", p.Data)
+	}
+	if p.Clock != now.Unix() {
+		t.Errorf("Clock: expected %d, got %d", now.Unix(), p.Clock)
+	}
+	if p.NS != now.Nanosecond() {
+		t.Errorf("NS: expected %d, got %d", now.Nanosecond(), p.NS)
+	}
+
+	if p := NewPacket([]*Metric{m}, true); p.Request != RequestAgentData {
+		t.Errorf("NewPacket active: expected '%s', got '%s'", RequestAgentData, p.Request)
+	}
+	if p := NewPacket([]*Metric{m}, false); p.Request != RequestSenderData {
+		t.Errorf("NewPacket trapper: expected '%s', got '%s'", RequestSenderData, p.Request)
+	}
+}
+
+func TestNormalizeHost_DefaultPort(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{} // can be string or []string
+		expected []string
+	}{
+		{
+			name:     "single host without port",
+			input:    "zabbix-proxy",
+			expected: []string{"zabbix-proxy:10051"},
+		},
+		{
+			name:     "single host with port",
+			input:    "zabbix-proxy:10051",
+			expected: []string{"zabbix-proxy:10051"},
+		},
+		{
+			name:     "multiple hosts mixed",
+			input:    []string{"zabbix-proxy1:10051", "zabbix-proxy2"},
+			expected: []string{"zabbix-proxy1:10051", "zabbix-proxy2:10051"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s *Sender
+
+			switch v := tt.input.(type) {
+			case string:
+				s = NewSender(v)
+			case []string:
+				s = NewSenderHosts(v)
+			}
+
+			if len(s.Hosts) != len(tt.expected) {
+				t.Fatalf("expected %d hosts, got %d", len(tt.expected), len(s.Hosts))
+			}
+
+			for i, expected := range tt.expected {
+				if s.Hosts[i] != expected {
+					t.Errorf("host[%d]: expected %s, got %s", i, expected, s.Hosts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSendIgnoresStalePrimaryHost(t *testing.T) {
+	stale := newMockZabbixServer(t)
+	defer stale.Close()
+	current := newMockZabbixServer(t)
+	defer current.Close()
+
+	staleAccepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := stale.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		staleAccepted <- struct{}{}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := current.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := current.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- current.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(stale.address)
+	s.PrimaryHost = stale.address
+
+	// Reconfigure: the cached primary is no longer a member of Hosts
+	s.Hosts = []string{current.address}
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err != nil {
+		t.Fatalf("error sending after reconfiguration: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	if s.PrimaryHost != current.address {
+		t.Errorf("PrimaryHost: expected %s, got %s", current.address, s.PrimaryHost)
+	}
+
+	select {
+	case <-staleAccepted:
+		t.Error("stale primary host should not be tried")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendEmptyDataPacket(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		// Only the registration packet reaches the server
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		if request.Request != "active checks" {
+			done <- fmt.Errorf("expected 'active checks', got '%s'", request.Request)
+			return
+		}
+
+		done <- mock.writeZabbixResponse(conn, `{"response":"success","data":[]}`)
+	}()
+
+	s := NewSender(mock.address)
+
+	if _, err := s.Send(NewPacket(nil, false)); !errors.Is(err, ErrEmptyPacket) {
+		t.Errorf("expected ErrEmptyPacket for empty sender data packet, got %v", err)
+	}
+
+	// Without metrics SendMetrics sends nothing
+	if _, errActive, _, errTrapper := s.SendMetrics(nil); errActive != nil || errTrapper != nil {
+		t.Errorf("expected SendMetrics without metrics to be a no-op, got %v / %v", errActive, errTrapper)
+	}
+	if errActive, errTrapper := s.SendMetricsNoWait([]*Metric{}); errActive != nil || errTrapper != nil {
+		t.Errorf("expected SendMetricsNoWait without metrics to be a no-op, got %v / %v", errActive, errTrapper)
+	}
+
+	if err := s.RegisterHost("prueba", "prueba"); err != nil {
+		t.Fatalf("registration packet without data should be sent: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestSendRedirectIPv6(t *testing.T) {
+	target, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer target.Close()
+
+	first := newMockZabbixServer(t)
+	defer first.Close()
+
+	done := make(chan error, 2)
+
+	go func() {
+		conn, err := first.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := first.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+		jsonResp := fmt.Sprintf(`{"response":"failed","redirect":{"revision":7,"address":"%s"}}`, target.Addr().String())
+		done <- first.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := first.readZabbixRequest(conn); err != nil {
+			done <- err
+			return
+		}
+		jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+		done <- first.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSender(first.address)
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error following IPv6 redirect to %s: %v", target.Addr(), err)
+	}
+	if res.Response != "success" {
+		t.Errorf("Response: expected success, got %s", res.Response)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Mock server error: %v", err)
+		}
+	}
+}
+
+func TestSendRedirectsInGroupOnly(t *testing.T) {
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	target := newMockZabbixServer(t)
+	defer target.Close()
+
+	serve := func(mock *mockZabbixServer, jsonResp string, requests *int32) {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(requests, 1)
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+		}
+	}
+	var redirected, delivered int32
+	go serve(redirecting, fmt.Sprintf(`{"response":"failed","redirect":{"revision":1,"address":"%s"}}`, target.address), &redirected)
+	go serve(target, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`, &delivered)
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	// In group: the target is one of the hosts
+	s := NewSenderHosts([]string{redirecting.address, target.address})
+	s.RedirectsInGroupOnly = true
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("expected in-group redirect to be followed: %v", err)
+	}
+	if n := atomic.LoadInt32(&delivered); n != 1 {
+		t.Fatalf("expected 1 delivery through the redirect, got %d", n)
+	}
+
+	// Out of group: the target is unknown
+	s = NewSender(redirecting.address)
+	s.RedirectsInGroupOnly = true
+	_, err := s.Send(packet)
+	if !errors.Is(err, ErrRedirectNotAllowed) {
+		t.Fatalf("expected ErrRedirectNotAllowed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&delivered); n != 1 {
+		t.Errorf("out-of-group redirect should not be followed, got %d deliveries", n)
+	}
+
+	// Allowlisted by CIDR
+	s.RedirectAllowlist = []string{"127.0.0.0/8"}
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("expected allowlisted redirect to be followed: %v", err)
+	}
+	if n := atomic.LoadInt32(&delivered); n != 2 {
+		t.Errorf("expected 2 deliveries, got %d", n)
+	}
+}
+
+func TestSendRedirectLoop(t *testing.T) {
+	proxy1 := newMockZabbixServer(t)
+	defer proxy1.Close()
+	proxy2 := newMockZabbixServer(t)
+	defer proxy2.Close()
+
+	serve := func(mock *mockZabbixServer, to string, requests *int32) {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(requests, 1)
+				mock.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"failed","redirect":{"revision":1,"address":"%s"}}`, to))
+			}
+			conn.Close()
+		}
+	}
+	var requests1, requests2 int32
+	go serve(proxy1, proxy2.address, &requests1)
+	go serve(proxy2, proxy1.address, &requests2)
+
+	s := NewSender(proxy1.address)
+	s.MaxRedirects = 10
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.Is(err, ErrRedirectLoop) {
+		t.Fatalf("expected ErrRedirectLoop, got %v", err)
+	}
+	cycle := fmt.Sprintf("%s -> %s -> %s", proxy1.address, proxy2.address, proxy1.address)
+	if !strings.Contains(err.Error(), cycle) {
+		t.Errorf("expected the cycle %q in %v", cycle, err)
+	}
+	if n1, n2 := atomic.LoadInt32(&requests1), atomic.LoadInt32(&requests2); n1 != 1 || n2 != 1 {
+		t.Errorf("expected one request per proxy before the loop is detected, got %d and %d", n1, n2)
+	}
+}
+
+func TestSendUpdateHost(t *testing.T) {
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	target := newMockZabbixServer(t)
+	defer target.Close()
+
+	serve := func(mock *mockZabbixServer, jsonResp string, requests *int32) {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(requests, 1)
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+		}
+	}
+	var redirected, delivered int32
+	go serve(redirecting, fmt.Sprintf(`{"response":"failed","redirect":{"revision":1,"address":"%s"}}`, target.address), &redirected)
+	go serve(target, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`, &delivered)
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	// Without UpdateHost the host the redirect chain started at is cached
+	s := NewSender(redirecting.address)
+	for i := 0; i < 2; i++ {
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if s.PrimaryHost != redirecting.address {
+			t.Errorf("send %d: expected %s cached, got %q", i, redirecting.address, s.PrimaryHost)
+		}
+	}
+	if n := atomic.LoadInt32(&redirected); n != 2 {
+		t.Errorf("expected every send redirected, got %d redirects", n)
+	}
+
+	// With UpdateHost the final host is cached and later sends go straight to it
+	atomic.StoreInt32(&redirected, 0)
+	s = NewSender(redirecting.address)
+	s.UpdateHost = true
+	for i := 0; i < 3; i++ {
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if s.PrimaryHost != target.address {
+			t.Errorf("send %d: expected %s cached, got %q", i, target.address, s.PrimaryHost)
+		}
+	}
+	if n := atomic.LoadInt32(&redirected); n != 1 {
+		t.Errorf("expected only the first send redirected, got %d redirects", n)
+	}
+	if n := atomic.LoadInt32(&delivered); n != 5 {
+		t.Errorf("expected 5 deliveries, got %d", n)
+	}
+}
+
+func TestSendUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	mock := &mockZabbixServer{listener: listener, address: "unix://" + path, t: t}
+	defer mock.Close()
+
+	received := make(chan *ZabbixRequest, 1)
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			return
+		}
+		received <- request
+		mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	}()
+
+	s := NewSender(mock.address)
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %+v", res)
+	}
+	if request := <-received; len(request.Data) != 1 || request.Data[0].Key != "ping" || request.Data[0].Value != "13" {
+		t.Errorf("unexpected request %+v", request)
+	}
+}
+
+func TestParseHostPortIPv6(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"::1", "[::1]:10051"},
+		{"2001:db8::1", "[2001:db8::1]:10051"},
+		{"[2001:db8::1]", "[2001:db8::1]:10051"},
+		{"[2001:db8::1]:10052", "[2001:db8::1]:10052"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHostPort(tt.input)
+		if err != nil {
+			t.Errorf("parseHostPort(%s): unexpected error %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseHostPort(%s): expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestNormalizeHostIPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"bare IPv6 loopback", "::1", "[::1]:10051"},
+		{"bare IPv6", "2001:db8::1", "[2001:db8::1]:10051"},
+		{"bare IPv4 mapped IPv6", "::ffff:10.0.0.1", "[::ffff:10.0.0.1]:10051"},
+		{"bracketed IPv6 without port", "[2001:db8::1]", "[2001:db8::1]:10051"},
+		{"bracketed IPv6 with port", "[2001:db8::1]:10051", "[2001:db8::1]:10051"},
+		{"bracketed IPv6 with other port", "[2001:db8::1]:10052", "[2001:db8::1]:10052"},
+		{"IPv4 without port", "10.0.0.1", "10.0.0.1:10051"},
+		{"IPv4 with port", "10.0.0.1:10052", "10.0.0.1:10052"},
+		{"hostname without port", "zabbix-proxy", "zabbix-proxy:10051"},
+		{"hostname with port", "zabbix-proxy:10052", "zabbix-proxy:10052"},
+		{"surrounding spaces", " ::1 ", "[::1]:10051"},
+		{"unix socket", "unix:///run/zabbix/proxy.sock", "unix:///run/zabbix/proxy.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeHost(tt.input); got != tt.expected {
+				t.Errorf("normalizeHost(%q): expected %s, got %s", tt.input, tt.expected, got)
+			}
+			if s := NewSender(tt.input); s.Hosts[0] != tt.expected {
+				t.Errorf("NewSender(%q): expected host %s, got %s", tt.input, tt.expected, s.Hosts[0])
+			}
+		})
+	}
+}
+
+// serveCompressionMock records whether each request was compressed. A server
+// without compression support drops compressed requests without answering.
+func serveCompressionMock(mock *mockZabbixServer, capable bool, modes chan<- bool) {
+	for {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		modes <- request.Compressed
+
+		if !request.Compressed || capable {
+			mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+		}
+		conn.Close()
+	}
+}
+
+func TestSenderCompressAuto(t *testing.T) {
+	capable := newMockZabbixServer(t)
+	defer capable.Close()
+	legacy := newMockZabbixServer(t)
+	defer legacy.Close()
+
+	capableModes := make(chan bool, 10)
+	legacyModes := make(chan bool, 10)
+	go serveCompressionMock(capable, true, capableModes)
+	go serveCompressionMock(legacy, false, legacyModes)
+
+	s := NewSenderHosts([]string{legacy.address, capable.address})
+	s.CompressAuto = true
+
+	sendTo := func(host string) {
+		t.Helper()
+		s.PrimaryHost = host
+		packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)}, false)
+		if _, err := s.Send(packet); err != nil {
+			t.Fatalf("error sending to %s: %v", host, err)
+		}
+	}
+	expectModes := func(name string, modes chan bool, expected ...bool) {
+		t.Helper()
+		for i, e := range expected {
+			if got := <-modes; got != e {
+				t.Errorf("%s request %d: expected compressed=%v, got %v", name, i, e, got)
+			}
+		}
+		select {
+		case got := <-modes:
+			t.Errorf("%s: unexpected extra request (compressed=%v)", name, got)
+		default:
+		}
+	}
+
+	// First send probes with compression and falls back to plain
+	sendTo(legacy.address)
+	expectModes("legacy", legacyModes, true, false)
+	sendTo(capable.address)
+	expectModes("capable", capableModes, true)
+
+	// The results are cached per host
+	sendTo(legacy.address)
+	expectModes("legacy", legacyModes, false)
+	sendTo(capable.address)
+	expectModes("capable", capableModes, true)
+}
+
+func TestSenderCompressAutoTimeout(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	// A slow server: the compressed request is received but not answered in time
+	modes := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if request, err := mock.readZabbixRequest(conn); err == nil {
+				modes <- request.Compressed
+				time.Sleep(200 * time.Millisecond)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.CompressAuto = true
+	s.ReadTimeout = 50 * time.Millisecond
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "1", false)}, false)
+	if _, err := s.Send(packet); err == nil {
+		t.Fatal("expected a read timeout")
+	}
+
+	// The packet may have been stored, it is not sent again uncompressed
+	if got := <-modes; !got {
+		t.Errorf("expected a compressed probe")
+	}
+	select {
+	case got := <-modes:
+		t.Errorf("unexpected resend after a timeout (compressed=%v)", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if compress, probe := s.compressFor(mock.address); !compress || !probe {
+		t.Errorf("expected the host to be probed again, got compress=%v probe=%v", compress, probe)
+	}
+}
+
+func TestDecodeResponseCompressed(t *testing.T) {
+	jsonResp := `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`
+
+	res, err := decodeResponse(compressedFrame([]byte(jsonResp)), "test")
+	if err != nil {
+		t.Fatalf("error decoding compressed response: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected 'success', got '%s'", res.Response)
+	}
+}
+
+func TestSenderResolverFailover(t *testing.T) {
+	live := newMockZabbixServer(t)
+	defer live.Close()
+	dead := newMockZabbixServer(t)
+	deadAddress := dead.address
+	dead.Close()
+
+	done := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := live.listener.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			if _, err := live.readZabbixRequest(conn); err != nil {
+				conn.Close()
+				done <- err
+				return
+			}
+			done <- live.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+			conn.Close()
+		}
+	}()
+
+	var resolved []string
+	s := NewSender("zabbix-proxies")
+	s.Hosts = []string{"zabbix-proxies"} // logical name, not host:port
+	s.Resolver = func(logical string) ([]string, error) {
+		resolved = append(resolved, logical)
+		if logical != "zabbix-proxies" {
+			return nil, fmt.Errorf("unknown service %s", logical)
+		}
+		return []string{deadAddress, live.address}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+		if err != nil {
+			t.Fatalf("send %d: expected failover to the second address: %v", i, err)
+		}
+		if res.Response != "success" {
+			t.Errorf("send %d: expected success, got %s", i, res.Response)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Mock server error: %v", err)
+		}
+		if s.PrimaryHost != live.address {
+			t.Errorf("send %d: expected resolved address %s cached as primary, got %s", i, live.address, s.PrimaryHost)
+		}
+	}
+	if len(resolved) != 2 {
+		t.Errorf("expected the resolver to be called on each send, got %d calls", len(resolved))
+	}
+
+	s.Hosts = []string{"unknown-service"}
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)); err == nil || !strings.Contains(err.Error(), "unknown service") {
+		t.Errorf("expected the resolver error, got %v", err)
+	}
+}
+
+func TestSendErrorDetail(t *testing.T) {
+	redirecting := newMockZabbixServer(t)
+	defer redirecting.Close()
+	dead := newMockZabbixServer(t)
+	deadAddress := dead.address
+	dead.Close()
+	deadRedirect := newMockZabbixServer(t)
+	deadRedirectAddress := deadRedirect.address
+	deadRedirect.Close()
+
+	go func() {
+		conn, err := redirecting.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := redirecting.readZabbixRequest(conn); err != nil {
+			return
+		}
+		jsonResp := fmt.Sprintf(`{"response":"failed","redirect":{"revision":1,"address":"%s"}}`, deadRedirectAddress)
+		redirecting.writeZabbixResponse(conn, jsonResp)
+	}()
+
+	s := NewSenderHosts([]string{deadAddress, redirecting.address})
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("expected *SendError, got %T: %v", err, err)
+	}
+	if len(sendErr.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(sendErr.Attempts))
+	}
+	if got := sendErr.Attempts[1].Redirects; len(got) != 1 || got[0] != deadRedirectAddress {
+		t.Errorf("expected redirect chain [%s], got %v", deadRedirectAddress, got)
+	}
+
+	detail := fmt.Sprintf("%+v", err)
+	for _, want := range []string{
+		deadAddress + ": sendOnce to " + deadAddress + " failed: connecting",
+		redirecting.address + " -> " + deadRedirectAddress + ": sendOnce to " + deadRedirectAddress + " failed: connecting",
+	} {
+		if !strings.Contains(detail, want) {
+			t.Errorf("expected detail to contain %q, got:\n%s", want, detail)
+		}
+	}
+	if detail != sendErr.Detail() {
+		t.Errorf("expected %%+v to print Detail")
+	}
+	if !strings.HasPrefix(err.Error(), "all 2 hosts failed: ") || strings.Contains(err.Error(), "\n") {
+		t.Errorf("unexpected single line message %q", err.Error())
+	}
+}
+
+func TestSenderTransforms(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan *ZabbixRequest, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		received <- request
+		done <- mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	}()
+
+	s := NewSender(mock.address)
+	s.Transforms = []func(*Metric) *Metric{
+		func(m *Metric) *Metric {
+			m.Key = "app." + m.Key
+			return m
+		},
+		func(m *Metric) *Metric {
+			if strings.HasSuffix(m.Key, ".debug") {
+				return nil
+			}
+			return m
+		},
+	}
+
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "requests", "10", false),
+		NewMetric("zabbixTrapper1", "debug", "1", false),
+		NewMetric("zabbixTrapper1", "errors", "0", false),
+	}
+	if _, _, _, err := s.SendMetrics(metrics); err != nil {
+		t.Fatalf("error sending metrics: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	request := <-received
+	if len(request.Data) != 2 || request.Data[0].Key != "app.requests" || request.Data[1].Key != "app.errors" {
+		t.Errorf("expected renamed metrics without the dropped one, got %+v", request.Data)
+	}
+	if metrics[0].Key != "requests" {
+		t.Errorf("caller's metric should not be modified, got key %s", metrics[0].Key)
+	}
+}
+
+func TestSendMetricsSetActiveOrder(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	received := make(chan *ZabbixRequest, 2)
+	done := make(chan error, 1)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			request, err := mock.readZabbixRequest(conn)
+			if err != nil {
+				conn.Close()
+				done <- err
+				return
+			}
+			received <- request
+			jsonResp := fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, len(request.Data), len(request.Data))
+			if err := mock.writeZabbixResponse(conn, jsonResp); err != nil {
+				conn.Close()
+				done <- err
+				return
+			}
+			conn.Close()
+		}
+		done <- nil
+	}()
+
+	metrics := []*Metric{
+		NewMetric("zabbixAgent1", "t1", "1", false),
+		NewMetric("zabbixAgent1", "a1", "2", false),
+		NewMetric("zabbixAgent1", "t2", "3", true),
+		NewMetric("zabbixAgent1", "a2", "4", false),
+		NewMetric("zabbixAgent1", "t3", "5", false),
+	}
+	// Flags changed after construction are honored
+	metrics[1].SetActive(true)
+	metrics[2].SetActive(false)
+	metrics[3].SetActive(true)
+
+	s := NewSender(mock.address)
+	_, errActive, _, errTrapper := s.SendMetrics(metrics)
+	if errActive != nil || errTrapper != nil {
+		t.Fatalf("error sending metrics: %v, %v", errActive, errTrapper)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+
+	expected := map[string][]string{
+		"sender data": {"t1", "t2", "t3"},
+		"agent data":  {"a1", "a2"},
+	}
+	for i := 0; i < 2; i++ {
+		request := <-received
+		var keys []string
+		for _, d := range request.Data {
+			keys = append(keys, d.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(expected[request.Request], ",") {
+			t.Errorf("%s: expected keys %v in order, got %v", request.Request, expected[request.Request], keys)
+		}
+	}
+}
+
+func TestSendRetryOnFailedInfo(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	responses := []string{
+		`{"response":"failed","info":"value cache is busy"}`,
+		`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`,
+		`{"response":"failed","info":"host [prueba] not found"}`,
+	}
+	var requests int32
+	go func() {
+		for _, jsonResp := range responses {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(&requests, 1)
+				mock.writeZabbixResponse(conn, jsonResp)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.FailedInfoRetries = 3
+	s.FailedInfoRetryDelay = 10 * time.Millisecond
+	s.RetryOnFailedInfo = func(info string) bool {
+		return strings.Contains(info, "value cache is busy")
+	}
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+	res, err := s.Send(packet)
+	if err != nil {
+		t.Fatalf("expected the transient failure to be retried: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success after retry, got %s", res.Response)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+
+	// Failures the predicate does not match are not retried
+	var rejected *ServerRejectedError
+	if _, err := s.Send(packet); !errors.As(err, &rejected) {
+		t.Fatalf("expected ServerRejectedError, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected no retry of a permanent failure, got %d requests", n)
+	}
+}
+
+func TestSendSuccessIfAnyProcessed(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	var requests int32
+	go func() {
+		for {
+			conn, err := mock.listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := mock.readZabbixRequest(conn); err == nil {
+				atomic.AddInt32(&requests, 1)
+				mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 5; failed: 2; total: 7; seconds spent: 0.000030"}`)
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSender(mock.address)
+	s.FailedInfoRetryDelay = time.Millisecond
+	s.RetryOnFailedInfo = func(info string) bool { return true }
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
+
+	// By default the failed items count as a failure, retried as transient
+	if _, err := s.Send(packet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected the failed items to be retried, got %d requests", n)
+	}
+
+	s.SuccessIfAnyProcessed = true
+	res, err := s.Send(packet)
+	if err != nil {
+		t.Fatalf("partial batch should be accepted: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected no retry of an accepted partial batch, got %d requests", n-2)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected the response as sent, got %q", res.Response)
+	}
+	info, err := res.GetInfo()
+	if err != nil {
+		t.Fatalf("error parsing info: %v", err)
+	}
+	if info.Processed != 5 || info.Failed != 2 {
+		t.Errorf("expected processed 5 and failed 2, got %+v", info)
+	}
+}
+
+func TestSenderSampleRate(t *testing.T) {
+	metrics := make([]*Metric, 10000)
+	for i := range metrics {
+		metrics[i] = NewMetric(fmt.Sprintf("host%d", i%10), fmt.Sprintf("debug[%d]", i), "1", false)
+	}
+
+	s := NewSender("localhost")
+	if kept := s.prepareMetrics(metrics); len(kept) != len(metrics) {
+		t.Fatalf("default rate should keep all metrics, kept %d", len(kept))
+	}
+
+	s.SampleRate = 0.5
+	kept := s.prepareMetrics(metrics)
+	if len(kept) < 4500 || len(kept) > 5500 {
+		t.Errorf("expected roughly half of %d series kept, got %d", len(metrics), len(kept))
+	}
+
+	// The same series are kept on every send
+	again := s.prepareMetrics(metrics)
+	if len(again) != len(kept) {
+		t.Fatalf("expected the same %d series kept, got %d", len(kept), len(again))
+	}
+	for i := range kept {
+		if kept[i] != again[i] {
+			t.Fatalf("series %s/%s kept inconsistently", kept[i].Host, kept[i].Key)
+		}
+	}
+}
+
+func TestSendRequestFullyReceivedBeforeResponse(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	const count = 64
+	value := strings.Repeat("x", 64*1024) // several MB in total, many TCP segments
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		// readZabbixRequest only returns once the declared length has arrived
+		request, err := mock.readZabbixRequest(conn)
+		if err != nil {
+			done <- err
+			return
+		}
+		if len(request.Data) != count || request.Data[count-1].Value != value {
+			done <- fmt.Errorf("incomplete request: %d metrics", len(request.Data))
+			return
+		}
+		done <- mock.writeZabbixResponse(conn, fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: 0; total: %d; seconds spent: 0.000030"}`, count, count))
+	}()
+
+	metrics := make([]*Metric, count)
+	for i := range metrics {
+		metrics[i] = NewMetric("zabbixTrapper1", fmt.Sprintf("log[%d]", i), value, false)
+	}
+
+	s := NewSender(mock.address)
+	if _, err := s.Send(NewPacket(metrics, false)); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Mock server error: %v", err)
+	}
+}
+
+func TestSendKeepAliveServer(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			return
+		}
+		mock.writeZabbixResponse(conn, `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+		// Keep the connection open for reuse
+		<-release
+	}()
+
+	s := NewSender(mock.address)
+	s.ReadTimeout = 5 * time.Second
+
+	start := time.Now()
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success, got %s", res.Response)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("send waited %v for the server to close the connection", elapsed)
+	}
+}
+
+func TestSendTruncatedResponse(t *testing.T) {
+	mock := newMockZabbixServer(t)
+	defer mock.Close()
+
+	go func() {
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := mock.readZabbixRequest(conn); err != nil {
+			return
+		}
+
+		// Declare 100 bytes but send only a part of the body
+		body := []byte(`{"response":"success"`)
+		frame := append([]byte("ZBXD\x01"), encodeDataLength(100)...)
+		conn.Write(append(frame, body...))
+	}()
+
+	_, err := NewSender(mock.address).Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.Is(err, ErrTruncatedResponse) {
+		t.Fatalf("expected ErrTruncatedResponse, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "got 21 of 100 bytes") {
+		t.Errorf("expected the byte counts in the error, got %v", err)
+	}
+}
+
+func TestSendInterruptedWriteResendsFullFrame(t *testing.T) {
+	// The first host drops the connection after a part of the frame
+	interrupting := newMockZabbixServer(t)
+	defer interrupting.Close()
+	partial := make(chan int, 1)
+	go func() {
+		conn, err := interrupting.listener.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 64*1024)
+		n, _ := io.ReadFull(conn, buf)
+		partial <- n
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}()
+
+	complete := newMockZabbixServer(t)
+	defer complete.Close()
+	requests := make(chan *ZabbixRequest, 1)
+	go func() {
+		conn, err := complete.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := complete.readZabbixRequest(conn)
+		if err != nil {
+			requests <- nil
+			return
+		}
+		requests <- request
+		complete.writeZabbixResponse(conn, `{"response":"success","info":"processed: 2000; failed: 0; total: 2000; seconds spent: 0.000030"}`)
+	}()
+
+	// Large enough not to fit in the socket buffers of the first connection
+	value := strings.Repeat("x", 4096)
+	metrics := make([]*Metric, 2000)
+	for i := range metrics {
+		metrics[i] = NewMetric("zabbixTrapper1", fmt.Sprintf("item%d", i), value, false)
+	}
+
+	s := NewSenderHosts([]string{interrupting.address, complete.address})
+	if _, err := s.Send(NewPacket(metrics, false)); err != nil {
+		t.Fatalf("expected the send to succeed on the second host: %v", err)
+	}
+
+	if n := <-partial; n == 0 {
+		t.Error("expected the first host to receive a part of the frame")
+	}
+	request := <-requests
+	if request == nil {
+		t.Fatal("second host did not receive a valid frame")
+	}
+	if len(request.Data) != len(metrics) || request.Data[0].Key != "item0" || request.Data[len(metrics)-1].Value != value {
+		t.Errorf("expected the full packet of %d metrics on the second host, got %d", len(metrics), len(request.Data))
+	}
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) { return len(p) / 2, nil }
+
+func TestWriteFrameFlushes(t *testing.T) {
+	frame := []byte("ZBXD\x01frame")
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if err := writeFrame(w, frame); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), frame) {
+		t.Errorf("expected the buffered frame to be flushed, got %q", out.Bytes())
+	}
+
+	if err := writeFrame(shortWriter{}, frame); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("expected io.ErrShortWrite, got %v", err)
+	}
+}
+
+func TestSenderString(t *testing.T) {
+	s := NewSenderHosts([]string{"zabbix-proxy1", "zabbix-proxy2:10052"})
+	s.PrimaryHost = "zabbix-proxy2:10052"
+	s.ClientName = "billing-service/1.4.2"
+
+	for _, format := range []string{"%v", "%s", "%#v"} {
+		out := fmt.Sprintf(format, s)
+
+		for _, expected := range []string{"hosts: 2", "zabbix-proxy1:10051", `primary: "zabbix-proxy2:10052"`, "connect=5s", "compression: false"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%s: expected %q in %s", format, expected, out)
+			}
+		}
+		if strings.Contains(out, "mu:") || strings.Contains(out, "{{") {
+			t.Errorf("%s: internal fields leaked in %s", format, out)
+		}
+	}
+
+	if out := s.String(); !strings.Contains(out, "tls: off, psk: false") {
+		t.Errorf("expected TLS and PSK off in %s", out)
+	}
+	s.TLSConfig = &tls.Config{}
+	s.TLSPSKIdentity, s.TLSPSKKey = "PSK web-01", []byte("0123456789abcdef")
+	if out := s.String(); !strings.Contains(out, "tls: on, psk: true") || strings.Contains(out, "web-01") {
+		t.Errorf("expected TLS and PSK on, without the identity, in %s", out)
+	}
+	s.TLSConfigForHost = func(string) *tls.Config { return nil }
+	if out := s.String(); !strings.Contains(out, "tls: per host") {
+		t.Errorf("expected per host TLS in %s", out)
 	}
 }
 